@@ -0,0 +1,259 @@
+// cmd/analysis.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"geowatch-backend/internal/cache"
+	"geowatch-backend/internal/geeclient"
+	"geowatch-backend/internal/geotiff"
+	"geowatch-backend/internal/pipeline"
+	"geowatch-backend/internal/progress"
+	"geowatch-backend/internal/tiling"
+
+	"github.com/gin-gonic/gin"
+)
+
+// analysisCacheVersion is part of every cache key. Bump it whenever the
+// analysis pipeline changes in a way that alters its results.
+const analysisCacheVersion = 4
+
+// analysisCacheKey is the normalised form of an analysis request that is
+// hashed into its cache key.
+type analysisCacheKey struct {
+	Version   int            `json:"v"`
+	AOI       [][][2]float64 `json:"aoi"`
+	StartDate string         `json:"start"`
+	EndDate   string         `json:"end"`
+	Format    string         `json:"format"`
+	// Pipeline is the parsed pipeline, so that equivalent pipelines share a
+	// key; absent without one.
+	Pipeline json.RawMessage `json:"pipeline,omitempty"`
+	// Tiling holds the settings a tiled result depends on; absent for
+	// analyses made in one piece.
+	Tiling *tilingCacheKey `json:"tiling,omitempty"`
+}
+
+// tilingCacheKey is the part of the tiling configuration that changes the
+// result of a tiled analysis: the tile grid and the mosaic resolution.
+type tilingCacheKey struct {
+	MaxTileSpanDeg float64 `json:"span"`
+	MaxTiles       int     `json:"tiles"`
+	MaxMosaicSize  int     `json:"size"`
+}
+
+// validateAnalysisRequest checks the request against the AOI and date policy,
+// answering with 422 and the list of field errors when it is invalid.
+func validateAnalysisRequest(c *gin.Context, requestData geeclient.AnalysisRequest) bool {
+	err := requestData.Validate(geeclient.DefaultPolicy(), time.Now())
+	if err == nil {
+		return true
+	}
+	var fieldErrs geeclient.ValidationErrors
+	if errors.As(err, &fieldErrs) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid analysis request", "fields": fieldErrs})
+		return false
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid analysis request", "details": err.Error()})
+	return false
+}
+
+// newGEEClient sets up the analysis service client from PYTHON_SERVICE_URL,
+// GEE_TIMEOUT, GEE_MAX_RETRIES, GEE_BREAKER_THRESHOLD and GEE_BREAKER_COOLDOWN.
+func newGEEClient() *geeclient.Client {
+	config := geeclient.DefaultConfig()
+	config.BaseURL = os.Getenv("PYTHON_SERVICE_URL")
+	if d, err := time.ParseDuration(os.Getenv("GEE_TIMEOUT")); err == nil && d > 0 {
+		config.Timeout = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("GEE_MAX_RETRIES")); err == nil && n >= 0 {
+		config.MaxRetries = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("GEE_BREAKER_THRESHOLD")); err == nil && n >= 0 {
+		config.BreakerThreshold = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("GEE_BREAKER_COOLDOWN")); err == nil && d > 0 {
+		config.BreakerCooldown = d
+	}
+	return geeclient.New(config)
+}
+
+// newSyncTimeout reads GEE_SYNC_TIMEOUT, the limit on a whole POST /changes
+// analysis. It defaults to one attempt's timeout, so retries don't keep a
+// client waiting several times as long.
+func newSyncTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("GEE_SYNC_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	if d, err := time.ParseDuration(os.Getenv("GEE_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	return geeclient.DefaultConfig().Timeout
+}
+
+// newAnalysisCache sets up the result cache from CACHE_ENABLED, CACHE_DIR,
+// CACHE_TTL and CACHE_MAX_MB. It returns nil, which disables caching, when
+// turned off or when the directory can't be used.
+func newAnalysisCache() *cache.Cache {
+	if os.Getenv("CACHE_ENABLED") == "false" {
+		return nil
+	}
+	config := cache.Config{
+		Dir:      os.Getenv("CACHE_DIR"),
+		TTL:      30 * 24 * time.Hour,
+		MaxBytes: 1024 << 20,
+	}
+	if config.Dir == "" {
+		config.Dir = "cache"
+	}
+	if d, err := time.ParseDuration(os.Getenv("CACHE_TTL")); err == nil && d >= 0 {
+		config.TTL = d
+	}
+	if mb, err := strconv.ParseInt(os.Getenv("CACHE_MAX_MB"), 10, 64); err == nil && mb >= 0 {
+		config.MaxBytes = mb << 20
+	}
+
+	c, err := cache.New(config)
+	if err != nil {
+		log.Printf("WARNING: Analysis result caching is disabled: %v", err)
+		return nil
+	}
+	return c
+}
+
+// analyze returns the result of an analysis request, from the cache when an
+// equivalent request has been run before and from the Python GEE service
+// otherwise. AOIs too large for one request are split into tiles and
+// mosaicked, and requests with a processing pipeline are analysed by the
+// backend itself (see analyzeWithPipeline). Progress is published through
+// report; the caller reports the final done or error stage. The boolean
+// reports a cache hit.
+func (app *AppState) analyze(ctx context.Context, requestData geeclient.AnalysisRequest, report progress.Reporter) (*cache.Entry, bool, error) {
+	var grid *tiling.Grid
+	if !requestData.HasPipeline() {
+		grid = app.analysisGrid(requestData)
+	}
+	key, err := app.requestCacheKey(requestData, grid != nil)
+	if err != nil {
+		log.Printf("WARNING: Not caching analysis: %v", err)
+	} else if entry, ok := app.Cache.Get(key); ok {
+		return entry, true, nil
+	}
+
+	entry := &cache.Entry{}
+	if requestData.HasPipeline() {
+		if entry, err = app.analyzeWithPipeline(ctx, requestData, report); err != nil {
+			return nil, false, err
+		}
+	} else if grid != nil {
+		if entry, err = app.analyzeTiled(ctx, requestData, grid, report); err != nil {
+			return nil, false, err
+		}
+	} else if requestData.Format == formatGeoTIFF {
+		fmt.Println("Go Backend: Forwarding request to Python GEE service...")
+		report(progress.StageFetching, 10, "Building the before and after composites in Earth Engine")
+		img, metadata, err := app.requestChangeMagnitude(ctx, requestData)
+		if err != nil {
+			return nil, false, err
+		}
+		report(progress.StageRendering, 90, "Writing the GeoTIFF")
+		entry.ContentType = geoTIFFContentType
+		entry.Metadata = encodeMetadata(metadata)
+		if entry.Data, err = geotiff.Encode(img); err != nil {
+			return nil, false, err
+		}
+	} else {
+		fmt.Println("Go Backend: Forwarding request to Python GEE service...")
+		report(progress.StageFetching, 10, "Building the before and after composites in Earth Engine")
+		resp, err := app.GEE.Analyze(ctx, requestData)
+		if err != nil {
+			return nil, false, err
+		}
+		entry.Data = resp.Data
+		entry.ContentType = resp.ContentType
+		entry.Metadata = encodeMetadata(responseMetadata(resp))
+		if entry.ContentType == "" {
+			entry.ContentType = "image/png"
+		}
+	}
+
+	if key != "" {
+		if err := app.Cache.Put(key, entry); err != nil {
+			log.Printf("WARNING: Failed to cache analysis result: %v", err)
+		}
+	}
+	return entry, false, nil
+}
+
+// requestCacheKey normalises the request so that equivalent requests share a
+// key: coordinates are rounded to 1e-6 degrees (about 10 cm), rings are
+// closed, dates are reformatted, the default format is made explicit and
+// pipelines get their default settings filled in. Tiled analyses also key on
+// the tiling configuration.
+func (app *AppState) requestCacheKey(requestData geeclient.AnalysisRequest, tiled bool) (string, error) {
+	key := analysisCacheKey{
+		Version:   analysisCacheVersion,
+		StartDate: normaliseDate(requestData.StartDate),
+		EndDate:   normaliseDate(requestData.EndDate),
+		Format:    requestData.Format,
+	}
+	if key.Format == "" {
+		key.Format = formatPNG
+	}
+	if tiled {
+		key.Tiling = &tilingCacheKey{
+			MaxTileSpanDeg: app.Tiling.MaxTileSpanDeg,
+			MaxTiles:       app.Tiling.MaxTiles,
+			MaxMosaicSize:  app.Tiling.MaxMosaicSize,
+		}
+	}
+	if requestData.HasPipeline() {
+		steps, err := pipeline.Parse(requestData.Pipeline)
+		if err != nil {
+			return "", err
+		}
+		if key.Pipeline, err = json.Marshal(steps); err != nil {
+			return "", err
+		}
+	}
+	for _, ring := range requestData.AOI {
+		var points [][2]float64
+		for _, coord := range ring {
+			if len(coord) < 2 {
+				return "", fmt.Errorf("AOI coordinate %v has fewer than 2 values", coord)
+			}
+			points = append(points, [2]float64{roundCoord(coord[0]), roundCoord(coord[1])})
+		}
+		if len(points) > 0 && points[0] != points[len(points)-1] {
+			points = append(points, points[0])
+		}
+		key.AOI = append(key.AOI, points)
+	}
+	return cache.Key(key)
+}
+
+func roundCoord(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// normaliseDate reformats a YYYY-MM-DD or RFC 3339 date as YYYY-MM-DD.
+func normaliseDate(value string) string {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return value
+}
@@ -0,0 +1,134 @@
+// cmd/events.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"geowatch-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventQuery is the parsed form of the query parameters shared by every
+// endpoint that lists change events.
+type eventQuery struct {
+	BBox   []float64
+	Filter storage.EventFilter
+}
+
+// parseEventQuery reads the event filters from the query string:
+//
+//	bbox=minLon,minLat,maxLon,maxLat   area of interest
+//	intersects=<GeoJSON geometry>      polygon the event must intersect
+//	from=, to=                         RFC 3339 timestamps or YYYY-MM-DD dates
+//	event_type=a,b                     one or more event types
+//	min_severity=N                     minimum severity
+//	location_id=N                      events of one location
+//	limit=N, cursor=<token>            page size and continuation token
+func parseEventQuery(c *gin.Context) (*eventQuery, error) {
+	q := &eventQuery{}
+
+	if bboxStr := c.Query("bbox"); bboxStr != "" {
+		coords, err := parseBbox(bboxStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'bbox': %w", err)
+		}
+		q.BBox = coords
+	}
+
+	if intersects := c.Query("intersects"); intersects != "" {
+		var geometry struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(intersects), &geometry); err != nil || geometry.Type == "" {
+			return nil, fmt.Errorf("'intersects' must be a GeoJSON geometry object")
+		}
+		q.Filter.Intersects = json.RawMessage(intersects)
+	}
+
+	var err error
+	if q.Filter.From, err = parseTimeParam(c, "from"); err != nil {
+		return nil, err
+	}
+	if q.Filter.To, err = parseTimeParam(c, "to"); err != nil {
+		return nil, err
+	}
+	if q.Filter.From != nil && q.Filter.To != nil && !q.Filter.To.After(*q.Filter.From) {
+		return nil, fmt.Errorf("'to' must be after 'from'")
+	}
+
+	if types := c.Query("event_type"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				q.Filter.EventTypes = append(q.Filter.EventTypes, t)
+			}
+		}
+	}
+	if q.Filter.MinSeverity, err = parseIntParam(c, "min_severity"); err != nil {
+		return nil, err
+	}
+	if q.Filter.LocationID, err = parseIntParam(c, "location_id"); err != nil {
+		return nil, err
+	}
+
+	limit, err := parseIntParam(c, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if limit != nil {
+		if *limit <= 0 || *limit > storage.MaxEventLimit {
+			return nil, fmt.Errorf("'limit' must be between 1 and %d", storage.MaxEventLimit)
+		}
+		q.Filter.Limit = *limit
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if q.Filter.After, err = storage.DecodeEventCursor(cursor); err != nil {
+			return nil, fmt.Errorf("invalid 'cursor'")
+		}
+	}
+	return q, nil
+}
+
+// parseTimeParam reads an RFC 3339 timestamp or a plain YYYY-MM-DD date (UTC).
+func parseTimeParam(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' must be an RFC 3339 timestamp or a YYYY-MM-DD date", name)
+}
+
+// parseIntParam reads an optional integer query parameter.
+func parseIntParam(c *gin.Context, name string) (*int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' must be an integer", name)
+	}
+	return &n, nil
+}
+
+// geoJSONContentType is the media type registered for GeoJSON (RFC 7946).
+const geoJSONContentType = "application/geo+json"
+
+// wantsGeoJSON reports whether the client asked for GeoJSON, either with
+// format=geojson or through the Accept header.
+func wantsGeoJSON(c *gin.Context) bool {
+	if format := c.Query("format"); format != "" {
+		return strings.EqualFold(format, "geojson")
+	}
+	return strings.Contains(c.GetHeader("Accept"), geoJSONContentType)
+}
@@ -0,0 +1,77 @@
+// cmd/export.go
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"geowatch-backend/internal/export"
+	"geowatch-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportName is the base name of exported files.
+const exportName = "change_events"
+
+// eventExporters maps the export formats to their writer, media type and file
+// extension.
+var eventExporters = map[string]struct {
+	write       func(w io.Writer, name string, events []storage.ChangeEventWithGeom) error
+	contentType string
+	ext         string
+}{
+	"kml":       {export.WriteKML, export.KMLContentType, ".kml"},
+	"kmz":       {export.WriteKMZ, export.KMZContentType, ".kmz"},
+	"shapefile": {export.WriteShapefile, export.ShapefileContentType, ".zip"},
+}
+
+// exportEventsHandler downloads change events as KML, KMZ or a zipped
+// Shapefile, for /events/export/{kml,kmz,shapefile}. It takes the same filters
+// as getEventsHandler; without a limit it exports up to MaxEventLimit events.
+func (app *AppState) exportEventsHandler(c *gin.Context) {
+	// Example Request: /api/v1/events/export/kmz?location_id=3&from=2024-01-01
+	exporter, ok := eventExporters[c.Param("format")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown export format, use kml, kmz or shapefile"})
+		return
+	}
+
+	query, err := parseEventQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query.Filter.Limit == 0 {
+		query.Filter.Limit = storage.MaxEventLimit
+	}
+
+	events, next, err := storage.LoadChangeEventsInBBox(app.DB, query.BBox, query.Filter)
+	if errors.Is(err, storage.ErrInvalidGeometry) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'intersects' geometry", "details": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to load events for export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query for events."})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := exporter.write(&buf, exportName, events); err != nil {
+		log.Printf("ERROR: Failed to export events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export events."})
+		return
+	}
+
+	if next != nil {
+		c.Header("X-Next-Cursor", next.Encode())
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, exportName, exporter.ext))
+	c.Data(http.StatusOK, exporter.contentType, buf.Bytes())
+}
@@ -0,0 +1,76 @@
+// cmd/geotiff.go
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+
+	"geowatch-backend/internal/geeclient"
+	"geowatch-backend/internal/geotiff"
+)
+
+// Result formats of a change analysis.
+const (
+	formatPNG     = "png"
+	formatGeoTIFF = "geotiff"
+)
+
+// geoTIFFContentType is the media type of GeoTIFF results.
+const geoTIFFContentType = "image/tiff"
+
+// requestChangeMagnitude asks the Python service for the raw change
+// magnitude grid and decodes it into a georeferenced two-band image in
+// EPSG:4326: band 1 is the magnitude (NaN where there is no data) and band 2
+// is the mask (1 for valid pixels, 0 otherwise). The service's metadata is
+// returned alongside, or nil if it sent none.
+func (app *AppState) requestChangeMagnitude(ctx context.Context, requestData geeclient.AnalysisRequest) (*geotiff.Image, *geeclient.Metadata, error) {
+	requestData.Output = geeclient.OutputMagnitude
+	resp, err := app.GEE.Analyze(ctx, requestData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	width, errW := strconv.Atoi(resp.Header.Get("X-Grid-Width"))
+	height, errH := strconv.Atoi(resp.Header.Get("X-Grid-Height"))
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return nil, nil, fmt.Errorf("analysis service returned an invalid grid size")
+	}
+	bbox, err := parseBbox(resp.Header.Get("X-Grid-BBox"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("analysis service returned an invalid grid bbox: %w", err)
+	}
+
+	raw := resp.Data
+	if len(raw) != width*height*4 {
+		return nil, nil, fmt.Errorf("analysis service returned %d bytes for a %dx%d grid", len(raw), width, height)
+	}
+
+	nan := float32(math.NaN())
+	magnitude := make([]float32, width*height)
+	mask := make([]float32, width*height)
+	for i := range magnitude {
+		v := math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+		if v < 0 || v != v {
+			magnitude[i] = nan
+			continue
+		}
+		magnitude[i] = v
+		mask[i] = 1
+	}
+
+	noData := math.NaN()
+	img := &geotiff.Image{
+		Width:  width,
+		Height: height,
+		Bands:  [][]float32{magnitude, mask},
+		NoData: &noData,
+	}
+	if err := img.SetBBox(bbox); err != nil {
+		return nil, nil, fmt.Errorf("analysis service returned an invalid grid bbox: %w", err)
+	}
+	return img, responseMetadata(resp), nil
+}
@@ -0,0 +1,136 @@
+// cmd/jobs.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"geowatch-backend/internal/geeclient"
+	"geowatch-backend/internal/jobs"
+	"geowatch-backend/internal/progress"
+	"geowatch-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runAnalysisJob is the jobs.Runner for change analyses: it runs the stored
+// request through the analysis cache and the Python GEE service (or the
+// backend, for requests with a processing pipeline) and returns the PNG
+// overlay, or a GeoTIFF when the request asks for one.
+func (app *AppState) runAnalysisJob(ctx context.Context, job *storage.Job, report jobs.ProgressFunc) (*jobs.Result, error) {
+	var requestData geeclient.AnalysisRequest
+	if err := json.Unmarshal(job.Request, &requestData); err != nil {
+		return nil, fmt.Errorf("invalid stored analysis request: %w", err)
+	}
+
+	// Progress goes both to the job record and to live subscribers.
+	live := app.Progress.Reporter(job.ID)
+	reporter := func(stage progress.Stage, percent int, message string) {
+		report(percent, message)
+		live(stage, percent, message)
+	}
+
+	result, hit, err := app.analyze(ctx, requestData, reporter)
+	if err != nil {
+		return nil, err
+	}
+	app.recordAnalysisRun(ctx, job.ID, job.ID, requestData, result, hit)
+	return &jobs.Result{Data: result.Data, ContentType: result.ContentType}, nil
+}
+
+// createJobHandler queues a change analysis and returns the new job right away.
+// The request body and the ?format= parameter are the same as for POST /changes.
+func (app *AppState) createJobHandler(c *gin.Context) {
+	var requestData geeclient.AnalysisRequest
+	if err := c.ShouldBindJSON(&requestData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// As on POST /changes, ?format= overrides the body and is stored with
+	// the job, which honours it when it runs.
+	if format := c.Query("format"); format != "" {
+		requestData.Format = format
+	}
+	if !validateAnalysisRequest(c, requestData) {
+		return
+	}
+
+	payload, err := json.Marshal(requestData)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode analysis request"})
+		return
+	}
+
+	job, err := app.Jobs.Submit(c.Request.Context(), payload)
+	if errors.Is(err, jobs.ErrQueueFull) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many analyses are queued, please try again later"})
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to create analysis job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create analysis job"})
+		return
+	}
+
+	app.Progress.Publish(job.ID, progress.StageQueued, 0, "Waiting for a free worker")
+	c.Header("Location", "/api/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// notifyJobFinished publishes the final progress event of a job once its
+// outcome is stored.
+func (app *AppState) notifyJobFinished(id string, status storage.JobStatus, message string) {
+	if status == storage.JobSucceeded {
+		app.Progress.Publish(id, progress.StageDone, 100, "Analysis finished")
+	} else {
+		app.Progress.Publish(id, progress.StageError, 100, message)
+	}
+}
+
+// getJobHandler returns the status and progress of a job.
+func (app *AppState) getJobHandler(c *gin.Context) {
+	job, ok := app.loadJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// getJobResultHandler serves the result of a finished job.
+func (app *AppState) getJobResultHandler(c *gin.Context) {
+	job, ok := app.loadJob(c)
+	if !ok {
+		return
+	}
+
+	switch job.Status {
+	case storage.JobSucceeded:
+		c.Header("Content-Type", job.ContentType)
+		c.File(job.ResultPath)
+	case storage.JobFailed:
+		c.JSON(http.StatusConflict, gin.H{"error": "The analysis job failed", "status": job.Status, "details": job.Error})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "The analysis job has not finished yet", "status": job.Status, "progress": job.Progress})
+	}
+}
+
+// loadJob fetches the job named in the URL, writing an error response if it can't.
+func (app *AppState) loadJob(c *gin.Context) (*storage.Job, bool) {
+	job, err := storage.GetJob(c.Request.Context(), app.DB, c.Param("id"))
+	if errors.Is(err, storage.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Analysis job not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to load analysis job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load analysis job"})
+		return nil, false
+	}
+	return job, true
+}
@@ -3,35 +3,64 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
-
-	"encoding/json"
+	"sync"
+	"syscall"
+	"time"
+
+	"geowatch-backend/internal/api"
+	"geowatch-backend/internal/cache"
+	"geowatch-backend/internal/composite"
+	"geowatch-backend/internal/detection"
+	"geowatch-backend/internal/fetcher"
+	"geowatch-backend/internal/geeclient"
+	"geowatch-backend/internal/jobs"
+	"geowatch-backend/internal/monitor"
+	"geowatch-backend/internal/pipeline"
+	"geowatch-backend/internal/progress"
 	"geowatch-backend/internal/storage"
+	"geowatch-backend/internal/tiling"
 	"geowatch-backend/pkg/db"
 
 	"github.com/gin-contrib/cors"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
 )
 
+// shutdownTimeout is how long in-flight requests get to finish after an
+// interrupt or SIGTERM before the server closes them.
+const shutdownTimeout = 30 * time.Second
+
 // AppState holds the shared state for our application, like the fetcher instance.
 type AppState struct {
-	DB *pgxpool.Pool
-}
-
-type AnalysisRequest struct {
-	AOI       [][][]float64 `json:"aoi"`
-	StartDate string        `json:"startDate"`
-	EndDate   string        `json:"endDate"`
+	DB   *pgxpool.Pool
+	Jobs *jobs.Manager
+	// GEE is the client for the Python analysis service.
+	GEE *geeclient.Client
+	// Cache holds finished analysis results; nil when caching is disabled.
+	Cache *cache.Cache
+	// Tiling controls how large AOIs are split into several analyses.
+	Tiling tiling.Config
+	// Progress streams the lifecycle events of running analyses.
+	Progress *progress.Broker
+	// Imagery fetches the images of analyses with a processing pipeline;
+	// nil when no imagery provider is configured.
+	Imagery *fetcher.Fetcher
+	// SyncTimeout bounds a whole POST /changes analysis, retries included.
+	// Jobs aren't bound by it.
+	SyncTimeout time.Duration
 }
 
 func main() {
@@ -44,8 +73,129 @@ func main() {
 	}
 	defer dbPool.Close()
 
+	// "geowatch-backend migrate ..." manages the schema and exits.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(dbPool, os.Args[2:]); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		return
+	}
+
+	// Background work and the server stop on an interrupt or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var background sync.WaitGroup
+
+	// Bring the schema up to date before anything queries it.
+	if os.Getenv("DB_AUTO_MIGRATE") != "false" {
+		if err := db.MigrateUp(ctx, dbPool); err != nil {
+			log.Fatalf("FATAL: Could not migrate the database: %v", err)
+		}
+	}
+
+	// Background analysis jobs, so large AOIs don't have to finish within one HTTP request.
+	resultsDir := os.Getenv("RESULTS_DIR")
+	if resultsDir == "" {
+		resultsDir = "results"
+	}
+	workers, _ := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if workers <= 0 {
+		workers = 2
+	}
+	// Analyses with a processing pipeline and scheduled monitoring of saved
+	// locations need an imagery provider; without one configured the rest of
+	// the API still works.
+	imageFetcher, err := fetcher.NewFetcher()
+	if err != nil {
+		log.Printf("WARNING: No imagery provider: %v", err)
+		imageFetcher = nil
+	}
+
 	appState := &AppState{
-		DB: dbPool,
+		DB:          dbPool,
+		Imagery:     imageFetcher,
+		GEE:         newGEEClient(),
+		Cache:       newAnalysisCache(),
+		Tiling:      newTilingConfig(),
+		Progress:    progress.NewBroker(10 * time.Minute),
+		SyncTimeout: newSyncTimeout(),
+	}
+
+	jobManager, err := jobs.NewManager(dbPool, appState.runAnalysisJob, jobs.Config{
+		Workers:    workers,
+		ResultsDir: resultsDir,
+		Notify:     appState.notifyJobFinished,
+	})
+	if err != nil {
+		log.Fatalf("FATAL: Could not set up the analysis job manager: %v", err)
+	}
+	if err := jobManager.Start(ctx); err != nil {
+		log.Fatalf("FATAL: Could not start the analysis job manager: %v", err)
+	}
+	appState.Jobs = jobManager
+
+	store := storage.NewStore(stdlib.OpenDBFromPool(dbPool))
+	locationsAPI := api.NewAPI(store)
+	if os.Getenv("MONITOR_ENABLED") != "false" {
+		if imageFetcher == nil {
+			log.Println("WARNING: Location monitoring is disabled without an imagery provider")
+		} else {
+			monitorConfig := monitor.DefaultConfig()
+			if d, err := time.ParseDuration(os.Getenv("MONITOR_POLL_INTERVAL")); err == nil && d > 0 {
+				monitorConfig.PollInterval = d
+			}
+			if f, err := strconv.ParseFloat(os.Getenv("MONITOR_MIN_VALID_FRACTION"), 64); err == nil && f >= 0 && f <= 1 {
+				monitorConfig.MinValidFraction = f
+			}
+			if name := os.Getenv("MONITOR_NORMALIZATION"); name != "" {
+				if monitorConfig.Normalization, err = fetcher.ParseNormalization(name); err != nil {
+					log.Printf("WARNING: Ignoring MONITOR_NORMALIZATION: %v", err)
+					monitorConfig.Normalization = monitor.DefaultConfig().Normalization
+				}
+			}
+			if spec := os.Getenv("MONITOR_PIPELINE"); spec != "" {
+				if monitorConfig.Pipeline, err = pipeline.Parse([]byte(spec)); err != nil {
+					log.Printf("WARNING: Ignoring MONITOR_PIPELINE: %v", err)
+					monitorConfig.Pipeline = nil
+				}
+			}
+			switch mode := detection.Mode(os.Getenv("MONITOR_MODE")); mode {
+			case "":
+			case detection.ModeVisual, detection.ModeSpectral:
+				monitorConfig.Mode = mode
+			default:
+				log.Printf("WARNING: Ignoring MONITOR_MODE=%q; expected %q or %q", mode, detection.ModeVisual, detection.ModeSpectral)
+			}
+			if monitorConfig.Mode == detection.ModeSpectral {
+				// Spectral comparisons can't run image pipelines.
+				if monitorConfig.Pipeline != nil {
+					log.Println("WARNING: Ignoring MONITOR_PIPELINE with MONITOR_MODE=spectral")
+					monitorConfig.Pipeline = nil
+				}
+				locationsAPI.RejectPipelines = true
+			}
+			if name := os.Getenv("MONITOR_COMPOSITE"); name != "" {
+				// Visual monitoring compares display images, which have no NIR for max_ndvi.
+				method, err := composite.ParseMethod(name)
+				if err != nil || method == composite.MethodMaxNDVI && monitorConfig.Mode != detection.ModeSpectral {
+					log.Printf("WARNING: Ignoring MONITOR_COMPOSITE=%q; visual monitoring supports %q and %q composites, spectral monitoring %q too",
+						name, composite.MethodMedian, composite.MethodLeastCloudy, composite.MethodMaxNDVI)
+				} else {
+					compositeConfig := composite.DefaultConfig()
+					compositeConfig.Method = method
+					if n, err := strconv.Atoi(os.Getenv("MONITOR_COMPOSITE_SCENES")); err == nil && n > 0 {
+						compositeConfig.MaxScenes = n
+					}
+					monitorConfig.Composite = &compositeConfig
+				}
+			}
+			scheduler := monitor.NewScheduler(store, dbPool, imageFetcher, monitorConfig)
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				scheduler.Run(ctx)
+			}()
+		}
 	}
 
 	router := gin.Default()
@@ -58,18 +208,32 @@ func main() {
 	}
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{allowedOrigin},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "X-Analysis-ID"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Disposition", "Location", "X-Cache", "X-Total-Count", "X-Next-Cursor", "X-Analysis-ID", "Link"},
 		AllowCredentials: true,
 	}))
 
 	// --- 3. Define API Routes ---
+	router.GET("/health", locationsAPI.HealthCheckHandler)
+
 	apiV1 := router.Group("/api/v1")
 	{
+		apiV1.GET("/health", locationsAPI.HealthCheckHandler)
+		locationsAPI.RegisterRoutes(apiV1)
+
 		// Changed to POST to accept a JSON body
 		apiV1.POST("/changes", appState.getChangesHandler)
 		apiV1.GET("/events", appState.getEventsHandler)
+		apiV1.GET("/events/tiles/:z/:x/:y", appState.getEventTileHandler)
+		apiV1.GET("/events/export/:format", appState.exportEventsHandler)
+
+		// Asynchronous analyses: submit, then poll for status and fetch the result.
+		apiV1.POST("/jobs", appState.createJobHandler)
+		apiV1.GET("/jobs/:id", appState.getJobHandler)
+		apiV1.GET("/jobs/:id/result", appState.getJobResultHandler)
+		apiV1.GET("/analyses/:id", appState.getAnalysisRunHandler)
+		apiV1.GET("/analyses/:id/events", appState.analysisEventsHandler)
 	}
 
 	// --- KEY CHANGE: RUN ON A DIFFERENT PORT ---
@@ -80,8 +244,26 @@ func main() {
 		}
 		goServerPort = p
 	}
-	fmt.Printf("INFO: Starting GeoWatch Go API server on port %s\n", goServerPort)
-	router.Run(goServerPort)
+	server := &http.Server{Addr: goServerPort, Handler: router}
+	go func() {
+		fmt.Printf("INFO: Starting GeoWatch Go API server on port %s\n", goServerPort)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("ERROR: API server failed: %v", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	fmt.Println("INFO: Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("WARNING: API server did not shut down cleanly: %v", err)
+	}
+	// Interrupted jobs stay marked as running and are resumed on the next start.
+	jobManager.Wait()
+	background.Wait()
+	fmt.Println("INFO: Shutdown complete.")
 }
 
 // getChangesHandler is the heart of our API. It processes the request and returns the image.
@@ -93,83 +275,146 @@ func (app *AppState) getChangesHandler(c *gin.Context) {
 	fmt.Println("Go Backend: Received request from frontend.")
 
 	// We still need to read the JSON from the frontend
-	var requestData AnalysisRequest
+	var requestData geeclient.AnalysisRequest
 	if err := c.ShouldBindJSON(&requestData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
 
-	// Marshal the received data to be sent to the Python service
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request for Python service"})
+	if format := c.Query("format"); format != "" {
+		requestData.Format = format
+	}
+	if !validateAnalysisRequest(c, requestData) {
 		return
 	}
 
-	// The Python service is running on port 8080
-	pythonServiceURL := os.Getenv("PYTHON_SERVICE_URL") + "/analyze-changes"
-
-	fmt.Println("Go Backend: Forwarding request to Python GEE service...")
-	resp, err := http.Post(pythonServiceURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("ERROR: Could not connect to Python service: %v", err)
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to communicate with the GEE analysis service"})
+	// Clients that want live progress pick an ID and follow
+	// /analyses/{id}/events while this request runs. Either way the ID is
+	// returned, and /analyses/{id} describes the result afterwards.
+	analysisID, ok := analysisIDParam(c)
+	if !ok {
 		return
 	}
-	defer resp.Body.Close()
-
-	// Check if the Python service itself returned an error
-	if resp.StatusCode != http.StatusOK {
-		// Try to read the error body from Python to give a better message
-		errorBody, _ := io.ReadAll(resp.Body)
-		log.Printf("ERROR: Python service returned status %d with body: %s", resp.StatusCode, string(errorBody))
-		c.JSON(http.StatusBadGateway, gin.H{"error": "The GEE analysis service returned an error"})
+	if analysisID == "" {
+		var err error
+		if analysisID, err = newAnalysisID(); err != nil {
+			log.Printf("ERROR: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start analysis"})
+			return
+		}
+		c.Header("X-Analysis-ID", analysisID)
+	}
+	if !app.reserveAnalysisID(c, analysisID, requestData) {
 		return
 	}
+	report := app.Progress.Reporter(analysisID)
 
-	// --- MORE EFFICIENT PROXYING ---
-	// We get the content type from Python's response
-	contentType := resp.Header.Get("Content-Type")
-	// And the content length
-	contentLength := resp.ContentLength
-
-	// We use Gin's Stream method to copy the response body directly
-	// without buffering the whole image in memory.
-	fmt.Println("Go Backend: Streaming PNG response back to client...")
-	c.Stream(func(w io.Writer) bool {
-		_, err := io.Copy(w, resp.Body)
-		return err != nil
-	})
+	ctx, cancel := context.WithTimeout(c.Request.Context(), app.SyncTimeout)
+	defer cancel()
+	result, hit, err := app.analyze(ctx, requestData, report)
+	if err != nil {
+		report(progress.StageError, 100, err.Error())
+		if err := storage.ReleaseAnalysisID(context.WithoutCancel(c.Request.Context()), app.DB, analysisID); err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+		app.writeAnalysisServiceError(c, err)
+		return
+	}
+	report(progress.StageDone, 100, "Analysis finished")
+	app.recordAnalysisRun(c.Request.Context(), analysisID, "", requestData, result, hit)
+	c.Header("Link", fmt.Sprintf(`</api/v1/analyses/%s>; rel="describedby"`, analysisID))
+
+	if hit {
+		fmt.Println("Go Backend: Serving cached analysis result.")
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+	}
+	if result.ContentType == geoTIFFContentType {
+		c.Header("Content-Disposition", `attachment; filename="change.tif"`)
+	}
+	c.Data(http.StatusOK, result.ContentType, result.Data)
+}
 
-	// Set the headers on our response to match Python's
-	c.Header("Content-Type", contentType)
-	c.Header("Content-Length", strconv.FormatInt(contentLength, 10))
+// writeAnalysisServiceError maps a failed analysis to a response. The error
+// message from the Python service or the imagery provider, when there is
+// one, is passed on in "details".
+func (app *AppState) writeAnalysisServiceError(c *gin.Context, err error) {
+	var serviceErr *geeclient.ServiceError
+	var imageryErr *imageryError
+	switch {
+	case errors.As(err, &serviceErr):
+		// Log the error body from Python to give a better message
+		log.Printf("ERROR: Python service returned status %d with body: %s", serviceErr.StatusCode, serviceErr.Body)
+		status := http.StatusBadGateway
+		if !serviceErr.Retryable() {
+			// The service rejected the request itself, e.g. a year without data.
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{
+			"error":          "The GEE analysis service returned an error",
+			"details":        serviceErr.Message,
+			"service_status": serviceErr.StatusCode,
+		})
+	case errors.Is(err, geeclient.ErrCircuitOpen):
+		log.Printf("ERROR: Skipping call to Python service: %v", err)
+		retryAfter := int(math.Ceil(app.GEE.RetryAfter().Seconds()))
+		c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "The GEE analysis service is temporarily unavailable", "details": err.Error()})
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Printf("ERROR: Python service timed out: %v", err)
+		c.JSON(http.StatusGatewayTimeout, gin.H{
+			"error":   "The GEE analysis service timed out; submit long analyses to /jobs instead",
+			"details": err.Error(),
+		})
+	case errors.Is(err, errNoImagery):
+		log.Printf("ERROR: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Analyses with a processing pipeline are not available", "details": err.Error()})
+	case errors.As(err, &imageryErr):
+		log.Printf("ERROR: Pipeline analysis failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The imagery analysis failed", "details": err.Error()})
+	default:
+		log.Printf("ERROR: Could not connect to Python service: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to communicate with the GEE analysis service", "details": err.Error()})
+	}
 }
 
 // getEventsHandler queries and returns historical change events from the DB.
+// See parseEventQuery for the supported filters. With format=geojson or an
+// "Accept: application/geo+json" header the events are returned as a GeoJSON
+// FeatureCollection instead of the plain JSON array. When more events match than
+// fit on one page, the X-Next-Cursor header holds the token for the next page.
 func (app *AppState) getEventsHandler(c *gin.Context) {
-	// Example Request: /api/v1/events?bbox=-74.0,40.7,-73.9,40.8
-	bboxStr := c.Query("bbox")
-	if bboxStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'bbox' query parameter. Example format: 'minLon,minLat,maxLon,maxLat'"})
+	// Example Request: /api/v1/events?bbox=-74.0,40.7,-73.9,40.8&from=2024-06-01&min_severity=500
+	query, err := parseEventQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event query.", "details": err.Error()})
 		return
 	}
 
-	// Parse the bbox string (e.g., "-74.0,40.7,-73.9,40.8") into a slice of float64
-	coords, err := parseBbox(bboxStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'bbox' format.", "details": err.Error()})
+	// Call our loading function
+	events, next, err := storage.LoadChangeEventsInBBox(app.DB, query.BBox, query.Filter)
+	if errors.Is(err, storage.ErrInvalidGeometry) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'intersects' geometry.", "details": err.Error()})
 		return
 	}
-
-	// Call our new loading function
-	events, err := storage.LoadChangeEventsInBBox(app.DB, coords)
 	if err != nil {
 		log.Printf("ERROR: Failed to load events from DB: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query for events."})
 		return
 	}
 
+	if next != nil {
+		c.Header("X-Next-Cursor", next.Encode())
+	}
+
+	// GIS clients get a FeatureCollection they can load directly.
+	if wantsGeoJSON(c) {
+		c.Header("Content-Type", geoJSONContentType)
+		c.JSON(http.StatusOK, storage.ToFeatureCollection(events))
+		return
+	}
+
 	// Return the found events as a JSON array.
 	c.JSON(http.StatusOK, events)
 }
@@ -200,7 +445,7 @@ func parseBbox(bboxStr string) ([]float64, error) {
 // 3.  **Replace** the code in `cmd/main.go` with the new API server code from Step 3.
 // 4.  **Run the server:** Open your terminal in the project root and execute:
 //     ```bash
-//     go run ./cmd/main.go
+//     go run ./cmd
 //     ```
 //     You should see the message: `INFO: Starting GeoWatch API server on port 8080...`
 // 5.  **Test your live API!** Open your web browser and navigate to this URL:
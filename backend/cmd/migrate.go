@@ -0,0 +1,58 @@
+// cmd/migrate.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"geowatch-backend/pkg/db"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const migrateUsage = "usage: geowatch-backend migrate [up | down [N] | status]"
+
+// runMigrateCommand implements the "migrate" subcommand:
+//
+//	geowatch-backend migrate up        apply all pending migrations (default)
+//	geowatch-backend migrate down [N]  revert the last N migrations (default 1)
+//	geowatch-backend migrate status    list migrations and when they were applied
+func runMigrateCommand(pool *pgxpool.Pool, args []string) error {
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		return db.MigrateUp(ctx, pool)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid number of migrations %q: %s", args[1], migrateUsage)
+			}
+			steps = n
+		}
+		return db.MigrateDown(ctx, pool, steps)
+	case "status":
+		states, err := db.MigrationStatus(ctx, pool)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q: %s", command, migrateUsage)
+	}
+}
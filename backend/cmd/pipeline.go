@@ -0,0 +1,172 @@
+// cmd/pipeline.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"geowatch-backend/internal/cache"
+	"geowatch-backend/internal/composite"
+	"geowatch-backend/internal/detection"
+	"geowatch-backend/internal/fetcher"
+	"geowatch-backend/internal/geeclient"
+	"geowatch-backend/internal/geo"
+	"geowatch-backend/internal/geotiff"
+	"geowatch-backend/internal/pipeline"
+	"geowatch-backend/internal/progress"
+	"geowatch-backend/internal/tiling"
+)
+
+// pipelineGridSize is the longer side, in pixels, of the images fetched for
+// analyses with a processing pipeline.
+const pipelineGridSize = 1024
+
+// errNoImagery is returned for analyses with a processing pipeline when no
+// imagery provider is configured.
+var errNoImagery = errors.New("analyses with a processing pipeline need an imagery provider (see IMAGERY_PROVIDER)")
+
+// imageryError is a failure of an analysis the backend runs itself, so that
+// it isn't reported as a failure of the GEE service.
+type imageryError struct {
+	err error
+}
+
+func (e *imageryError) Error() string { return e.err.Error() }
+
+func (e *imageryError) Unwrap() error { return e.err }
+
+// analyzeWithPipeline runs an analysis in the backend, since Earth Engine
+// can't run processing pipelines: median composites of 1-15 July of the start
+// and end years, the windows the GEE service uses, are fetched from the
+// imagery provider, run through the request's pipeline and compared by colour
+// distance. The change magnitude is clipped to the AOI and rendered like a
+// tiled analysis, and the metadata records the pipeline as it ran.
+func (app *AppState) analyzeWithPipeline(ctx context.Context, requestData geeclient.AnalysisRequest, report progress.Reporter) (*cache.Entry, error) {
+	if app.Imagery == nil {
+		return nil, errNoImagery
+	}
+	steps, err := pipeline.Parse(requestData.Pipeline)
+	if err != nil {
+		return nil, err
+	}
+	polygon := aoiPolygon(requestData.AOI)
+	bbox := polygon.BBox()
+	width, height := gridSize(bbox, pipelineGridSize)
+
+	fmt.Printf("Go Backend: Running analysis with a %d-step pipeline on %s imagery...\n", len(steps.Steps), app.Imagery.Provider().Name())
+	report(progress.StageFetching, 10, "Fetching the before composite")
+	before, beforeComposite, err := app.fetchJulyComposite(ctx, requestData.StartDate, bbox, width, height)
+	if err != nil {
+		return nil, &imageryError{fmt.Errorf("failed to fetch the before composite: %w", err)}
+	}
+	report(progress.StageFetching, 40, "Fetching the after composite")
+	after, afterComposite, err := app.fetchJulyComposite(ctx, requestData.EndDate, bbox, width, height)
+	if err != nil {
+		return nil, &imageryError{fmt.Errorf("failed to fetch the after composite: %w", err)}
+	}
+
+	report(progress.StageProcessing, 70, fmt.Sprintf("Running the %d-step processing pipeline", len(steps.Steps)))
+	before, after, applied, err := steps.Apply(before, after, pipeline.Area{BBox: bbox, Polygons: []geo.Polygon{polygon}})
+	if err != nil {
+		return nil, &imageryError{err}
+	}
+	result, err := detection.VisualChangeWithOptions(before, after, detection.Options{Strategy: detection.ThresholdOtsu})
+	if err != nil {
+		return nil, &imageryError{err}
+	}
+	grid, err := magnitudeGrid(result, bbox)
+	if err != nil {
+		return nil, &imageryError{err}
+	}
+	tiling.Clip(grid, polygon)
+
+	metadata := &geeclient.Metadata{
+		Before: beforeComposite,
+		After:  afterComposite,
+		BBox:   bbox,
+		Width:  grid.Width,
+		Height: grid.Height,
+		CRS:    "EPSG:4326",
+	}
+	if metadata.Pipeline, err = json.Marshal(applied); err != nil {
+		return nil, fmt.Errorf("failed to record the pipeline: %w", err)
+	}
+	return renderMagnitude(requestData.Format, grid, metadata, report)
+}
+
+// fetchJulyComposite fetches the median composite of 1-15 July of the year
+// of date and describes it the way the service describes its composites.
+func (app *AppState) fetchJulyComposite(ctx context.Context, date string, bbox []float64, width, height int) (*fetcher.SatelliteImage, geeclient.Composite, error) {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, geeclient.Composite{}, fmt.Errorf("invalid date %q: %w", date, err)
+	}
+	from := time.Date(t.Year(), time.July, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(t.Year(), time.July, 15, 0, 0, 0, 0, time.UTC)
+	img, err := composite.FetchImage(ctx, app.Imagery, fetcher.ImageRequest{
+		BBox:   bbox,
+		From:   from,
+		To:     to,
+		Bands:  fetcher.DefaultRGBBands,
+		Width:  width,
+		Height: height,
+	}, to, composite.DefaultConfig())
+	if err != nil {
+		return nil, geeclient.Composite{}, err
+	}
+
+	description := geeclient.Composite{
+		Year:       t.Year(),
+		Collection: app.Imagery.Provider().Name(),
+		StartDate:  from.Format("2006-01-02"),
+		EndDate:    to.Format("2006-01-02"),
+		SceneIDs:   []string{},
+	}
+	if img.SceneID != "" {
+		description.SceneIDs = strings.Split(img.SceneID, ",")
+		sort.Strings(description.SceneIDs)
+	}
+	description.SceneCount = len(description.SceneIDs)
+	return img, description, nil
+}
+
+// magnitudeGrid turns a detection result into the two-band grid the service
+// returns: the change magnitude (NaN where either image was masked) and the
+// valid-pixel mask.
+func magnitudeGrid(result *detection.Result, bbox []float64) (*geotiff.Image, error) {
+	mask := make([]float32, len(result.Magnitude))
+	for i, v := range result.Magnitude {
+		if v == v { // not NaN
+			mask[i] = 1
+		}
+	}
+	noData := math.NaN()
+	grid := &geotiff.Image{
+		Width:  result.Width,
+		Height: result.Height,
+		Bands:  [][]float32{result.Magnitude, mask},
+		NoData: &noData,
+	}
+	if err := grid.SetBBox(bbox); err != nil {
+		return nil, err
+	}
+	return grid, nil
+}
+
+// gridSize returns the width and height of an image of bbox whose longer
+// side is maxSize pixels, keeping pixels roughly square on the ground.
+func gridSize(bbox []float64, maxSize int) (int, int) {
+	width := (bbox[2] - bbox[0]) * math.Cos((bbox[1]+bbox[3])/2*math.Pi/180)
+	height := bbox[3] - bbox[1]
+	if width >= height {
+		return maxSize, max(1, int(math.Round(float64(maxSize)*height/width)))
+	}
+	return max(1, int(math.Round(float64(maxSize)*width/height))), maxSize
+}
@@ -0,0 +1,117 @@
+// cmd/progress.go
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"geowatch-backend/internal/progress"
+	"geowatch-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// analysisIDPattern restricts client-chosen analysis IDs to URL-safe tokens.
+var analysisIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// sseKeepAlive is how often an idle event stream gets a comment line, so
+// proxies don't close it while Earth Engine is busy.
+const sseKeepAlive = 15 * time.Second
+
+// analysisIDParam reads the optional client-chosen analysis ID from the
+// X-Analysis-ID header or the analysis_id query parameter, writing a 400
+// response if it is malformed.
+func analysisIDParam(c *gin.Context) (string, bool) {
+	id := c.GetHeader("X-Analysis-ID")
+	if id == "" {
+		id = c.Query("analysis_id")
+	}
+	if id != "" && !analysisIDPattern.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Analysis IDs must be 1-64 letters, digits, '-' or '_'"})
+		return "", false
+	}
+	if id != "" {
+		c.Header("X-Analysis-ID", id)
+	}
+	return id, true
+}
+
+// analysisEventsHandler streams the progress of an analysis as Server-Sent
+// Events, one "progress" event per update, and ends after the done or error
+// event. The ID is a job ID or the ID a client passed to POST /changes; the
+// stream may be opened before the analysis starts.
+func (app *AppState) analysisEventsHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !analysisIDPattern.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid analysis ID"})
+		return
+	}
+
+	// Jobs are persisted, so their state is known even if this server never
+	// ran them or their events have expired.
+	var snapshot *progress.Event
+	job, err := storage.GetJob(c.Request.Context(), app.DB, id)
+	switch {
+	case err == nil:
+		snapshot = jobSnapshot(job)
+	case !errors.Is(err, storage.ErrJobNotFound):
+		log.Printf("ERROR: Failed to load analysis job for progress stream: %v", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	if snapshot != nil && snapshot.Stage.Final() {
+		c.SSEvent("progress", snapshot)
+		return
+	}
+
+	events, unsubscribe := app.Progress.Subscribe(id)
+	defer unsubscribe()
+	if snapshot != nil {
+		c.SSEvent("progress", snapshot)
+		c.Writer.Flush()
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent("progress", event)
+			return !event.Stage.Final()
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// jobSnapshot describes the stored state of a job as a progress event.
+func jobSnapshot(job *storage.Job) *progress.Event {
+	event := &progress.Event{AnalysisID: job.ID, Progress: job.Progress, Message: job.Message, Time: job.UpdatedAt}
+	switch job.Status {
+	case storage.JobQueued:
+		event.Stage = progress.StageQueued
+	case storage.JobRunning:
+		event.Stage = progress.StageFetching
+	case storage.JobSucceeded:
+		event.Stage = progress.StageDone
+	case storage.JobFailed:
+		event.Stage = progress.StageError
+		event.Message = job.Error
+	}
+	return event
+}
@@ -0,0 +1,139 @@
+// cmd/runs.go
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"log"
+	"net/http"
+
+	"geowatch-backend/internal/cache"
+	"geowatch-backend/internal/geeclient"
+	"geowatch-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseMetadata parses the metadata the service sent with a result. A
+// missing or malformed description is logged rather than failing a result
+// that is otherwise fine.
+func responseMetadata(resp *geeclient.Response) *geeclient.Metadata {
+	metadata, err := resp.Metadata()
+	if err != nil {
+		log.Printf("WARNING: Ignoring analysis metadata: %v", err)
+		return nil
+	}
+	return metadata
+}
+
+// encodeMetadata returns the JSON stored with a cached result, or nil when
+// there is no metadata.
+func encodeMetadata(metadata *geeclient.Metadata) json.RawMessage {
+	if metadata == nil {
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		log.Printf("WARNING: Failed to encode analysis metadata: %v", err)
+		return nil
+	}
+	return data
+}
+
+// paletteHex formats palette colours the way the service reports them.
+func paletteHex(palette []color.RGBA) []string {
+	colors := make([]string, len(palette))
+	for i, c := range palette {
+		colors[i] = fmt.Sprintf("%02X%02X%02X", c.R, c.G, c.B)
+	}
+	return colors
+}
+
+// newAnalysisID returns a random 128-bit hex identifier for analyses whose
+// client didn't choose one.
+func newAnalysisID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate analysis id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// reserveAnalysisID claims the analysis ID of a POST /changes request before
+// it runs. It writes a 409 response and returns false if another analysis or
+// job already uses the ID.
+func (app *AppState) reserveAnalysisID(c *gin.Context, id string, requestData geeclient.AnalysisRequest) bool {
+	request, err := json.Marshal(requestData)
+	if err == nil {
+		err = storage.ReserveAnalysisID(c.Request.Context(), app.DB, id, request)
+	}
+	if errors.Is(err, storage.ErrRunExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An analysis with this ID already exists"})
+		return false
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start analysis"})
+		return false
+	}
+	return true
+}
+
+// recordAnalysisRun stores the request and metadata of a served analysis
+// under its analysis ID; jobID is the job that ran it, or empty for
+// POST /changes. Failing to record it doesn't fail the analysis.
+func (app *AppState) recordAnalysisRun(ctx context.Context, id, jobID string, requestData geeclient.AnalysisRequest, result *cache.Entry, hit bool) {
+	request, err := json.Marshal(requestData)
+	if err != nil {
+		log.Printf("ERROR: Failed to encode analysis request for run %s: %v", id, err)
+		return
+	}
+	run := &storage.AnalysisRun{
+		ID:          id,
+		Request:     request,
+		ContentType: result.ContentType,
+		CacheHit:    hit,
+		JobID:       jobID,
+		Metadata:    result.Metadata,
+	}
+	if err := storage.SaveAnalysisRun(ctx, app.DB, run); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+}
+
+// analysisRunResponse is a recorded run, with a link to the stored result
+// when the analysis ran as a job.
+type analysisRunResponse struct {
+	*storage.AnalysisRun
+	ResultURL string `json:"result_url,omitempty"`
+}
+
+// getAnalysisRunHandler returns the recorded request and metadata of an
+// analysis: the Landsat collection and scenes behind each composite, the
+// colour stretch and the bounds of the result.
+func (app *AppState) getAnalysisRunHandler(c *gin.Context) {
+	run, err := storage.GetAnalysisRun(c.Request.Context(), app.DB, c.Param("id"))
+	if errors.Is(err, storage.ErrRunNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Analysis not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to load analysis run: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load analysis"})
+		return
+	}
+
+	response := analysisRunResponse{AnalysisRun: run}
+	if run.JobID != "" {
+		if job, err := storage.GetJob(c.Request.Context(), app.DB, run.JobID); err == nil && job.Status == storage.JobSucceeded {
+			response.ResultURL = "/api/v1/jobs/" + job.ID + "/result"
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,59 @@
+// cmd/tiles.go
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"geowatch-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mvtContentType is the media type of Mapbox Vector Tiles.
+const mvtContentType = "application/vnd.mapbox-vector-tile"
+
+// getEventTileHandler serves change events as a Mapbox Vector Tile for
+// /events/tiles/{z}/{x}/{y}.mvt. It takes the same filters as getEventsHandler
+// except bbox, limit and cursor, which don't apply to a tile.
+func (app *AppState) getEventTileHandler(c *gin.Context) {
+	// Example Request: /api/v1/events/tiles/12/2411/3079.mvt?from=2024-06-01&min_severity=500
+	z, errZ := strconv.Atoi(c.Param("z"))
+	x, errX := strconv.Atoi(c.Param("x"))
+	y, errY := strconv.Atoi(strings.TrimSuffix(c.Param("y"), ".mvt"))
+	if errZ != nil || errX != nil || errY != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tile coordinates must be integers, e.g. /events/tiles/3/4/2.mvt"})
+		return
+	}
+
+	query, err := parseEventQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tile, err := storage.LoadEventTile(c.Request.Context(), app.DB, z, x, y, query.Filter)
+	if errors.Is(err, storage.ErrInvalidGeometry) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'intersects' geometry", "details": err.Error()})
+		return
+	}
+	if errors.Is(err, storage.ErrTileOutOfRange) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to render event tile: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render event tile."})
+		return
+	}
+
+	if len(tile) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.Data(http.StatusOK, mvtContentType, tile)
+}
@@ -0,0 +1,134 @@
+// cmd/tiling.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"geowatch-backend/internal/cache"
+	"geowatch-backend/internal/detection"
+	"geowatch-backend/internal/geeclient"
+	"geowatch-backend/internal/geo"
+	"geowatch-backend/internal/geotiff"
+	"geowatch-backend/internal/progress"
+	"geowatch-backend/internal/tiling"
+)
+
+// newTilingConfig reads TILE_MAX_SPAN_DEG and TILE_WORKERS on top of the
+// default tiling settings.
+func newTilingConfig() tiling.Config {
+	config := tiling.DefaultConfig()
+	if span, err := strconv.ParseFloat(os.Getenv("TILE_MAX_SPAN_DEG"), 64); err == nil && span > 0 {
+		config.MaxTileSpanDeg = span
+	}
+	if n, err := strconv.Atoi(os.Getenv("TILE_WORKERS")); err == nil && n > 0 {
+		config.Workers = n
+	}
+	return config
+}
+
+// analysisGrid splits the AOI's bounding box into tiles. It returns nil when
+// the AOI fits in a single request.
+func (app *AppState) analysisGrid(requestData geeclient.AnalysisRequest) *tiling.Grid {
+	if len(requestData.AOI) == 0 {
+		return nil
+	}
+	grid, err := tiling.Split(aoiPolygon(requestData.AOI).BBox(), app.Tiling)
+	if err != nil || len(grid.Tiles) < 2 {
+		return nil
+	}
+	return grid
+}
+
+// analyzeTiled runs the analysis tile by tile and mosaics the change
+// magnitude, clipped to the AOI polygon, so the colour stretch (min to 98th percentile) is computed once
+// for the whole area instead of per tile.
+func (app *AppState) analyzeTiled(ctx context.Context, requestData geeclient.AnalysisRequest, grid *tiling.Grid, report progress.Reporter) (*cache.Entry, error) {
+	fmt.Printf("Go Backend: Splitting AOI into %d tiles (%dx%d)...\n", len(grid.Tiles), grid.Cols, grid.Rows)
+	report(progress.StageFetching, 10, fmt.Sprintf("Analysing %d tiles in Earth Engine", len(grid.Tiles)))
+
+	var done atomic.Int32
+	var mu sync.Mutex
+	metadata := &geeclient.Metadata{}
+	mosaic, err := tiling.Mosaic(ctx, grid, app.Tiling, func(ctx context.Context, tile tiling.Tile) (*geotiff.Image, error) {
+		tileRequest := requestData
+		tileRequest.AOI = bboxPolygon(tile.BBox)
+		img, tileMetadata, err := app.requestChangeMagnitude(ctx, tileRequest)
+		if err == nil {
+			mu.Lock()
+			metadata.Merge(tileMetadata)
+			mu.Unlock()
+			n := int(done.Add(1))
+			report(progress.StageFetching, 10+70*n/len(grid.Tiles), fmt.Sprintf("Analysed %d of %d tiles", n, len(grid.Tiles)))
+		}
+		return img, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	tiling.Clip(mosaic, aoiPolygon(requestData.AOI))
+	metadata.Tiles = len(grid.Tiles)
+	metadata.Width, metadata.Height = mosaic.Width, mosaic.Height
+	metadata.BBox = mosaic.BBox()
+	return renderMagnitude(requestData.Format, mosaic, metadata, report)
+}
+
+// renderMagnitude encodes a change magnitude grid (band 0) and its mask
+// (band 1) in the requested format: as a GeoTIFF, or as a palette PNG
+// stretched from the minimum to the 98th percentile of the whole grid, which
+// is recorded in metadata.
+func renderMagnitude(format string, grid *geotiff.Image, metadata *geeclient.Metadata, report progress.Reporter) (*cache.Entry, error) {
+	if format == formatGeoTIFF {
+		report(progress.StageRendering, 90, "Writing the GeoTIFF")
+		data, err := geotiff.Encode(grid)
+		if err != nil {
+			return nil, err
+		}
+		return &cache.Entry{Data: data, ContentType: geoTIFFContentType, Metadata: encodeMetadata(metadata)}, nil
+	}
+
+	report(progress.StageComputingStats, 85, "Computing the colour stretch")
+	stats := detection.ComputeStats(grid.Bands[0])
+	metadata.Stretch = &geeclient.Stretch{Min: stats.Min, P98: stats.P98}
+	metadata.Palette = paletteHex(detection.ChangePalette)
+	report(progress.StageRendering, 90, "Rendering the change overlay")
+	overlay := detection.RenderPalette(grid.Bands[0], grid.Width, grid.Height, stats.Min, stats.P98, detection.ChangePalette)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, overlay); err != nil {
+		return nil, fmt.Errorf("failed to encode change overlay: %w", err)
+	}
+	return &cache.Entry{Data: buf.Bytes(), ContentType: "image/png", Metadata: encodeMetadata(metadata)}, nil
+}
+
+// aoiPolygon converts the request's AOI rings, holes included, to a polygon.
+func aoiPolygon(aoi [][][]float64) geo.Polygon {
+	polygon := make(geo.Polygon, 0, len(aoi))
+	for _, positions := range aoi {
+		ring := make(geo.Ring, 0, len(positions))
+		for _, pos := range positions {
+			if len(pos) >= 2 {
+				ring = append(ring, geo.Point{pos[0], pos[1]})
+			}
+		}
+		polygon = append(polygon, ring)
+	}
+	return polygon
+}
+
+// bboxPolygon returns a bbox as a closed, counter-clockwise AOI ring.
+func bboxPolygon(bbox []float64) [][][]float64 {
+	return [][][]float64{{
+		{bbox[0], bbox[1]},
+		{bbox[2], bbox[1]},
+		{bbox[2], bbox[3]},
+		{bbox[0], bbox[3]},
+		{bbox[0], bbox[1]},
+	}}
+}
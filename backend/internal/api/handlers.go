@@ -2,16 +2,31 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	// Make sure your module name in go.mod is correct. Assuming 'geowatch'.
+	"geowatch-backend/internal/pipeline"
 	"geowatch-backend/internal/storage"
 )
 
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
 // API holds the dependencies for the API handlers, such as the data store.
 type API struct {
 	Store *storage.Store
+	// RejectPipelines is set when scheduled comparisons can't run processing
+	// pipelines (spectral monitoring compares rasters, not images), so that
+	// locations can't be given a pipeline that would never apply.
+	RejectPipelines bool
 }
 
 // NewAPI creates a new API struct with the given store.
@@ -19,25 +34,73 @@ func NewAPI(store *storage.Store) *API {
 	return &API{Store: store}
 }
 
+// RegisterRoutes mounts the location routes on the given router group.
+func (a *API) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("/locations", a.GetLocationsHandler)
+	group.POST("/locations", a.CreateLocationHandler)
+	group.GET("/locations/:id", a.GetLocationHandler)
+	group.PUT("/locations/:id", a.UpdateLocationHandler)
+	group.DELETE("/locations/:id", a.DeleteLocationHandler)
+}
+
 // HealthCheckHandler provides a simple health check endpoint.
 func (a *API) HealthCheckHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
 // GetLocationsHandler handles requests to retrieve locations.
+// Example Request: /api/v1/locations?name=forest&limit=20&offset=40
+// The total number of matching locations is returned in the X-Total-Count header.
 func (a *API) GetLocationsHandler(c *gin.Context) {
-	locations, err := a.Store.GetLocations(c.Request.Context())
+	limit, err := queryInt(c, "limit", defaultPageSize)
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'limit' must be a number between 1 and 500"})
+		return
+	}
+	offset, err := queryInt(c, "offset", 0)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'offset' must be a non-negative number"})
+		return
+	}
+
+	locations, total, err := a.Store.GetLocations(c.Request.Context(), storage.LocationQuery{
+		Name:   c.Query("name"),
+		Limit:  limit,
+		Offset: offset,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.Header("X-Total-Count", strconv.Itoa(total))
 	c.JSON(http.StatusOK, locations)
 }
 
+// GetLocationHandler handles requests to retrieve a single location.
+func (a *API) GetLocationHandler(c *gin.Context) {
+	id, ok := locationID(c)
+	if !ok {
+		return
+	}
+	location, err := a.Store.GetLocation(c.Request.Context(), id)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, location)
+}
+
 // CreateLocationInput defines the expected JSON input for creating a location.
+// The geometry is given either as WKT or as a GeoJSON geometry object.
 type CreateLocationInput struct {
-	Name string `json:"name" binding:"required"`
-	WKT  string `json:"wkt"  binding:"required"`
+	Name     string          `json:"name" binding:"required"`
+	WKT      string          `json:"wkt"`
+	Geometry json.RawMessage `json:"geometry"`
+	// Optional scheduled monitoring settings.
+	MonitorEnabled         *bool  `json:"monitor_enabled"`
+	MonitorIntervalSeconds *int64 `json:"monitor_interval_seconds"`
+	// Optional processing pipeline for scheduled comparisons.
+	Pipeline json.RawMessage `json:"pipeline"`
 }
 
 // CreateLocationHandler handles requests to create a new location.
@@ -47,12 +110,145 @@ func (a *API) CreateLocationHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	settings, ok := a.locationSettings(c, input.MonitorEnabled, input.MonitorIntervalSeconds, input.Pipeline)
+	if !ok {
+		return
+	}
 
-	id, err := a.Store.CreateLocation(c.Request.Context(), input.Name, input.WKT)
+	id, err := a.Store.CreateLocationWithSettings(c.Request.Context(), input.Name, storage.Geometry{
+		WKT:     input.WKT,
+		GeoJSON: input.Geometry,
+	}, settings)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeStoreError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"id": id})
-}
\ No newline at end of file
+}
+
+// UpdateLocationInput defines the JSON input for updating a location. Only the
+// fields present in the request are changed.
+type UpdateLocationInput struct {
+	Name                   *string         `json:"name"`
+	WKT                    string          `json:"wkt"`
+	Geometry               json.RawMessage `json:"geometry"`
+	MonitorEnabled         *bool           `json:"monitor_enabled"`
+	MonitorIntervalSeconds *int64          `json:"monitor_interval_seconds"`
+	// Pipeline replaces the location's processing pipeline; null removes it.
+	Pipeline json.RawMessage `json:"pipeline"`
+}
+
+// UpdateLocationHandler handles requests to change a location.
+func (a *API) UpdateLocationHandler(c *gin.Context) {
+	id, ok := locationID(c)
+	if !ok {
+		return
+	}
+	var input UpdateLocationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	settings, ok := a.locationSettings(c, input.MonitorEnabled, input.MonitorIntervalSeconds, input.Pipeline)
+	if !ok {
+		return
+	}
+
+	update := storage.LocationUpdate{Name: input.Name, LocationSettings: settings}
+	if input.WKT != "" || (len(input.Geometry) > 0 && string(input.Geometry) != "null") {
+		update.Geometry = &storage.Geometry{WKT: input.WKT, GeoJSON: input.Geometry}
+	}
+	ctx := c.Request.Context()
+	if err := a.Store.UpdateLocation(ctx, id, update); err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	location, err := a.Store.GetLocation(ctx, id)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, location)
+}
+
+// DeleteLocationHandler handles requests to delete a location.
+func (a *API) DeleteLocationHandler(c *gin.Context) {
+	id, ok := locationID(c)
+	if !ok {
+		return
+	}
+	if err := a.Store.DeleteLocation(c.Request.Context(), id); err != nil {
+		writeStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// locationSettings validates the monitoring settings and pipeline of a
+// create or update request before anything is written. Absent fields stay
+// nil; a null pipeline removes the current one. It writes a 400 response and
+// returns false if a setting is invalid.
+func (a *API) locationSettings(c *gin.Context, enabled *bool, intervalSeconds *int64, rawPipeline json.RawMessage) (storage.LocationSettings, bool) {
+	settings := storage.LocationSettings{MonitorEnabled: enabled}
+	if intervalSeconds != nil {
+		if *intervalSeconds < 3600 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "'monitor_interval_seconds' must be at least 3600"})
+			return settings, false
+		}
+		interval := time.Duration(*intervalSeconds) * time.Second
+		settings.MonitorInterval = &interval
+	}
+	if len(rawPipeline) > 0 {
+		locationPipeline := json.RawMessage{}
+		if string(rawPipeline) != "null" {
+			if a.RejectPipelines {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "'pipeline' isn't supported with spectral monitoring (MONITOR_MODE=spectral)"})
+				return settings, false
+			}
+			p, err := pipeline.Parse(rawPipeline)
+			if err == nil {
+				locationPipeline, err = json.Marshal(p)
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return settings, false
+			}
+		}
+		settings.Pipeline = &locationPipeline
+	}
+	return settings, true
+}
+
+// locationID parses the :id URL parameter, writing a 400 response if it's invalid.
+func locationID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// queryInt reads an integer query parameter, returning def when it is absent.
+func queryInt(c *gin.Context, name string, def int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// writeStoreError maps store errors onto HTTP responses.
+func writeStoreError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrLocationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+	case errors.Is(err, storage.ErrInvalidGeometry), errors.Is(err, storage.ErrNotMonitorable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("ERROR: Location store operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,213 @@
+// internal/cache/cache.go
+
+// Package cache is a content-addressed disk cache for analysis results. Each
+// entry is stored as <key>.bin with a <key>.json metadata file next to it.
+// Entries expire after a TTL and the least recently used ones are evicted
+// once the cache grows past its size limit.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a cached result.
+type Entry struct {
+	Data        []byte    `json:"-"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Metadata describes how the result was produced, if known.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// Config controls where entries are stored and how long they are kept.
+type Config struct {
+	// Dir is the cache directory.
+	Dir string
+	// TTL is how long an entry stays valid. Zero means entries never expire.
+	TTL time.Duration
+	// MaxBytes bounds the total size of cached data. Zero means no limit.
+	MaxBytes int64
+}
+
+// Cache stores results on disk. A nil *Cache is valid and caches nothing.
+type Cache struct {
+	config Config
+	mu     sync.Mutex
+}
+
+// New creates a Cache, creating its directory if needed.
+func New(config Config) (*Cache, error) {
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &Cache{config: config}, nil
+}
+
+// Key derives a cache key from the JSON encoding of v, which should already
+// be normalised so that equivalent requests encode identically.
+func Key(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cache key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Get returns the entry stored under key, if there is one and it hasn't
+// expired. A hit marks the entry as recently used.
+func (c *Cache) Get(key string) (*Entry, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metaPath, dataPath := c.paths(key)
+	meta, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, false
+	}
+	var entry Entry
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		fmt.Printf("WARNING: Removing corrupt cache entry %s: %v\n", key, err)
+		c.remove(key)
+		return nil, false
+	}
+	if c.expired(entry.CreatedAt, time.Now()) {
+		c.remove(key)
+		return nil, false
+	}
+	if entry.Data, err = os.ReadFile(dataPath); err != nil {
+		c.remove(key)
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(metaPath, now, now)
+	return &entry, true
+}
+
+// Put stores an entry under key and evicts old entries if the cache has
+// grown too large.
+func (c *Cache) Put(key string, entry *Entry) error {
+	if c == nil {
+		return nil
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache metadata: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Write the data before the metadata, so a reader never sees metadata
+	// for data that isn't there yet.
+	metaPath, dataPath := c.paths(key)
+	if err := writeFileAtomic(dataPath, entry.Data); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(metaPath, meta); err != nil {
+		return err
+	}
+	c.evict()
+	return nil
+}
+
+// evict removes expired entries, then the least recently used entries until
+// the cache is within MaxBytes. The caller must hold c.mu.
+func (c *Cache) evict() {
+	type item struct {
+		key    string
+		size   int64
+		usedAt time.Time
+	}
+
+	metas, err := filepath.Glob(filepath.Join(c.config.Dir, "*.json"))
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	var items []item
+	var total int64
+	for _, metaPath := range metas {
+		key := strings.TrimSuffix(filepath.Base(metaPath), ".json")
+		metaInfo, err := os.Stat(metaPath)
+		if err != nil {
+			continue
+		}
+		_, dataPath := c.paths(key)
+		dataInfo, err := os.Stat(dataPath)
+		if err != nil {
+			c.remove(key)
+			continue
+		}
+		var entry Entry
+		if meta, err := os.ReadFile(metaPath); err == nil && json.Unmarshal(meta, &entry) == nil && c.expired(entry.CreatedAt, now) {
+			c.remove(key)
+			continue
+		}
+		items = append(items, item{key: key, size: dataInfo.Size(), usedAt: metaInfo.ModTime()})
+		total += dataInfo.Size()
+	}
+
+	if c.config.MaxBytes <= 0 || total <= c.config.MaxBytes {
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].usedAt.Before(items[j].usedAt) })
+	for _, it := range items {
+		if total <= c.config.MaxBytes {
+			break
+		}
+		c.remove(it.key)
+		total -= it.size
+	}
+}
+
+func (c *Cache) expired(createdAt, now time.Time) bool {
+	return c.config.TTL > 0 && now.Sub(createdAt) > c.config.TTL
+}
+
+func (c *Cache) paths(key string) (metaPath, dataPath string) {
+	base := filepath.Join(c.config.Dir, key)
+	return base + ".json", base + ".bin"
+}
+
+func (c *Cache) remove(key string) {
+	metaPath, dataPath := c.paths(key)
+	os.Remove(metaPath)
+	os.Remove(dataPath)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store cache file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// internal/cache/cache_test.go
+
+package cache
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	type request struct {
+		AOI   [][2]float64 `json:"aoi"`
+		Start string       `json:"start"`
+	}
+	a, err := Key(request{AOI: [][2]float64{{1, 2}}, Start: "2020-01-01"})
+	if err != nil {
+		t.Fatalf("Key() failed: %v", err)
+	}
+	b, _ := Key(request{AOI: [][2]float64{{1, 2}}, Start: "2020-01-01"})
+	c, _ := Key(request{AOI: [][2]float64{{1, 2}}, Start: "2021-01-01"})
+	if a != b {
+		t.Errorf("equal requests have different keys %s and %s", a, b)
+	}
+	if a == c {
+		t.Errorf("different requests share the key %s", a)
+	}
+	if len(a) != 64 {
+		t.Errorf("Key() = %q, want a hex SHA-256", a)
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	c, err := New(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Error("Get() of a missing key hit")
+	}
+	if err := c.Put("k", &Entry{Data: []byte("png bytes"), ContentType: "image/png"}); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	entry, ok := c.Get("k")
+	if !ok {
+		t.Fatal("Get() after Put() missed")
+	}
+	if string(entry.Data) != "png bytes" || entry.ContentType != "image/png" || entry.CreatedAt.IsZero() {
+		t.Errorf("Get() = %+v, want the stored entry", entry)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		age  time.Duration
+		hit  bool
+	}{
+		{"fresh", time.Hour, time.Minute, true},
+		{"expired", time.Hour, 2 * time.Hour, false},
+		{"no TTL", 0, 1000 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(Config{Dir: t.TempDir(), TTL: tt.ttl})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+			entry := &Entry{Data: []byte("x"), CreatedAt: time.Now().Add(-tt.age)}
+			if err := c.Put("k", entry); err != nil {
+				t.Fatalf("Put() failed: %v", err)
+			}
+			if _, ok := c.Get("k"); ok != tt.hit {
+				t.Errorf("Get() hit = %v, want %v", ok, tt.hit)
+			}
+		})
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(Config{Dir: dir, MaxBytes: 10})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	for i, key := range []string{"old", "used"} {
+		if err := c.Put(key, &Entry{Data: []byte("12345")}); err != nil {
+			t.Fatalf("Put(%s) failed: %v", key, err)
+		}
+		// Make the use order explicit rather than relying on timestamps.
+		at := time.Now().Add(time.Duration(i-10) * time.Minute)
+		metaPath, _ := c.paths(key)
+		os.Chtimes(metaPath, at, at)
+	}
+	// Reading "old" makes it the most recently used entry.
+	if _, ok := c.Get("old"); !ok {
+		t.Fatal("Get(old) missed")
+	}
+	if err := c.Put("new", &Entry{Data: []byte("12345")}); err != nil {
+		t.Fatalf("Put(new) failed: %v", err)
+	}
+
+	for key, want := range map[string]bool{"old": true, "used": false, "new": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("Get(%s) hit = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	if err := c.Put("k", &Entry{Data: []byte("x")}); err != nil {
+		t.Errorf("Put() on a nil cache = %v, want nil", err)
+	}
+	if _, ok := c.Get("k"); ok {
+		t.Error("Get() on a nil cache hit")
+	}
+}
@@ -0,0 +1,389 @@
+// internal/composite/composite.go
+
+// Package composite merges several acquisitions of the same area into one
+// image, pixel by pixel, so that clouds and gaps in a single scene are filled
+// from the others. It gives Go-based detection the same robustness as the
+// median composites the GEE service builds over 1-15 July. Only pixels
+// marked usable in a scene's mask take part.
+package composite
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"geowatch-backend/internal/fetcher"
+)
+
+// Method selects how the scenes are combined.
+type Method string
+
+const (
+	// MethodMedian takes the per-band median of the usable pixels.
+	MethodMedian Method = "median"
+	// MethodMaxNDVI takes every band from the scene with the highest NDVI at
+	// that pixel, which favours clear, green observations.
+	MethodMaxNDVI Method = "max_ndvi"
+	// MethodLeastCloudy takes each pixel from the least cloudy scene that has
+	// it, filling gaps from the next least cloudy one.
+	MethodLeastCloudy Method = "least_cloudy"
+)
+
+// Config controls how a composite is built.
+type Config struct {
+	Method Method `json:"method"`
+	// MaxScenes is how many of the best-ranked scenes in the period are
+	// combined.
+	MaxScenes int `json:"max_scenes"`
+}
+
+// DefaultConfig returns the settings used when nothing else is configured.
+func DefaultConfig() Config {
+	return Config{Method: MethodMedian, MaxScenes: 8}
+}
+
+// ParseMethod checks a method name, defaulting to MethodMedian when empty.
+func ParseMethod(name string) (Method, error) {
+	switch m := Method(strings.ToLower(name)); m {
+	case "":
+		return MethodMedian, nil
+	case MethodMedian, MethodMaxNDVI, MethodLeastCloudy:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown composite method %q (expected %q, %q or %q)", name, MethodMedian, MethodMaxNDVI, MethodLeastCloudy)
+	}
+}
+
+// FetchRaster fetches up to config.MaxScenes scenes of the request's period
+// and composites them. The NDVI needed by MethodMaxNDVI is fetched even if
+// the request doesn't include it.
+func FetchRaster(ctx context.Context, f *fetcher.Fetcher, req fetcher.ImageRequest, target time.Time, config Config) (*fetcher.Raster, error) {
+	method, err := ParseMethod(string(config.Method))
+	if err != nil {
+		return nil, err
+	}
+	fetchReq := req
+	if method == MethodMaxNDVI && !hasBand(req.Bands, "NDVI") {
+		fetchReq.Bands = append(append([]string(nil), req.Bands...), "NDVI")
+	}
+
+	rasters, err := f.FetchRasters(ctx, fetchReq, target, config.MaxScenes)
+	if err != nil {
+		return nil, err
+	}
+	out, err := Rasters(rasters, method)
+	if err != nil {
+		return nil, err
+	}
+	return out.Select(req.Bands)
+}
+
+// FetchImage fetches up to config.MaxScenes scenes of the request's period
+// and composites them. MethodMaxNDVI isn't available for images.
+func FetchImage(ctx context.Context, f *fetcher.Fetcher, req fetcher.ImageRequest, target time.Time, config Config) (*fetcher.SatelliteImage, error) {
+	method, err := ParseMethod(string(config.Method))
+	if err != nil {
+		return nil, err
+	}
+	if method == MethodMaxNDVI {
+		return nil, fmt.Errorf("the %s composite needs a raster with NIR, not a display image", method)
+	}
+	images, err := f.FetchImages(ctx, req, target, config.MaxScenes)
+	if err != nil {
+		return nil, err
+	}
+	return Images(images, method)
+}
+
+// Rasters composites rasters of the same size and bands. The result is
+// masked where no scene had a usable pixel.
+func Rasters(rasters []*fetcher.Raster, method Method) (*fetcher.Raster, error) {
+	if len(rasters) == 0 {
+		return nil, fmt.Errorf("no rasters to composite")
+	}
+	first := rasters[0]
+	size := first.Width * first.Height
+	for _, r := range rasters[1:] {
+		if r.Width != first.Width || r.Height != first.Height {
+			return nil, fmt.Errorf("raster dimensions do not match: %dx%d vs %dx%d", first.Width, first.Height, r.Width, r.Height)
+		}
+	}
+	// Put every raster's bands in the order of the first one.
+	aligned := make([]*fetcher.Raster, len(rasters))
+	for i, r := range rasters {
+		selected, err := r.Select(first.Bands)
+		if err != nil {
+			return nil, fmt.Errorf("raster %s: %w", r.ID, err)
+		}
+		if r.Mask != nil && len(r.Mask) != size {
+			return nil, fmt.Errorf("raster %s has a mask of the wrong size", r.ID)
+		}
+		aligned[i] = selected
+	}
+
+	fmt.Printf("INFO: Building %s composite of %d rasters.\n", method, len(rasters))
+	out := fetcher.NewRaster(compositeID(first.ID, method, len(rasters)), latest(rasterTimes(rasters)),
+		first.BBox, first.Width, first.Height, first.Bands)
+	out.SceneID = sceneIDs(rasterSceneIDs(rasters))
+	out.Mask = make(fetcher.Mask, size)
+	nan := float32(math.NaN())
+
+	usable := func(r *fetcher.Raster, i int) bool {
+		if !r.Mask.Valid(i) {
+			return false
+		}
+		for _, band := range r.Data {
+			if band[i] != band[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch method {
+	case MethodMedian:
+		values := make([]float64, 0, len(aligned))
+		for i := 0; i < size; i++ {
+			for b := range out.Data {
+				values = values[:0]
+				for _, r := range aligned {
+					if usable(r, i) {
+						values = append(values, float64(r.Data[b][i]))
+					}
+				}
+				if len(values) == 0 {
+					out.Data[b][i] = nan
+					continue
+				}
+				out.Data[b][i] = float32(median(values))
+				out.Mask[i] = true
+			}
+		}
+	case MethodMaxNDVI:
+		ndvi := make([][]float32, len(aligned))
+		for k, r := range aligned {
+			values, err := r.Band("NDVI")
+			if err != nil {
+				if values, err = r.ComputeIndex("NDVI"); err != nil {
+					return nil, fmt.Errorf("the %s composite needs NDVI: %w", method, err)
+				}
+			}
+			ndvi[k] = values
+		}
+		for i := 0; i < size; i++ {
+			best := -1
+			for k, r := range aligned {
+				if usable(r, i) && ndvi[k][i] == ndvi[k][i] && (best < 0 || ndvi[k][i] > ndvi[best][i]) {
+					best = k
+				}
+			}
+			copyPixel(out, aligned, best, i)
+		}
+	case MethodLeastCloudy:
+		order := leastCloudyOrder(len(aligned), func(k int) fetcher.Mask { return aligned[k].Mask })
+		for i := 0; i < size; i++ {
+			best := -1
+			for _, k := range order {
+				if usable(aligned[k], i) {
+					best = k
+					break
+				}
+			}
+			copyPixel(out, aligned, best, i)
+		}
+	default:
+		return nil, fmt.Errorf("unknown composite method %q", method)
+	}
+
+	fmt.Printf("INFO: %.0f%% of the composite has usable data.\n", 100*out.Mask.ValidFraction())
+	return out, nil
+}
+
+// Images composites display images of the same size with MethodMedian
+// (per channel) or MethodLeastCloudy. Pixels without any usable scene are
+// transparent and masked.
+func Images(images []*fetcher.SatelliteImage, method Method) (*fetcher.SatelliteImage, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images to composite")
+	}
+	for _, img := range images {
+		if img == nil || img.ImageData == nil {
+			return nil, fmt.Errorf("cannot composite nil images")
+		}
+	}
+	first := images[0]
+	bounds := first.ImageData.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	for _, img := range images[1:] {
+		if img.ImageData.Bounds().Dx() != width || img.ImageData.Bounds().Dy() != height {
+			return nil, fmt.Errorf("image dimensions do not match")
+		}
+	}
+	for _, img := range images {
+		if img.Mask != nil && len(img.Mask) != width*height {
+			return nil, fmt.Errorf("image %s has a mask of the wrong size", img.ID)
+		}
+	}
+
+	fmt.Printf("INFO: Building %s composite of %d images.\n", method, len(images))
+	pixel := func(img *fetcher.SatelliteImage, x, y int) color.NRGBA {
+		b := img.ImageData.Bounds()
+		return color.NRGBAModel.Convert(img.ImageData.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+	}
+	usable := func(img *fetcher.SatelliteImage, x, y int) bool {
+		return img.Mask.Valid(y*width+x) && pixel(img, x, y).A > 0
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	mask := make(fetcher.Mask, width*height)
+	switch method {
+	case MethodMedian:
+		var r, g, b []float64
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				r, g, b = r[:0], g[:0], b[:0]
+				for _, img := range images {
+					if usable(img, x, y) {
+						c := pixel(img, x, y)
+						r, g, b = append(r, float64(c.R)), append(g, float64(c.G)), append(b, float64(c.B))
+					}
+				}
+				if len(r) == 0 {
+					continue
+				}
+				out.SetNRGBA(x, y, color.NRGBA{R: round8(median(r)), G: round8(median(g)), B: round8(median(b)), A: 255})
+				mask[y*width+x] = true
+			}
+		}
+	case MethodLeastCloudy:
+		order := leastCloudyOrder(len(images), func(k int) fetcher.Mask { return images[k].Mask })
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				for _, k := range order {
+					if usable(images[k], x, y) {
+						c := pixel(images[k], x, y)
+						c.A = 255
+						out.SetNRGBA(x, y, c)
+						mask[y*width+x] = true
+						break
+					}
+				}
+			}
+		}
+	case MethodMaxNDVI:
+		return nil, fmt.Errorf("the %s composite needs a raster with NIR, not a display image", method)
+	default:
+		return nil, fmt.Errorf("unknown composite method %q", method)
+	}
+
+	times := make([]time.Time, len(images))
+	ids := make([]string, len(images))
+	for i, img := range images {
+		times[i], ids[i] = img.AcquiredAt, img.SceneID
+	}
+	fmt.Printf("INFO: %.0f%% of the composite has usable data.\n", 100*mask.ValidFraction())
+	return &fetcher.SatelliteImage{
+		ID:         compositeID(first.ID, method, len(images)),
+		SceneID:    sceneIDs(ids),
+		AcquiredAt: latest(times),
+		ImageData:  out,
+		Mask:       mask,
+	}, nil
+}
+
+// copyPixel copies pixel i of every band from source k, or marks it as
+// missing when k is -1.
+func copyPixel(out *fetcher.Raster, sources []*fetcher.Raster, k, i int) {
+	for b := range out.Data {
+		if k < 0 {
+			out.Data[b][i] = float32(math.NaN())
+		} else {
+			out.Data[b][i] = sources[k].Data[b][i]
+		}
+	}
+	out.Mask[i] = k >= 0
+}
+
+// leastCloudyOrder returns the scene indices sorted by usable share, most
+// usable first, keeping the catalog ranking on a tie.
+func leastCloudyOrder(n int, mask func(int) fetcher.Mask) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return mask(order[a]).ValidFraction() > mask(order[b]).ValidFraction()
+	})
+	return order
+}
+
+// median returns the median of the values, averaging the middle two for an
+// even count. The slice is sorted in place.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 1 {
+		return values[mid]
+	}
+	return (values[mid-1] + values[mid]) / 2
+}
+
+func round8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+func hasBand(bands []string, name string) bool {
+	for _, b := range bands {
+		if strings.EqualFold(b, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func compositeID(firstID string, method Method, n int) string {
+	return fmt.Sprintf("%s_%s_composite_of_%d", firstID, method, n)
+}
+
+// sceneIDs lists the known source scene IDs, comma-separated.
+func sceneIDs(ids []string) string {
+	var known []string
+	for _, id := range ids {
+		if id != "" {
+			known = append(known, id)
+		}
+	}
+	return strings.Join(known, ",")
+}
+
+func rasterSceneIDs(rasters []*fetcher.Raster) []string {
+	ids := make([]string, len(rasters))
+	for i, r := range rasters {
+		ids[i] = r.SceneID
+	}
+	return ids
+}
+
+func rasterTimes(rasters []*fetcher.Raster) []time.Time {
+	times := make([]time.Time, len(rasters))
+	for i, r := range rasters {
+		times[i] = r.AcquiredAt
+	}
+	return times
+}
+
+// latest returns the most recent of the acquisition times.
+func latest(times []time.Time) time.Time {
+	var t time.Time
+	for _, at := range times {
+		if at.After(t) {
+			t = at
+		}
+	}
+	return t
+}
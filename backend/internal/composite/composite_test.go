@@ -0,0 +1,192 @@
+// internal/composite/composite_test.go
+
+package composite
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+	"time"
+
+	"geowatch-backend/internal/fetcher"
+)
+
+func TestParseMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Method
+		wantErr bool
+	}{
+		{"", MethodMedian, false},
+		{"median", MethodMedian, false},
+		{"MAX_NDVI", MethodMaxNDVI, false},
+		{"least_cloudy", MethodLeastCloudy, false},
+		{"mean", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMethod(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMethod(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMethod(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// raster builds a 2x1 raster with B04 and B08 bands.
+func raster(id string, day int, red, nir []float32, mask fetcher.Mask) *fetcher.Raster {
+	r := fetcher.NewRaster(id, time.Date(2024, 7, day, 0, 0, 0, 0, time.UTC), []float64{0, 0, 1, 1}, 2, 1, []string{"B04", "B08"})
+	copy(r.Data[0], red)
+	copy(r.Data[1], nir)
+	r.SceneID = id
+	r.Mask = mask
+	return r
+}
+
+func TestRasters(t *testing.T) {
+	nan := float32(math.NaN())
+	// a is clear everywhere, b has a cloud over pixel 1, c is missing
+	// pixel 0.
+	a := raster("a", 1, []float32{0.1, 0.4}, []float32{0.5, 0.5}, nil)
+	b := raster("b", 3, []float32{0.3, 0.9}, []float32{0.9, 0.9}, fetcher.Mask{true, false})
+	c := raster("c", 2, []float32{nan, 0.2}, []float32{nan, 0.3}, nil)
+
+	tests := []struct {
+		method   Method
+		wantRed  []float32
+		wantMask fetcher.Mask
+	}{
+		// Pixel 0: median of 0.1 and 0.3; pixel 1: median of 0.4 and 0.2.
+		{MethodMedian, []float32{0.2, 0.3}, fetcher.Mask{true, true}},
+		// Pixel 0: NDVI is 0.67 in a and 0.5 in b; pixel 1: 0.11 in a and 0.2 in c.
+		{MethodMaxNDVI, []float32{0.1, 0.2}, fetcher.Mask{true, true}},
+		// a and c have no mask, so they rank ahead of b and a comes first.
+		{MethodLeastCloudy, []float32{0.1, 0.4}, fetcher.Mask{true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			out, err := Rasters([]*fetcher.Raster{a, b, c}, tt.method)
+			if err != nil {
+				t.Fatalf("Rasters() failed: %v", err)
+			}
+			for i, want := range tt.wantRed {
+				if math.Abs(float64(out.Data[0][i]-want)) > 1e-6 {
+					t.Errorf("B04[%d] = %v, want %v", i, out.Data[0][i], want)
+				}
+				if out.Mask[i] != tt.wantMask[i] {
+					t.Errorf("Mask[%d] = %v, want %v", i, out.Mask[i], tt.wantMask[i])
+				}
+			}
+			if out.SceneID != "a,b,c" {
+				t.Errorf("SceneID = %q, want %q", out.SceneID, "a,b,c")
+			}
+			if !out.AcquiredAt.Equal(b.AcquiredAt) {
+				t.Errorf("AcquiredAt = %v, want the latest scene's %v", out.AcquiredAt, b.AcquiredAt)
+			}
+		})
+	}
+}
+
+func TestRastersMasksUncoveredPixels(t *testing.T) {
+	a := raster("a", 1, []float32{0.1, 0.4}, []float32{0.5, 0.5}, fetcher.Mask{true, false})
+	b := raster("b", 2, []float32{0.3, 0.9}, []float32{0.9, 0.9}, fetcher.Mask{true, false})
+	out, err := Rasters([]*fetcher.Raster{a, b}, MethodMedian)
+	if err != nil {
+		t.Fatalf("Rasters() failed: %v", err)
+	}
+	if out.Mask[1] || out.Data[0][1] == out.Data[0][1] {
+		t.Errorf("pixel 1 = %v (mask %v), want NaN and masked", out.Data[0][1], out.Mask[1])
+	}
+}
+
+func TestRastersRejectsBadInput(t *testing.T) {
+	a := raster("a", 1, []float32{0.1, 0.4}, []float32{0.5, 0.5}, nil)
+	wide := fetcher.NewRaster("wide", time.Time{}, nil, 3, 1, []string{"B04", "B08"})
+	redOnly := fetcher.NewRaster("red", time.Time{}, nil, 2, 1, []string{"B04"})
+	badMask := raster("mask", 1, []float32{0, 0}, []float32{0, 0}, fetcher.Mask{true})
+
+	tests := []struct {
+		name    string
+		rasters []*fetcher.Raster
+		method  Method
+	}{
+		{"empty", nil, MethodMedian},
+		{"size mismatch", []*fetcher.Raster{a, wide}, MethodMedian},
+		{"missing band", []*fetcher.Raster{a, redOnly}, MethodMedian},
+		{"wrong mask size", []*fetcher.Raster{a, badMask}, MethodMedian},
+		{"no NDVI", []*fetcher.Raster{redOnly}, MethodMaxNDVI},
+		{"unknown method", []*fetcher.Raster{a}, "mean"},
+	}
+	for _, tt := range tests {
+		if _, err := Rasters(tt.rasters, tt.method); err == nil {
+			t.Errorf("%s: Rasters() succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func solid(id string, c color.NRGBA, mask fetcher.Mask) *fetcher.SatelliteImage {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	for x := 0; x < 2; x++ {
+		img.SetNRGBA(x, 0, c)
+	}
+	return &fetcher.SatelliteImage{ID: id, SceneID: id, ImageData: img, Mask: mask}
+}
+
+func TestImages(t *testing.T) {
+	dark := solid("dark", color.NRGBA{R: 10, G: 20, B: 30, A: 255}, fetcher.Mask{true, false})
+	mid := solid("mid", color.NRGBA{R: 20, G: 40, B: 60, A: 255}, nil)
+	bright := solid("bright", color.NRGBA{R: 200, G: 200, B: 200, A: 255}, fetcher.Mask{false, false})
+
+	tests := []struct {
+		method Method
+		want   [2]color.NRGBA
+	}{
+		// Pixel 0 has dark and mid (median 15, 30, 45); pixel 1 only mid.
+		{MethodMedian, [2]color.NRGBA{{R: 15, G: 30, B: 45, A: 255}, {R: 20, G: 40, B: 60, A: 255}}},
+		// mid has no mask, so it is the least cloudy everywhere.
+		{MethodLeastCloudy, [2]color.NRGBA{{R: 20, G: 40, B: 60, A: 255}, {R: 20, G: 40, B: 60, A: 255}}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			out, err := Images([]*fetcher.SatelliteImage{dark, mid, bright}, tt.method)
+			if err != nil {
+				t.Fatalf("Images() failed: %v", err)
+			}
+			for x, want := range tt.want {
+				if got := out.ImageData.(*image.NRGBA).NRGBAAt(x, 0); got != want {
+					t.Errorf("pixel %d = %v, want %v", x, got, want)
+				}
+				if !out.Mask[x] {
+					t.Errorf("pixel %d is masked", x)
+				}
+			}
+			if out.SceneID != "dark,mid,bright" {
+				t.Errorf("SceneID = %q, want %q", out.SceneID, "dark,mid,bright")
+			}
+		})
+	}
+}
+
+func TestImagesRejectsBadInput(t *testing.T) {
+	a := solid("a", color.NRGBA{A: 255}, nil)
+	wide := &fetcher.SatelliteImage{ID: "wide", ImageData: image.NewNRGBA(image.Rect(0, 0, 3, 1))}
+
+	tests := []struct {
+		name   string
+		images []*fetcher.SatelliteImage
+		method Method
+	}{
+		{"empty", nil, MethodMedian},
+		{"nil image", []*fetcher.SatelliteImage{a, nil}, MethodMedian},
+		{"size mismatch", []*fetcher.SatelliteImage{a, wide}, MethodMedian},
+		{"max NDVI", []*fetcher.SatelliteImage{a}, MethodMaxNDVI},
+	}
+	for _, tt := range tests {
+		if _, err := Images(tt.images, tt.method); err == nil {
+			t.Errorf("%s: Images() succeeded, want an error", tt.name)
+		}
+	}
+}
@@ -9,17 +9,51 @@ import (
 	"geowatch-backend/internal/fetcher"
 )
 
+// Mode selects which change detection algorithm is used.
+type Mode string
+
+const (
+	// ModeVisual compares the RGB colour of true-colour images.
+	ModeVisual Mode = "visual"
+	// ModeSpectral compares NDVI/NDWI/NDBI rasters like the GEE service does.
+	ModeSpectral Mode = "spectral"
+)
+
 // Result contains the output of a change detection analysis.
 type Result struct {
+	// Mode is the algorithm that produced the result.
+	Mode Mode
 	// The image overlay showing changes.
 	ChangeOverlay image.Image
 	// A metric for the amount of change, e.g., number of changed pixels.
 	ChangeSeverity int
+	// Magnitude holds the per-pixel change value (colour distance or summed
+	// squared index difference), Width*Height values row by row.
+	Magnitude []float32
+	Width     int
+	Height    int
+	// Stats summarises Magnitude.
+	Stats Stats
+	// Threshold is the magnitude above which a pixel counted as changed, and
+	// Histogram the distribution it was chosen from. Both are only set by
+	// detectors that threshold the magnitude.
+	Threshold float64
+	Histogram *Histogram
+	// ValidFraction is the share of pixels usable in both images. Pixels
+	// masked as cloud, shadow or missing in either image have NaN magnitude
+	// and are never counted as changed.
+	ValidFraction float64
 }
 
 // VisualChange detects differences between two images by comparing pixel colors.
 // It returns a result struct containing the overlay and the severity.
 func VisualChange(imageA, imageB *fetcher.SatelliteImage, threshold float64) (*Result, error) {
+	return VisualChangeWithOptions(imageA, imageB, Options{Strategy: ThresholdFixed, Threshold: threshold})
+}
+
+// VisualChangeWithOptions is VisualChange with a configurable threshold strategy.
+// The threshold actually used and the distance histogram are reported in the result.
+func VisualChangeWithOptions(imageA, imageB *fetcher.SatelliteImage, opts Options) (*Result, error) {
 	fmt.Println("INFO: Starting visual change detection...")
 
 	if imageA == nil || imageB == nil || imageA.ImageData == nil || imageB.ImageData == nil {
@@ -31,17 +65,40 @@ func VisualChange(imageA, imageB *fetcher.SatelliteImage, threshold float64) (*R
 		return nil, fmt.Errorf("image dimensions do not match")
 	}
 
-	diffImage := image.NewRGBA(boundsA)
-	highlightColor := color.RGBA{R: 255, G: 0, B: 0, A: 180}
-	changedPixels := 0
-
+	width, height := boundsA.Dx(), boundsA.Dy()
+	mask, err := combinedMask(imageA.Mask, imageB.Mask, width*height)
+	if err != nil {
+		return nil, err
+	}
+	nan := float32(math.NaN())
+	magnitude := make([]float32, width*height)
 	for y := boundsA.Min.Y; y < boundsA.Max.Y; y++ {
 		for x := boundsA.Min.X; x < boundsA.Max.X; x++ {
+			i := (y-boundsA.Min.Y)*width + (x - boundsA.Min.X)
+			if !mask.Valid(i) {
+				magnitude[i] = nan
+				continue
+			}
 			colorA := imageA.ImageData.At(x, y)
 			colorB := imageB.ImageData.At(x, y)
-			distance := colorDistance(colorA, colorB)
+			magnitude[i] = float32(colorDistance(colorA, colorB))
+		}
+	}
+	validFraction := mask.ValidFraction()
+	fmt.Printf("INFO: %.0f%% of pixels are usable in both images.\n", 100*validFraction)
+
+	threshold, histogram, err := SelectThreshold(magnitude, opts)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("INFO: Using %s threshold %.2f.\n", opts.strategy(), threshold)
 
-			if distance > threshold {
+	diffImage := image.NewRGBA(boundsA)
+	highlightColor := color.RGBA{R: 255, G: 0, B: 0, A: 180}
+	changedPixels := 0
+	for y := boundsA.Min.Y; y < boundsA.Max.Y; y++ {
+		for x := boundsA.Min.X; x < boundsA.Max.X; x++ {
+			if float64(magnitude[(y-boundsA.Min.Y)*width+(x-boundsA.Min.X)]) > threshold {
 				diffImage.Set(x, y, highlightColor)
 				changedPixels++
 			} else {
@@ -51,14 +108,31 @@ func VisualChange(imageA, imageB *fetcher.SatelliteImage, threshold float64) (*R
 	}
 
 	result := &Result{
+		Mode:           ModeVisual,
 		ChangeOverlay:  diffImage,
 		ChangeSeverity: changedPixels,
+		Magnitude:      magnitude,
+		Width:          width,
+		Height:         height,
+		Stats:          ComputeStats(magnitude),
+		Threshold:      threshold,
+		Histogram:      histogram,
+		ValidFraction:  validFraction,
 	}
 
 	fmt.Printf("INFO: Change detection finished. Found %d changed pixels.\n", changedPixels)
 	return result, nil
 }
 
+// combinedMask merges the masks of the two compared images, checking that
+// they match the image size.
+func combinedMask(a, b fetcher.Mask, size int) (fetcher.Mask, error) {
+	if a != nil && len(a) != size || b != nil && len(b) != size {
+		return nil, fmt.Errorf("image masks do not match the image size")
+	}
+	return fetcher.CombineMasks(a, b), nil
+}
+
 // colorDistance calculates the Euclidean distance between two colors.
 func colorDistance(c1, c2 color.Color) float64 {
 	r1, g1, b1, _ := c1.RGBA()
@@ -0,0 +1,248 @@
+// internal/detection/spectral.go
+
+package detection
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"geowatch-backend/internal/fetcher"
+)
+
+// SpectralBands are the indices compared by SpectralChange. They match the
+// bands selected in the GEE service's create_analysis_composite.
+var SpectralBands = []string{"NDVI", "NDWI", "NDBI"}
+
+// ChangePalette is the blue → yellow → orange → red ramp used by the GEE
+// service to render change magnitude.
+var ChangePalette = []color.RGBA{
+	{R: 0x00, G: 0x00, B: 0xFF, A: 0xFF},
+	{R: 0xFF, G: 0xFF, B: 0x00, A: 0xFF},
+	{R: 0xFF, G: 0xA5, B: 0x00, A: 0xFF},
+	{R: 0xFF, G: 0x00, B: 0x00, A: 0xFF},
+}
+
+// Stats summarises a change magnitude raster, ignoring pixels without data.
+type Stats struct {
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Mean        float64 `json:"mean"`
+	StdDev      float64 `json:"std_dev"`
+	P98         float64 `json:"p98"`
+	ValidPixels int     `json:"valid_pixels"`
+}
+
+// SpectralChange computes the same change magnitude as the GEE service: the sum
+// over NDVI, NDWI and NDBI of the squared after-minus-before difference. The
+// overlay is stretched between the minimum and the 98th percentile and painted
+// with ChangePalette; pixels with zero change, no data or cloud in either
+// raster stay transparent. Like the service's selfMask(), every pixel with any
+// change at all is painted, so ChangeSeverity counts pixels that changed by
+// any amount, not significant change; use SpectralChangeWithOptions for that.
+func SpectralChange(before, after *fetcher.Raster) (*Result, error) {
+	return SpectralChangeWithOptions(before, after, Options{Strategy: ThresholdFixed})
+}
+
+// SpectralChangeWithOptions is SpectralChange with a configurable threshold:
+// only pixels whose magnitude is above it are painted and counted in
+// ChangeSeverity, so the overlay can be vectorized like a visual result.
+func SpectralChangeWithOptions(before, after *fetcher.Raster, opts Options) (*Result, error) {
+	fmt.Println("INFO: Starting spectral change detection...")
+
+	magnitude, err := ChangeMagnitude(before, after, SpectralBands)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := ComputeStats(magnitude)
+	fmt.Printf("INFO: Dynamic range found: min=%f, p98_max=%f\n", stats.Min, stats.P98)
+
+	threshold, histogram, err := SelectThreshold(magnitude, opts)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("INFO: Using %s threshold %f.\n", opts.strategy(), threshold)
+
+	nan := float32(math.NaN())
+	changed := make([]float32, len(magnitude))
+	changedPixels := 0
+	for i, v := range magnitude {
+		if isNaN32(v) || float64(v) <= threshold {
+			changed[i] = nan
+			continue
+		}
+		changed[i] = v
+		changedPixels++
+	}
+	overlay := RenderPalette(changed, before.Width, before.Height, stats.Min, stats.P98, ChangePalette)
+
+	fmt.Printf("INFO: Spectral change detection finished. %d pixels changed.\n", changedPixels)
+	return &Result{
+		Mode:           ModeSpectral,
+		ChangeOverlay:  overlay,
+		ChangeSeverity: changedPixels,
+		Magnitude:      magnitude,
+		Width:          before.Width,
+		Height:         before.Height,
+		Stats:          stats,
+		Threshold:      threshold,
+		Histogram:      histogram,
+		ValidFraction:  float64(stats.ValidPixels) / float64(len(magnitude)),
+	}, nil
+}
+
+// ChangeMagnitude returns, for every pixel, the sum of squared differences of
+// the given bands between two rasters. Spectral indices missing from a raster
+// are derived from its raw bands. Pixels missing in any band or masked in
+// either raster are NaN.
+func ChangeMagnitude(before, after *fetcher.Raster, bands []string) ([]float32, error) {
+	if before == nil || after == nil {
+		return nil, fmt.Errorf("cannot compare nil rasters")
+	}
+	if before.Width != after.Width || before.Height != after.Height {
+		return nil, fmt.Errorf("raster dimensions do not match: %dx%d vs %dx%d",
+			before.Width, before.Height, after.Width, after.Height)
+	}
+
+	a, err := before.Select(bands)
+	if err != nil {
+		return nil, fmt.Errorf("before raster: %w", err)
+	}
+	b, err := after.Select(bands)
+	if err != nil {
+		return nil, fmt.Errorf("after raster: %w", err)
+	}
+
+	mask, err := combinedMask(before.Mask, after.Mask, before.Width*before.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	magnitude := make([]float32, before.Width*before.Height)
+	for i := range magnitude {
+		if !mask.Valid(i) {
+			magnitude[i] = float32(math.NaN())
+			continue
+		}
+		var sum float32
+		for band := range bands {
+			diff := b.Data[band][i] - a.Data[band][i]
+			sum += diff * diff
+		}
+		// NaN propagates through the sum, so missing data stays missing.
+		magnitude[i] = sum
+	}
+	return magnitude, nil
+}
+
+// ComputeStats returns min, max, mean, standard deviation and the 98th
+// percentile of the values, skipping NaN.
+func ComputeStats(values []float32) Stats {
+	valid := make([]float64, 0, len(values))
+	for _, v := range values {
+		if !isNaN32(v) {
+			valid = append(valid, float64(v))
+		}
+	}
+	if len(valid) == 0 {
+		return Stats{}
+	}
+	sort.Float64s(valid)
+
+	var sum float64
+	for _, v := range valid {
+		sum += v
+	}
+	mean := sum / float64(len(valid))
+	var variance float64
+	for _, v := range valid {
+		variance += (v - mean) * (v - mean)
+	}
+
+	return Stats{
+		Min:         valid[0],
+		Max:         valid[len(valid)-1],
+		Mean:        mean,
+		StdDev:      math.Sqrt(variance / float64(len(valid))),
+		P98:         percentileSorted(valid, 98),
+		ValidPixels: len(valid),
+	}
+}
+
+// Percentile returns the p-th percentile (0-100) of the values, skipping NaN.
+func Percentile(values []float32, p float64) float64 {
+	valid := make([]float64, 0, len(values))
+	for _, v := range values {
+		if !isNaN32(v) {
+			valid = append(valid, float64(v))
+		}
+	}
+	if len(valid) == 0 {
+		return 0
+	}
+	sort.Float64s(valid)
+	return percentileSorted(valid, p)
+}
+
+// percentileSorted interpolates linearly between the closest ranks.
+func percentileSorted(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	pos := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	if lower < 0 {
+		return sorted[0]
+	}
+	if upper >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(lower)
+	return sorted[lower] + frac*(sorted[upper]-sorted[lower])
+}
+
+// RenderPalette paints values stretched between min and max with a linearly
+// interpolated palette, like Earth Engine's visualization options. Zero and NaN
+// pixels are transparent, matching selfMask() in the GEE service.
+func RenderPalette(values []float32, width, height int, min, max float64, palette []color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := values[y*width+x]
+			if isNaN32(v) || v == 0 {
+				continue
+			}
+			img.SetRGBA(x, y, paletteColor(float64(v), min, max, palette))
+		}
+	}
+	return img
+}
+
+// paletteColor maps a value to a colour on the stretched palette.
+func paletteColor(v, min, max float64, palette []color.RGBA) color.RGBA {
+	t := 0.0
+	if max > min {
+		t = (v - min) / (max - min)
+	}
+	t = math.Max(0, math.Min(1, t))
+
+	pos := t * float64(len(palette)-1)
+	i := int(math.Floor(pos))
+	if i >= len(palette)-1 {
+		return palette[len(palette)-1]
+	}
+	frac := pos - float64(i)
+	c0, c1 := palette[i], palette[i+1]
+	lerp := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + frac*(float64(b)-float64(a))))
+	}
+	return color.RGBA{R: lerp(c0.R, c1.R), G: lerp(c0.G, c1.G), B: lerp(c0.B, c1.B), A: lerp(c0.A, c1.A)}
+}
+
+func isNaN32(v float32) bool {
+	return v != v
+}
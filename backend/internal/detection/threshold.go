@@ -0,0 +1,165 @@
+// internal/detection/threshold.go
+
+package detection
+
+import (
+	"fmt"
+)
+
+// ThresholdStrategy selects how the change threshold is chosen.
+type ThresholdStrategy string
+
+const (
+	// ThresholdFixed uses Options.Threshold as-is.
+	ThresholdFixed ThresholdStrategy = "fixed"
+	// ThresholdOtsu splits the magnitude histogram with Otsu's method.
+	ThresholdOtsu ThresholdStrategy = "otsu"
+	// ThresholdPercentile flags the pixels above Options.Percentile.
+	ThresholdPercentile ThresholdStrategy = "percentile"
+	// ThresholdMeanStd uses mean + K standard deviations.
+	ThresholdMeanStd ThresholdStrategy = "mean_std"
+)
+
+// DefaultHistogramBins is the histogram resolution used when Options.Bins is 0.
+const DefaultHistogramBins = 256
+
+// Options configures how a detector turns change magnitude into changed pixels.
+type Options struct {
+	// Strategy defaults to ThresholdFixed.
+	Strategy ThresholdStrategy `json:"strategy"`
+	// Threshold is the fixed threshold for ThresholdFixed.
+	Threshold float64 `json:"threshold,omitempty"`
+	// Percentile (0-100) is used by ThresholdPercentile, e.g. 95.
+	Percentile float64 `json:"percentile,omitempty"`
+	// K is the number of standard deviations above the mean for ThresholdMeanStd.
+	K float64 `json:"k,omitempty"`
+	// Bins is the number of histogram bins, DefaultHistogramBins if zero.
+	Bins int `json:"bins,omitempty"`
+}
+
+func (o Options) strategy() ThresholdStrategy {
+	if o.Strategy == "" {
+		return ThresholdFixed
+	}
+	return o.Strategy
+}
+
+// Histogram is an equal-width histogram of change magnitude.
+type Histogram struct {
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	BinWidth float64 `json:"bin_width"`
+	Counts   []int   `json:"counts"`
+}
+
+// BuildHistogram bins the non-NaN values between their minimum and maximum.
+func BuildHistogram(values []float32, bins int) *Histogram {
+	if bins <= 0 {
+		bins = DefaultHistogramBins
+	}
+	h := &Histogram{Counts: make([]int, bins)}
+
+	first := true
+	for _, v := range values {
+		if isNaN32(v) {
+			continue
+		}
+		if first || float64(v) < h.Min {
+			h.Min = float64(v)
+		}
+		if first || float64(v) > h.Max {
+			h.Max = float64(v)
+		}
+		first = false
+	}
+	if first {
+		return h
+	}
+
+	h.BinWidth = (h.Max - h.Min) / float64(bins)
+	for _, v := range values {
+		if isNaN32(v) {
+			continue
+		}
+		h.Counts[h.bin(float64(v))]++
+	}
+	return h
+}
+
+// bin returns the index of the bin holding v.
+func (h *Histogram) bin(v float64) int {
+	if h.BinWidth == 0 {
+		return 0
+	}
+	i := int((v - h.Min) / h.BinWidth)
+	if i >= len(h.Counts) {
+		i = len(h.Counts) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+// Otsu returns the value that best separates the histogram into two classes
+// by maximising the between-class variance. It is the upper edge of the last
+// "unchanged" bin.
+func (h *Histogram) Otsu() float64 {
+	total := 0
+	var sumAll float64
+	for i, c := range h.Counts {
+		total += c
+		sumAll += float64(i) * float64(c)
+	}
+	if total == 0 || h.BinWidth == 0 {
+		return h.Max
+	}
+
+	var sumBelow, bestVariance float64
+	weightBelow, best := 0, 0
+	for i, c := range h.Counts {
+		weightBelow += c
+		if weightBelow == 0 {
+			continue
+		}
+		weightAbove := total - weightBelow
+		if weightAbove == 0 {
+			break
+		}
+		sumBelow += float64(i) * float64(c)
+		meanBelow := sumBelow / float64(weightBelow)
+		meanAbove := (sumAll - sumBelow) / float64(weightAbove)
+		variance := float64(weightBelow) * float64(weightAbove) * (meanBelow - meanAbove) * (meanBelow - meanAbove)
+		if variance > bestVariance {
+			bestVariance = variance
+			best = i
+		}
+	}
+	return h.Min + float64(best+1)*h.BinWidth
+}
+
+// SelectThreshold picks the change threshold for the magnitude values using
+// the configured strategy and returns it with the histogram it was based on.
+func SelectThreshold(values []float32, opts Options) (float64, *Histogram, error) {
+	histogram := BuildHistogram(values, opts.Bins)
+
+	switch opts.strategy() {
+	case ThresholdFixed:
+		return opts.Threshold, histogram, nil
+	case ThresholdOtsu:
+		return histogram.Otsu(), histogram, nil
+	case ThresholdPercentile:
+		if opts.Percentile <= 0 || opts.Percentile >= 100 {
+			return 0, nil, fmt.Errorf("percentile must be between 0 and 100, got %v", opts.Percentile)
+		}
+		return Percentile(values, opts.Percentile), histogram, nil
+	case ThresholdMeanStd:
+		if opts.K < 0 {
+			return 0, nil, fmt.Errorf("k must not be negative, got %v", opts.K)
+		}
+		stats := ComputeStats(values)
+		return stats.Mean + opts.K*stats.StdDev, histogram, nil
+	default:
+		return 0, nil, fmt.Errorf("unknown threshold strategy %q", opts.Strategy)
+	}
+}
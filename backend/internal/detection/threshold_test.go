@@ -0,0 +1,129 @@
+// internal/detection/threshold_test.go
+
+package detection
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	nan := float32(math.NaN())
+	tests := []struct {
+		name   string
+		values []float32
+		p      float64
+		want   float64
+	}{
+		{"empty", nil, 50, 0},
+		{"single value", []float32{7}, 90, 7},
+		{"median of odd count", []float32{3, 1, 2}, 50, 2},
+		{"interpolates between ranks", []float32{0, 10}, 25, 2.5},
+		{"maximum", []float32{4, 9, 1}, 100, 9},
+		{"ignores NaN", []float32{nan, 1, nan, 3}, 50, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Percentile(tt.values, tt.p); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Percentile(%v, %v) = %v, want %v", tt.values, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildHistogram(t *testing.T) {
+	h := BuildHistogram([]float32{0, 1, 2, 3, 4, float32(math.NaN())}, 4)
+	if h.Min != 0 || h.Max != 4 || h.BinWidth != 1 {
+		t.Fatalf("got min %v, max %v, bin width %v; want 0, 4, 1", h.Min, h.Max, h.BinWidth)
+	}
+	want := []int{1, 1, 1, 2}
+	for i, c := range want {
+		if h.Counts[i] != c {
+			t.Errorf("bin %d has %d values, want %d", i, h.Counts[i], c)
+		}
+	}
+}
+
+func TestOtsu(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float32
+		min, max float64
+	}{
+		{
+			name:   "separates two clusters",
+			values: append(repeat(1, 100), repeat(9, 20)...),
+			min:    1, max: 9,
+		},
+		{
+			name:   "separates unequal spreads",
+			values: []float32{0, 0.5, 1, 1, 1.5, 2, 8, 8.5, 9, 10},
+			min:    2, max: 8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildHistogram(tt.values, 64).Otsu()
+			if got <= tt.min || got > tt.max {
+				t.Errorf("Otsu() = %v, want a value in (%v, %v]", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestOtsuDegenerate(t *testing.T) {
+	if got := BuildHistogram(repeat(5, 10), 16).Otsu(); got != 5 {
+		t.Errorf("Otsu() of a constant = %v, want 5", got)
+	}
+	if got := BuildHistogram(nil, 16).Otsu(); got != 0 {
+		t.Errorf("Otsu() of no values = %v, want 0", got)
+	}
+}
+
+func TestSelectThreshold(t *testing.T) {
+	values := make([]float32, 101)
+	for i := range values {
+		values[i] = float32(i)
+	}
+	tests := []struct {
+		name    string
+		opts    Options
+		want    float64
+		wantErr bool
+	}{
+		{"fixed by default", Options{Threshold: 42}, 42, false},
+		{"percentile", Options{Strategy: ThresholdPercentile, Percentile: 95}, 95, false},
+		{"mean plus zero std", Options{Strategy: ThresholdMeanStd}, 50, false},
+		{"percentile out of range", Options{Strategy: ThresholdPercentile, Percentile: 100}, 0, true},
+		{"negative k", Options{Strategy: ThresholdMeanStd, K: -1}, 0, true},
+		{"unknown strategy", Options{Strategy: "magic"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, histogram, err := SelectThreshold(values, tt.opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("SelectThreshold() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SelectThreshold() failed: %v", err)
+			}
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("SelectThreshold() = %v, want %v", got, tt.want)
+			}
+			if histogram == nil || len(histogram.Counts) != DefaultHistogramBins {
+				t.Errorf("SelectThreshold() returned histogram %+v, want %d bins", histogram, DefaultHistogramBins)
+			}
+		})
+	}
+}
+
+func repeat(v float32, n int) []float32 {
+	values := make([]float32, n)
+	for i := range values {
+		values[i] = v
+	}
+	return values
+}
@@ -0,0 +1,264 @@
+// internal/detection/vectorize.go
+
+package detection
+
+import (
+	"fmt"
+	"image"
+
+	"geowatch-backend/internal/geo"
+)
+
+// ChangeRegion is one connected blob of changed pixels turned into a polygon.
+type ChangeRegion struct {
+	// Polygon is the georeferenced outline of the blob, including holes.
+	Polygon geo.Polygon
+	// Bounds is the blob's bounding rectangle in pixel coordinates.
+	Bounds        image.Rectangle
+	PixelCount    int
+	AreaSqM       float64
+	MeanMagnitude float64
+}
+
+// ChangeMask returns true for every pixel that the overlay marks as changed,
+// i.e. every pixel that isn't fully transparent.
+func ChangeMask(overlay image.Image) []bool {
+	bounds := overlay.Bounds()
+	mask := make([]bool, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := overlay.At(x, y).RGBA()
+			mask[(y-bounds.Min.Y)*bounds.Dx()+(x-bounds.Min.X)] = a > 0
+		}
+	}
+	return mask
+}
+
+// LabelComponents assigns a label from 1 to count to every 4-connected group
+// of true pixels in the mask. Unset pixels get label 0.
+//
+// 4-connectivity is used on purpose: blobs that only touch at a corner become
+// separate regions, which keeps every traced polygon valid for PostGIS.
+func LabelComponents(mask []bool, width, height int) (labels []int, count int) {
+	labels = make([]int, len(mask))
+	stack := make([]int, 0, 64)
+	for start := range mask {
+		if !mask[start] || labels[start] != 0 {
+			continue
+		}
+		count++
+		labels[start] = count
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%width, i/width
+			neighbours := [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
+			for _, n := range neighbours {
+				if n[0] < 0 || n[0] >= width || n[1] < 0 || n[1] >= height {
+					continue
+				}
+				j := n[1]*width + n[0]
+				if mask[j] && labels[j] == 0 {
+					labels[j] = count
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+	return labels, count
+}
+
+// Vectorize labels the changed pixels of the result's overlay and converts each
+// blob of at least minPixels pixels into a polygon georeferenced on bbox
+// (minLon, minLat, maxLon, maxLat), which must be the area the images cover.
+func Vectorize(result *Result, bbox []float64, minPixels int) ([]ChangeRegion, error) {
+	if result == nil || result.ChangeOverlay == nil {
+		return nil, fmt.Errorf("cannot vectorize a nil result")
+	}
+	if len(bbox) != 4 {
+		return nil, fmt.Errorf("bbox must have exactly 4 values: minLon,minLat,maxLon,maxLat")
+	}
+
+	bounds := result.ChangeOverlay.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	mask := ChangeMask(result.ChangeOverlay)
+	labels, count := LabelComponents(mask, width, height)
+
+	// Gather per-blob statistics in a single pass.
+	regions := make([]ChangeRegion, count)
+	sums := make([]float64, count)
+	sampled := make([]int, count)
+	for i, label := range labels {
+		if label == 0 {
+			continue
+		}
+		r := &regions[label-1]
+		x, y := i%width, i/width
+		if r.PixelCount == 0 {
+			r.Bounds = image.Rect(x, y, x+1, y+1)
+		} else {
+			r.Bounds = r.Bounds.Union(image.Rect(x, y, x+1, y+1))
+		}
+		r.PixelCount++
+		if len(result.Magnitude) == len(labels) && !isNaN32(result.Magnitude[i]) {
+			sums[label-1] += float64(result.Magnitude[i])
+			sampled[label-1]++
+		}
+	}
+
+	toGeo := func(px, py int) geo.Point {
+		lon := bbox[0] + float64(px)*(bbox[2]-bbox[0])/float64(width)
+		lat := bbox[3] - float64(py)*(bbox[3]-bbox[1])/float64(height)
+		return geo.Point{lon, lat}
+	}
+
+	var out []ChangeRegion
+	for i := range regions {
+		r := regions[i]
+		if r.PixelCount < minPixels {
+			continue
+		}
+		if sampled[i] > 0 {
+			r.MeanMagnitude = sums[i] / float64(sampled[i])
+		}
+		polygon, err := traceComponent(labels, width, i+1, r.Bounds, toGeo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to trace change region %d: %w", i+1, err)
+		}
+		r.Polygon = polygon
+		r.AreaSqM = polygon.Area()
+		out = append(out, r)
+	}
+
+	fmt.Printf("INFO: Vectorized %d change regions (%d blobs before the %d-pixel minimum).\n", len(out), count, minPixels)
+	return out, nil
+}
+
+// Edge directions in pixel space (y grows downwards).
+const (
+	dirRight = iota
+	dirDown
+	dirLeft
+	dirUp
+)
+
+type edge struct {
+	from, to image.Point
+	dir      int
+	used     bool
+}
+
+// traceComponent follows the pixel edges around one labelled blob and returns
+// its outline. Boundary sides are emitted with the blob on their left, so
+// exteriors come out counter-clockwise and holes clockwise as GeoJSON expects.
+func traceComponent(labels []int, width, label int, bounds image.Rectangle, toGeo func(x, y int) geo.Point) (geo.Polygon, error) {
+	height := len(labels) / width
+	inside := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < width && y < height && labels[y*width+x] == label
+	}
+
+	var edges []*edge
+	outgoing := map[image.Point][]*edge{}
+	add := func(fx, fy, tx, ty, dir int) {
+		e := &edge{from: image.Pt(fx, fy), to: image.Pt(tx, ty), dir: dir}
+		edges = append(edges, e)
+		outgoing[e.from] = append(outgoing[e.from], e)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !inside(x, y) {
+				continue
+			}
+			if !inside(x, y-1) {
+				add(x+1, y, x, y, dirLeft)
+			}
+			if !inside(x-1, y) {
+				add(x, y, x, y+1, dirDown)
+			}
+			if !inside(x, y+1) {
+				add(x, y+1, x+1, y+1, dirRight)
+			}
+			if !inside(x+1, y) {
+				add(x+1, y+1, x+1, y, dirUp)
+			}
+		}
+	}
+
+	var exterior geo.Ring
+	var holes []geo.Ring
+	exteriorArea := 0.0
+	for _, start := range edges {
+		if start.used {
+			continue
+		}
+		var vertices []image.Point
+		e := start
+		for {
+			e.used = true
+			vertices = append(vertices, e.from)
+			next := nextEdge(outgoing[e.to], e.dir, start)
+			if next == nil {
+				return nil, fmt.Errorf("open boundary at pixel %v", e.to)
+			}
+			if next == start {
+				break
+			}
+			e = next
+		}
+
+		ring := simplifyRing(vertices, toGeo)
+		area := geo.RingArea(ring)
+		if area > 0 {
+			// With 4-connected blobs there is a single exterior; keep the
+			// largest one just in case.
+			if exterior == nil || area > exteriorArea {
+				if exterior != nil {
+					holes = append(holes, exterior)
+				}
+				exterior, exteriorArea = ring, area
+			}
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	if exterior == nil {
+		return nil, fmt.Errorf("no exterior ring found")
+	}
+	return append(geo.Polygon{exterior}, holes...), nil
+}
+
+// nextEdge picks the edge to follow from a vertex: an unused one, or the edge
+// the ring started with. Where two boundaries meet at a corner it prefers
+// turning right, away from the blob, so that rings only ever touch each other
+// at a point and never themselves (which PostGIS would reject as invalid).
+func nextEdge(candidates []*edge, dir int, start *edge) *edge {
+	for _, turn := range []int{1, 0, 3} {
+		want := (dir + turn) % 4
+		for _, c := range candidates {
+			if c.dir == want && (!c.used || c == start) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// simplifyRing drops vertices in the middle of straight runs, converts the
+// rest to geographic coordinates and closes the ring.
+func simplifyRing(vertices []image.Point, toGeo func(x, y int) geo.Point) geo.Ring {
+	n := len(vertices)
+	ring := make(geo.Ring, 0, n+1)
+	for i, v := range vertices {
+		prev := vertices[(i+n-1)%n]
+		next := vertices[(i+1)%n]
+		collinear := (prev.X == v.X && v.X == next.X) || (prev.Y == v.Y && v.Y == next.Y)
+		if !collinear {
+			ring = append(ring, toGeo(v.X, v.Y))
+		}
+	}
+	if len(ring) == 0 {
+		return ring
+	}
+	return append(ring, ring[0])
+}
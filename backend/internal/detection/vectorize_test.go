@@ -0,0 +1,133 @@
+// internal/detection/vectorize_test.go
+
+package detection
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"geowatch-backend/internal/geo"
+)
+
+// overlayFromRows builds a change overlay where '#' marks a changed pixel.
+func overlayFromRows(rows ...string) *Result {
+	overlay := image.NewNRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				overlay.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+			}
+		}
+	}
+	return &Result{ChangeOverlay: overlay, Width: len(rows[0]), Height: len(rows)}
+}
+
+func TestLabelComponents(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []string
+		want int
+	}{
+		{"empty", []string{"...", "..."}, 0},
+		{"one blob", []string{"##.", ".##"}, 1},
+		{"corner contact is two blobs", []string{"#.", ".#"}, 2},
+		{"separate blobs", []string{"#.#", "#.#"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask := ChangeMask(overlayFromRows(tt.rows...).ChangeOverlay)
+			_, count := LabelComponents(mask, len(tt.rows[0]), len(tt.rows))
+			if count != tt.want {
+				t.Errorf("LabelComponents() found %d blobs, want %d", count, tt.want)
+			}
+		})
+	}
+}
+
+func TestVectorize(t *testing.T) {
+	bbox := []float64{0, 0, 4, 4}
+	tests := []struct {
+		name      string
+		rows      []string
+		minPixels int
+		// rings holds the number of rings of each region's polygon.
+		rings  []int
+		pixels []int
+	}{
+		{
+			name:   "single pixel",
+			rows:   []string{"....", ".#..", "....", "...."},
+			rings:  []int{1},
+			pixels: []int{1},
+		},
+		{
+			name:   "square with a hole",
+			rows:   []string{"###.", "#.#.", "###.", "...."},
+			rings:  []int{2},
+			pixels: []int{8},
+		},
+		{
+			name:      "small blobs are dropped",
+			rows:      []string{"##..", "##..", "...#", "...."},
+			minPixels: 2,
+			rings:     []int{1},
+			pixels:    []int{4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			regions, err := Vectorize(overlayFromRows(tt.rows...), bbox, tt.minPixels)
+			if err != nil {
+				t.Fatalf("Vectorize() failed: %v", err)
+			}
+			if len(regions) != len(tt.rings) {
+				t.Fatalf("Vectorize() returned %d regions, want %d", len(regions), len(tt.rings))
+			}
+			for i, r := range regions {
+				if len(r.Polygon) != tt.rings[i] {
+					t.Errorf("region %d has %d rings, want %d", i, len(r.Polygon), tt.rings[i])
+				}
+				if r.PixelCount != tt.pixels[i] {
+					t.Errorf("region %d has %d pixels, want %d", i, r.PixelCount, tt.pixels[i])
+				}
+				for j, ring := range r.Polygon {
+					if ring[0] != ring[len(ring)-1] {
+						t.Errorf("region %d ring %d isn't closed: %v", i, j, ring)
+					}
+					// Exteriors are counter-clockwise, holes clockwise.
+					if area := geo.RingArea(ring); (j == 0) != (area > 0) {
+						t.Errorf("region %d ring %d has the wrong orientation (signed area %v)", i, j, area)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVectorizeGeoreferencing(t *testing.T) {
+	// One pixel in the north-west corner of a 2x2 grid over (10,20)-(12,22).
+	regions, err := Vectorize(overlayFromRows("#.", ".."), []float64{10, 20, 12, 22}, 1)
+	if err != nil {
+		t.Fatalf("Vectorize() failed: %v", err)
+	}
+	if len(regions) != 1 {
+		t.Fatalf("Vectorize() returned %d regions, want 1", len(regions))
+	}
+	want := []float64{10, 21, 11, 22}
+	got := regions[0].Polygon.BBox()
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("region bbox = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestVectorizeRejectsBadInput(t *testing.T) {
+	if _, err := Vectorize(nil, []float64{0, 0, 1, 1}, 1); err == nil {
+		t.Error("Vectorize(nil) succeeded, want an error")
+	}
+	if _, err := Vectorize(overlayFromRows("#"), []float64{0, 0, 1}, 1); err == nil {
+		t.Error("Vectorize() with a 3-value bbox succeeded, want an error")
+	}
+}
@@ -0,0 +1,50 @@
+// internal/export/export.go
+
+// Package export converts change events into file formats used outside the
+// web app: KML/KMZ for Google Earth and zipped ESRI Shapefiles for desktop GIS.
+package export
+
+import (
+	"fmt"
+
+	"geowatch-backend/internal/geo"
+	"geowatch-backend/internal/storage"
+)
+
+// SeverityClass groups events for styling. An event belongs to the last class
+// whose MinSeverity it reaches.
+type SeverityClass struct {
+	Name        string
+	MinSeverity int
+	// Color is the RGB hex colour, e.g. "FF0000".
+	Color string
+}
+
+// SeverityClasses are the classes used to style exported events. Severity is
+// the number of changed pixels of a region; the colours follow the change
+// palette used for overlays.
+var SeverityClasses = []SeverityClass{
+	{Name: "low", MinSeverity: 0, Color: "FFFF00"},
+	{Name: "moderate", MinSeverity: 100, Color: "FFA500"},
+	{Name: "high", MinSeverity: 1000, Color: "FF0000"},
+}
+
+// ClassifySeverity returns the class an event severity falls into.
+func ClassifySeverity(severity int) SeverityClass {
+	class := SeverityClasses[0]
+	for _, c := range SeverityClasses[1:] {
+		if severity >= c.MinSeverity {
+			class = c
+		}
+	}
+	return class
+}
+
+// eventPolygons parses the stored geometry of an event.
+func eventPolygons(e storage.ChangeEventWithGeom) ([]geo.Polygon, error) {
+	polygons, err := geo.ParsePolygons([]byte(e.GeoJSON))
+	if err != nil {
+		return nil, fmt.Errorf("event %d: %w", e.ID, err)
+	}
+	return polygons, nil
+}
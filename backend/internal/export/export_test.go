@@ -0,0 +1,241 @@
+// internal/export/export_test.go
+
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"geowatch-backend/internal/storage"
+)
+
+// The first event is a square with a square hole, both wound as GeoJSON
+// expects; the second is a multipolygon of two triangles.
+func testEvents() []storage.ChangeEventWithGeom {
+	area := 1234.5
+	return []storage.ChangeEventWithGeom{
+		{
+			ID: 1, LocationID: 7, EventType: "vegetation_loss", Description: "Vegetation loss near the river",
+			DetectedAt: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), Severity: 1500, AreaSqM: &area,
+			GeoJSON: `{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[1,2],[2,2],[2,1],[1,1]]]}`,
+		},
+		{
+			ID: 2, LocationID: 7, EventType: "urban_expansion", Description: "Ünïcode",
+			DetectedAt: time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC), Severity: 5,
+			GeoJSON: `{"type":"MultiPolygon","coordinates":[[[[10,10],[11,10],[10,11],[10,10]]],[[[12,12],[13,12],[12,13],[12,12]]]]}`,
+		},
+	}
+}
+
+func TestClassifySeverity(t *testing.T) {
+	tests := []struct {
+		severity int
+		want     string
+	}{
+		{0, "low"},
+		{99, "low"},
+		{100, "moderate"},
+		{999, "moderate"},
+		{1000, "high"},
+		{50000, "high"},
+	}
+	for _, tt := range tests {
+		if got := ClassifySeverity(tt.severity).Name; got != tt.want {
+			t.Errorf("ClassifySeverity(%d) = %q, want %q", tt.severity, got, tt.want)
+		}
+	}
+}
+
+func TestKMLColor(t *testing.T) {
+	if got := kmlColor("FFA500", "80"); got != "8000a5ff" {
+		t.Errorf("kmlColor() = %q, want %q", got, "8000a5ff")
+	}
+}
+
+func TestWriteKML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteKML(&buf, "changes", testEvents()); err != nil {
+		t.Fatalf("WriteKML() failed: %v", err)
+	}
+	var doc kmlRoot
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("WriteKML() wrote invalid XML: %v", err)
+	}
+	if doc.Document.Name != "changes" || len(doc.Document.Styles) != len(SeverityClasses) {
+		t.Fatalf("document = %q with %d styles", doc.Document.Name, len(doc.Document.Styles))
+	}
+	if len(doc.Document.Placemarks) != 2 {
+		t.Fatalf("got %d placemarks, want 2", len(doc.Document.Placemarks))
+	}
+
+	polygon := doc.Document.Placemarks[0]
+	if polygon.StyleURL != "#severity-high" || polygon.When != "2024-07-01T12:00:00Z" {
+		t.Errorf("placemark 1 has style %q and time %q", polygon.StyleURL, polygon.When)
+	}
+	if polygon.Polygon == nil || len(polygon.Polygon.Inner) != 1 {
+		t.Fatalf("placemark 1 = %+v, want a polygon with one hole", polygon.Polygon)
+	}
+	if got, want := polygon.Polygon.Outer.Coordinates, "0,0 4,0 4,4 0,4 0,0"; got != want {
+		t.Errorf("outer ring = %q, want %q", got, want)
+	}
+	found := false
+	for _, d := range polygon.Data {
+		found = found || (d.Name == "area_sq_m" && d.Value == "1234.50")
+	}
+	if !found {
+		t.Errorf("placemark 1 data %v has no area_sq_m", polygon.Data)
+	}
+
+	multi := doc.Document.Placemarks[1]
+	if multi.MultiGeometry == nil || len(multi.MultiGeometry.Polygons) != 2 || multi.Polygon != nil {
+		t.Errorf("placemark 2 should be a MultiGeometry of two polygons")
+	}
+	if multi.StyleURL != "#severity-low" {
+		t.Errorf("placemark 2 has style %q, want #severity-low", multi.StyleURL)
+	}
+}
+
+func TestWriteKMLRejectsBadGeometry(t *testing.T) {
+	events := []storage.ChangeEventWithGeom{{ID: 3, GeoJSON: `{"type":"Point","coordinates":[0,0]}`}}
+	if err := WriteKML(io.Discard, "changes", events); err == nil {
+		t.Error("WriteKML() succeeded for a point geometry, want an error")
+	}
+}
+
+func TestWriteKMZ(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteKMZ(&buf, "changes", testEvents()); err != nil {
+		t.Fatalf("WriteKMZ() failed: %v", err)
+	}
+	files := unzip(t, buf.Bytes())
+	doc, ok := files["doc.kml"]
+	if !ok || len(files) != 1 {
+		t.Fatalf("KMZ holds %d files, want only doc.kml", len(files))
+	}
+	if !bytes.HasPrefix(doc, []byte("<?xml")) {
+		t.Errorf("doc.kml starts with %q", doc[:min(len(doc), 20)])
+	}
+}
+
+func TestWriteShapefile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteShapefile(&buf, "changes", testEvents()); err != nil {
+		t.Fatalf("WriteShapefile() failed: %v", err)
+	}
+	files := unzip(t, buf.Bytes())
+	for _, ext := range []string{".shp", ".shx", ".dbf", ".prj", ".cpg"} {
+		if _, ok := files["changes"+ext]; !ok {
+			t.Errorf("archive has no changes%s", ext)
+		}
+	}
+
+	shp := files["changes.shp"]
+	if code := binary.BigEndian.Uint32(shp[0:4]); code != 9994 {
+		t.Errorf("file code = %d, want 9994", code)
+	}
+	if words := int(binary.BigEndian.Uint32(shp[24:28])); words*2 != len(shp) {
+		t.Errorf("header length %d bytes, file is %d bytes", words*2, len(shp))
+	}
+	if got := readFloats(shp[36:68]); got != [4]float64{0, 0, 13, 13} {
+		t.Errorf("file bbox = %v, want [0 0 13 13]", got)
+	}
+
+	// The first record has two rings of five points each.
+	record := shp[100+8:]
+	if shape := binary.LittleEndian.Uint32(record[0:4]); shape != shapePolygon {
+		t.Fatalf("record 1 shape = %d, want %d", shape, shapePolygon)
+	}
+	if parts, points := binary.LittleEndian.Uint32(record[36:40]), binary.LittleEndian.Uint32(record[40:44]); parts != 2 || points != 10 {
+		t.Errorf("record 1 has %d parts and %d points, want 2 and 10", parts, points)
+	}
+
+	shx := files["changes.shx"]
+	if len(shx) != 100+8*2 {
+		t.Errorf(".shx is %d bytes, want %d", len(shx), 100+8*2)
+	}
+
+	dbf := files["changes.dbf"]
+	if count := binary.LittleEndian.Uint32(dbf[4:8]); count != 2 {
+		t.Errorf(".dbf has %d records, want 2", count)
+	}
+	headerLen := int(binary.LittleEndian.Uint16(dbf[8:10]))
+	recordLen := int(binary.LittleEndian.Uint16(dbf[10:12]))
+	if want := headerLen + 2*recordLen + 1; len(dbf) != want {
+		t.Errorf(".dbf is %d bytes, want %d", len(dbf), want)
+	}
+	if !strings.Contains(string(dbf[headerLen:]), "vegetation_loss") {
+		t.Error(".dbf records don't contain the event type")
+	}
+}
+
+func TestPolygonRecordOrientation(t *testing.T) {
+	polygons, err := eventPolygons(testEvents()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _ := polygonRecord(polygons)
+	points := record[44+4*2:]
+	// GeoJSON's counter-clockwise outer ring must come out clockwise:
+	// (0,0) is followed by (0,4) instead of (4,0).
+	x := math.Float64frombits(binary.LittleEndian.Uint64(points[16:24]))
+	y := math.Float64frombits(binary.LittleEndian.Uint64(points[24:32]))
+	if x != 0 || y != 4 {
+		t.Errorf("second outer point = (%v, %v), want (0, 4)", x, y)
+	}
+}
+
+func TestDBFValue(t *testing.T) {
+	text := dbfField{Type: 'C', Length: 5}
+	number := dbfField{Type: 'N', Length: 4}
+	tests := []struct {
+		field dbfField
+		value string
+		want  string
+	}{
+		{text, "ab", "ab   "},
+		{text, "abcdefg", "abcde"},
+		{text, "abcdé", "abcd "}, // é is two bytes and must not be split
+		{number, "42", "  42"},
+		{number, "123456", "    "},
+	}
+	for _, tt := range tests {
+		if got := dbfValue(tt.field, tt.value); got != tt.want {
+			t.Errorf("dbfValue(%c%d, %q) = %q, want %q", tt.field.Type, tt.field.Length, tt.value, got, tt.want)
+		}
+	}
+}
+
+func unzip(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip archive: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+// readFloats decodes four little-endian float64 values.
+func readFloats(b []byte) [4]float64 {
+	var out [4]float64
+	binary.Read(bytes.NewReader(b), binary.LittleEndian, &out)
+	return out
+}
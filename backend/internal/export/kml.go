@@ -0,0 +1,179 @@
+// internal/export/kml.go
+
+package export
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"geowatch-backend/internal/geo"
+	"geowatch-backend/internal/storage"
+)
+
+// KMLContentType and KMZContentType are the media types of the KML exports.
+const (
+	KMLContentType = "application/vnd.google-earth.kml+xml"
+	KMZContentType = "application/vnd.google-earth.kmz"
+)
+
+type kmlRoot struct {
+	XMLName  xml.Name    `xml:"kml"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name"`
+	Styles     []kmlStyle     `xml:"Style"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlStyle struct {
+	ID        string `xml:"id,attr"`
+	LineColor string `xml:"LineStyle>color"`
+	LineWidth int    `xml:"LineStyle>width"`
+	PolyColor string `xml:"PolyStyle>color"`
+}
+
+type kmlPlacemark struct {
+	ID            string        `xml:"id,attr"`
+	Name          string        `xml:"name"`
+	Description   string        `xml:"description"`
+	When          string        `xml:"TimeStamp>when"`
+	StyleURL      string        `xml:"styleUrl"`
+	Data          []kmlData     `xml:"ExtendedData>Data"`
+	Polygon       *kmlPolygon   `xml:"Polygon,omitempty"`
+	MultiGeometry *kmlMultiGeom `xml:"MultiGeometry,omitempty"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlMultiGeom struct {
+	Polygons []kmlPolygon `xml:"Polygon"`
+}
+
+type kmlPolygon struct {
+	Outer kmlBoundary   `xml:"outerBoundaryIs"`
+	Inner []kmlBoundary `xml:"innerBoundaryIs"`
+}
+
+type kmlBoundary struct {
+	Coordinates string `xml:"LinearRing>coordinates"`
+}
+
+// WriteKML writes the events as a KML document named name. Each event becomes
+// a placemark styled by its severity class, with the event fields as
+// extended data.
+func WriteKML(w io.Writer, name string, events []storage.ChangeEventWithGeom) error {
+	doc := kmlRoot{
+		Xmlns:    "http://www.opengis.net/kml/2.2",
+		Document: kmlDocument{Name: name},
+	}
+	for _, class := range SeverityClasses {
+		doc.Document.Styles = append(doc.Document.Styles, kmlStyle{
+			ID:        "severity-" + class.Name,
+			LineColor: kmlColor(class.Color, "ff"),
+			LineWidth: 2,
+			PolyColor: kmlColor(class.Color, "80"),
+		})
+	}
+
+	for _, e := range events {
+		polygons, err := eventPolygons(e)
+		if err != nil {
+			return err
+		}
+		placemark := kmlPlacemark{
+			ID:          "event-" + strconv.Itoa(e.ID),
+			Name:        fmt.Sprintf("%s #%d", e.EventType, e.ID),
+			Description: e.Description,
+			When:        e.DetectedAt.UTC().Format(time.RFC3339),
+			StyleURL:    "#severity-" + ClassifySeverity(e.Severity).Name,
+			Data: []kmlData{
+				{Name: "id", Value: strconv.Itoa(e.ID)},
+				{Name: "location_id", Value: strconv.Itoa(e.LocationID)},
+				{Name: "event_type", Value: e.EventType},
+				{Name: "severity", Value: strconv.Itoa(e.Severity)},
+			},
+		}
+		if e.AreaSqM != nil {
+			placemark.Data = append(placemark.Data, kmlData{Name: "area_sq_m", Value: strconv.FormatFloat(*e.AreaSqM, 'f', 2, 64)})
+		}
+		if e.MeanMagnitude != nil {
+			placemark.Data = append(placemark.Data, kmlData{Name: "mean_magnitude", Value: strconv.FormatFloat(*e.MeanMagnitude, 'g', -1, 64)})
+		}
+		if len(polygons) == 1 {
+			p := toKMLPolygon(polygons[0])
+			placemark.Polygon = &p
+		} else {
+			multi := &kmlMultiGeom{}
+			for _, polygon := range polygons {
+				multi.Polygons = append(multi.Polygons, toKMLPolygon(polygon))
+			}
+			placemark.MultiGeometry = multi
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, placemark)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode KML: %w", err)
+	}
+	return enc.Close()
+}
+
+// WriteKMZ writes the events as a KMZ archive: the KML document zipped as
+// doc.kml.
+func WriteKMZ(w io.Writer, name string, events []storage.ChangeEventWithGeom) error {
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("doc.kml")
+	if err != nil {
+		return fmt.Errorf("failed to create KMZ archive: %w", err)
+	}
+	if err := WriteKML(f, name, events); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish KMZ archive: %w", err)
+	}
+	return nil
+}
+
+func toKMLPolygon(p geo.Polygon) kmlPolygon {
+	var out kmlPolygon
+	for i, ring := range p {
+		boundary := kmlBoundary{Coordinates: kmlCoordinates(ring)}
+		if i == 0 {
+			out.Outer = boundary
+		} else {
+			out.Inner = append(out.Inner, boundary)
+		}
+	}
+	return out
+}
+
+// kmlCoordinates formats a ring as KML "lon,lat" tuples.
+func kmlCoordinates(r geo.Ring) string {
+	parts := make([]string, len(r))
+	for i, pt := range r {
+		parts[i] = strconv.FormatFloat(pt[0], 'f', -1, 64) + "," + strconv.FormatFloat(pt[1], 'f', -1, 64)
+	}
+	return strings.Join(parts, " ")
+}
+
+// kmlColor converts an RGB hex colour to KML's aabbggrr notation.
+func kmlColor(rgb, alpha string) string {
+	return strings.ToLower(alpha + rgb[4:6] + rgb[2:4] + rgb[0:2])
+}
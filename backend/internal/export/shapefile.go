@@ -0,0 +1,270 @@
+// internal/export/shapefile.go
+
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"geowatch-backend/internal/geo"
+	"geowatch-backend/internal/storage"
+)
+
+// ShapefileContentType is the media type of the zipped Shapefile export.
+const ShapefileContentType = "application/zip"
+
+const (
+	shapeNull    = 0
+	shapePolygon = 5
+)
+
+// wgs84PRJ is the ESRI WKT of EPSG:4326, written as the .prj file.
+const wgs84PRJ = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// dbfField is one attribute column. Names are limited to 10 characters.
+type dbfField struct {
+	Name     string
+	Type     byte // 'C' for text, 'N' for numbers
+	Length   int
+	Decimals int
+	value    func(e storage.ChangeEventWithGeom) string
+}
+
+var eventFields = []dbfField{
+	{Name: "ID", Type: 'N', Length: 10, value: func(e storage.ChangeEventWithGeom) string { return strconv.Itoa(e.ID) }},
+	{Name: "LOC_ID", Type: 'N', Length: 10, value: func(e storage.ChangeEventWithGeom) string { return strconv.Itoa(e.LocationID) }},
+	{Name: "EVENT_TYPE", Type: 'C', Length: 64, value: func(e storage.ChangeEventWithGeom) string { return e.EventType }},
+	{Name: "DESCR", Type: 'C', Length: 254, value: func(e storage.ChangeEventWithGeom) string { return e.Description }},
+	{Name: "DETECTED", Type: 'C', Length: 20, value: func(e storage.ChangeEventWithGeom) string {
+		return e.DetectedAt.UTC().Format(time.RFC3339)
+	}},
+	{Name: "SEVERITY", Type: 'N', Length: 10, value: func(e storage.ChangeEventWithGeom) string { return strconv.Itoa(e.Severity) }},
+	{Name: "SEV_CLASS", Type: 'C', Length: 10, value: func(e storage.ChangeEventWithGeom) string { return ClassifySeverity(e.Severity).Name }},
+	{Name: "AREA_SQM", Type: 'N', Length: 19, Decimals: 2, value: func(e storage.ChangeEventWithGeom) string {
+		return formatOptional(e.AreaSqM, 2)
+	}},
+	{Name: "MEAN_MAG", Type: 'N', Length: 19, Decimals: 8, value: func(e storage.ChangeEventWithGeom) string {
+		return formatOptional(e.MeanMagnitude, 8)
+	}},
+}
+
+// WriteShapefile writes the events as a zip archive holding the name.shp,
+// .shx, .dbf, .prj and .cpg files of a polygon Shapefile in EPSG:4326.
+func WriteShapefile(w io.Writer, name string, events []storage.ChangeEventWithGeom) error {
+	var shp, shx bytes.Buffer
+	if err := writeShapes(&shp, &shx, events); err != nil {
+		return err
+	}
+	var dbf bytes.Buffer
+	writeDBF(&dbf, events)
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		ext  string
+		data []byte
+	}{
+		{".shp", shp.Bytes()},
+		{".shx", shx.Bytes()},
+		{".dbf", dbf.Bytes()},
+		{".prj", []byte(wgs84PRJ)},
+		{".cpg", []byte("UTF-8")},
+	}
+	for _, file := range files {
+		f, err := zw.Create(name + file.ext)
+		if err != nil {
+			return fmt.Errorf("failed to create shapefile archive: %w", err)
+		}
+		if _, err := f.Write(file.data); err != nil {
+			return fmt.Errorf("failed to write %s%s: %w", name, file.ext, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish shapefile archive: %w", err)
+	}
+	return nil
+}
+
+// writeShapes writes the .shp geometry file and its .shx index.
+func writeShapes(shp, shx *bytes.Buffer, events []storage.ChangeEventWithGeom) error {
+	var records [][]byte
+	var bbox []float64
+	for _, e := range events {
+		polygons, err := eventPolygons(e)
+		if err != nil {
+			return err
+		}
+		record, recordBBox := polygonRecord(polygons)
+		records = append(records, record)
+		bbox = unionBBox(bbox, recordBBox)
+	}
+	if bbox == nil {
+		bbox = []float64{0, 0, 0, 0}
+	}
+
+	// Lengths in the headers are counted in 16-bit words.
+	shpLength := 100
+	for _, record := range records {
+		shpLength += 8 + len(record)
+	}
+	writeShapeHeader(shp, shpLength/2, bbox)
+	writeShapeHeader(shx, (100+8*len(records))/2, bbox)
+
+	offset := 100
+	for i, record := range records {
+		binary.Write(shx, binary.BigEndian, int32(offset/2))
+		binary.Write(shx, binary.BigEndian, int32(len(record)/2))
+
+		binary.Write(shp, binary.BigEndian, int32(i+1))
+		binary.Write(shp, binary.BigEndian, int32(len(record)/2))
+		shp.Write(record)
+		offset += 8 + len(record)
+	}
+	return nil
+}
+
+func writeShapeHeader(buf *bytes.Buffer, lengthWords int, bbox []float64) {
+	binary.Write(buf, binary.BigEndian, int32(9994))
+	buf.Write(make([]byte, 20))
+	binary.Write(buf, binary.BigEndian, int32(lengthWords))
+	binary.Write(buf, binary.LittleEndian, int32(1000))
+	binary.Write(buf, binary.LittleEndian, int32(shapePolygon))
+	for _, v := range bbox {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+	buf.Write(make([]byte, 32)) // Z and M ranges
+}
+
+// polygonRecord encodes the polygons as the content of one Polygon record.
+// Shapefiles expect clockwise outer rings and counter-clockwise holes, the
+// opposite of GeoJSON, so rings are reoriented as needed.
+func polygonRecord(polygons []geo.Polygon) ([]byte, []float64) {
+	var rings []geo.Ring
+	for _, polygon := range polygons {
+		for i, ring := range polygon {
+			clockwise := geo.RingArea(ring) < 0
+			if (i == 0) != clockwise {
+				ring = ring.Reversed()
+			}
+			rings = append(rings, ring)
+		}
+	}
+
+	var buf bytes.Buffer
+	if len(rings) == 0 {
+		binary.Write(&buf, binary.LittleEndian, int32(shapeNull))
+		return buf.Bytes(), nil
+	}
+
+	bbox := []float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	points := 0
+	for _, ring := range rings {
+		for _, pt := range ring {
+			bbox[0] = math.Min(bbox[0], pt[0])
+			bbox[1] = math.Min(bbox[1], pt[1])
+			bbox[2] = math.Max(bbox[2], pt[0])
+			bbox[3] = math.Max(bbox[3], pt[1])
+		}
+		points += len(ring)
+	}
+
+	binary.Write(&buf, binary.LittleEndian, int32(shapePolygon))
+	for _, v := range bbox {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	binary.Write(&buf, binary.LittleEndian, int32(len(rings)))
+	binary.Write(&buf, binary.LittleEndian, int32(points))
+	start := 0
+	for _, ring := range rings {
+		binary.Write(&buf, binary.LittleEndian, int32(start))
+		start += len(ring)
+	}
+	for _, ring := range rings {
+		for _, pt := range ring {
+			binary.Write(&buf, binary.LittleEndian, pt[0])
+			binary.Write(&buf, binary.LittleEndian, pt[1])
+		}
+	}
+	return buf.Bytes(), bbox
+}
+
+func unionBBox(a, b []float64) []float64 {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return []float64{math.Min(a[0], b[0]), math.Min(a[1], b[1]), math.Max(a[2], b[2]), math.Max(a[3], b[3])}
+}
+
+// writeDBF writes the dBASE III attribute table, one record per event.
+func writeDBF(buf *bytes.Buffer, events []storage.ChangeEventWithGeom) {
+	recordLength := 1 // deletion flag
+	for _, f := range eventFields {
+		recordLength += f.Length
+	}
+	now := time.Now()
+
+	buf.WriteByte(0x03)
+	buf.Write([]byte{byte(now.Year() - 1900), byte(now.Month()), byte(now.Day())})
+	binary.Write(buf, binary.LittleEndian, uint32(len(events)))
+	binary.Write(buf, binary.LittleEndian, uint16(32+32*len(eventFields)+1))
+	binary.Write(buf, binary.LittleEndian, uint16(recordLength))
+	buf.Write(make([]byte, 20))
+
+	for _, f := range eventFields {
+		name := make([]byte, 11)
+		copy(name, f.Name)
+		buf.Write(name)
+		buf.WriteByte(f.Type)
+		buf.Write(make([]byte, 4))
+		buf.WriteByte(byte(f.Length))
+		buf.WriteByte(byte(f.Decimals))
+		buf.Write(make([]byte, 14))
+	}
+	buf.WriteByte(0x0D)
+
+	for _, e := range events {
+		buf.WriteByte(' ')
+		for _, f := range eventFields {
+			buf.WriteString(dbfValue(f, f.value(e)))
+		}
+	}
+	buf.WriteByte(0x1A)
+}
+
+// dbfValue pads or truncates a value to the field width: text is left
+// aligned, numbers right aligned. Text is cut on a UTF-8 character boundary.
+func dbfValue(f dbfField, value string) string {
+	if len(value) > f.Length {
+		if f.Type == 'N' {
+			// A number that doesn't fit can't be stored meaningfully.
+			value = ""
+		} else {
+			cut := f.Length
+			for cut > 0 && !utf8.RuneStart(value[cut]) {
+				cut--
+			}
+			value = value[:cut]
+		}
+	}
+	pad := string(bytes.Repeat([]byte{' '}, f.Length-len(value)))
+	if f.Type == 'N' {
+		return pad + value
+	}
+	return value + pad
+}
+
+func formatOptional(v *float64, decimals int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', decimals, 64)
+}
@@ -0,0 +1,270 @@
+// internal/fetcher/catalog.go
+
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultSearchWindow is how far on either side of the target date scenes
+// are searched for when the Fetcher isn't configured otherwise.
+const DefaultSearchWindow = 7 * 24 * time.Hour
+
+// sceneTimeSlack is the time range around a chosen scene's acquisition that
+// is requested from the provider, so that only that acquisition is used.
+const sceneTimeSlack = 30 * time.Minute
+
+// Scene is one acquisition found in a provider's catalog.
+type Scene struct {
+	ID         string    `json:"id"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	// CloudCover is the scene's cloud cover in percent, or -1 if unknown.
+	CloudCover float64 `json:"cloud_cover"`
+	// BBox is minLon, minLat, maxLon, maxLat of the scene footprint.
+	BBox []float64 `json:"bbox"`
+	// Coverage is the share (0-1) of the requested bbox inside the footprint.
+	// It is filled in by RankScenes.
+	Coverage float64 `json:"coverage"`
+}
+
+// SceneCatalog is implemented by providers that can list the acquisitions
+// available for a request before fetching one.
+type SceneCatalog interface {
+	// SearchScenes lists the scenes intersecting req.BBox acquired between
+	// req.From and req.To.
+	SearchScenes(ctx context.Context, req ImageRequest) ([]Scene, error)
+}
+
+// score rates a scene for an AOI: the share of the AOI covered by the
+// scene and not by cloud. Unknown cloud cover counts as half cloudy.
+func (s Scene) score() float64 {
+	cloud := s.CloudCover
+	if cloud < 0 {
+		cloud = 50
+	}
+	return s.Coverage * (1 - math.Min(cloud, 100)/100)
+}
+
+// RankScenes fills in each scene's coverage of bbox and sorts the scenes
+// best first: by covered, cloud-free share of the bbox, compared to two
+// decimals, and then by closeness to target. Scenes that don't cover any of
+// the bbox are dropped.
+//
+// The cloud-free share is an estimate: catalogs report cloud cover for the
+// whole scene (eo:cloud_cover), not for the bbox, so a scene that is cloudy
+// elsewhere but clear over a small AOI ranks below a scene with less cloud
+// that happens to sit over the AOI. Only the fetched image's mask says how
+// much of the AOI is actually usable; callers that need a minimum, like the
+// scheduler with its ValidFraction check, must check the mask themselves.
+func RankScenes(scenes []Scene, bbox []float64, target time.Time) []Scene {
+	area := (bbox[2] - bbox[0]) * (bbox[3] - bbox[1])
+	ranked := make([]Scene, 0, len(scenes))
+	for _, scene := range scenes {
+		if len(scene.BBox) == 4 && area > 0 {
+			scene.Coverage = math.Min(1, bboxOverlap(scene.BBox, bbox)/area)
+		} else {
+			// Footprint unknown: the catalog only returns intersecting scenes.
+			scene.Coverage = 1
+		}
+		if scene.Coverage > 0 {
+			ranked = append(ranked, scene)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		si, sj := math.Round(ranked[i].score()*100), math.Round(ranked[j].score()*100)
+		if si != sj {
+			return si > sj
+		}
+		return absDuration(ranked[i].AcquiredAt.Sub(target)) < absDuration(ranked[j].AcquiredAt.Sub(target))
+	})
+	return ranked
+}
+
+// sceneAttempts is how many of the best-ranked scenes are tried before
+// giving up, e.g. when a local archive has a scene only as a raster.
+const sceneAttempts = 3
+
+// rankedScenes searches the provider's catalog for the request's time range
+// and returns the scenes best first. It returns nil without an error if the
+// provider has no catalog.
+func (f *Fetcher) rankedScenes(ctx context.Context, req ImageRequest, target time.Time) ([]Scene, error) {
+	catalog, ok := f.provider.(SceneCatalog)
+	if !ok {
+		return nil, nil
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	scenes, err := catalog.SearchScenes(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("%s provider: scene search failed: %w", f.provider.Name(), err)
+	}
+	ranked := RankScenes(scenes, req.BBox, target)
+	if len(ranked) == 0 {
+		return nil, fmt.Errorf("%s provider: no scenes of bbox %v between %s and %s",
+			f.provider.Name(), req.BBox, req.From.Format("2006-01-02"), req.To.Format("2006-01-02"))
+	}
+	return ranked, nil
+}
+
+// fetchBest calls fetch for the best of the ranked scenes, falling back to
+// the next ones if it fails, and returns the scene that was fetched. Without
+// a catalog fetch gets the whole time range and the scene is nil.
+func (f *Fetcher) fetchBest(ctx context.Context, req ImageRequest, target time.Time, fetch func(ImageRequest) error) (*Scene, error) {
+	ranked, err := f.rankedScenes(ctx, req, target)
+	if err != nil {
+		return nil, err
+	}
+	if ranked == nil {
+		return nil, fetch(req)
+	}
+
+	var lastErr error
+	for i := 0; i < len(ranked) && i < sceneAttempts; i++ {
+		scene := ranked[i]
+		fmt.Printf("INFO: Fetching scene %s acquired %s (cloud cover %.0f%%, %.0f%% of the bbox), ranked %d of %d.\n",
+			scene.ID, scene.AcquiredAt.Format(time.RFC3339), scene.CloudCover, 100*scene.Coverage, i+1, len(ranked))
+		sceneReq := req
+		sceneReq.From = scene.AcquiredAt.Add(-sceneTimeSlack)
+		sceneReq.To = scene.AcquiredAt.Add(sceneTimeSlack)
+		err := fetch(sceneReq)
+		if err == nil {
+			return &scene, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		fmt.Printf("WARNING: Failed to fetch scene %s: %v\n", scene.ID, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// FetchBestImage searches the request's time range for the scene with the
+// most cloud-free coverage of the bbox, preferring acquisitions closer to
+// target on a tie, and fetches it. The image carries the chosen scene's ID
+// and acquisition time. Providers without a catalog get the whole range.
+func (f *Fetcher) FetchBestImage(ctx context.Context, req ImageRequest, target time.Time) (*SatelliteImage, error) {
+	var img *SatelliteImage
+	scene, err := f.fetchBest(ctx, req, target, func(req ImageRequest) (err error) {
+		img, err = f.FetchImage(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if scene != nil {
+		img.SceneID = scene.ID
+		img.AcquiredAt = scene.AcquiredAt
+	}
+	return img, nil
+}
+
+// FetchBestRaster is FetchBestImage for rasters.
+func (f *Fetcher) FetchBestRaster(ctx context.Context, req ImageRequest, target time.Time) (*Raster, error) {
+	var raster *Raster
+	scene, err := f.fetchBest(ctx, req, target, func(req ImageRequest) (err error) {
+		raster, err = f.FetchRaster(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if scene != nil {
+		raster.SceneID = scene.ID
+		raster.AcquiredAt = scene.AcquiredAt
+	}
+	return raster, nil
+}
+
+// fetchRanked calls fetch for each of the best n ranked scenes, skipping
+// scenes that fail, and returns the scenes that were fetched. Without a
+// catalog fetch gets the whole time range once and the list is nil.
+func (f *Fetcher) fetchRanked(ctx context.Context, req ImageRequest, target time.Time, n int, fetch func(ImageRequest) error) ([]Scene, error) {
+	ranked, err := f.rankedScenes(ctx, req, target)
+	if err != nil {
+		return nil, err
+	}
+	if ranked == nil {
+		return nil, fetch(req)
+	}
+
+	var fetched []Scene
+	var lastErr error
+	for _, scene := range ranked {
+		if len(fetched) == n {
+			break
+		}
+		sceneReq := req
+		sceneReq.From = scene.AcquiredAt.Add(-sceneTimeSlack)
+		sceneReq.To = scene.AcquiredAt.Add(sceneTimeSlack)
+		if err := fetch(sceneReq); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			fmt.Printf("WARNING: Failed to fetch scene %s: %v\n", scene.ID, err)
+			lastErr = err
+			continue
+		}
+		fetched = append(fetched, scene)
+	}
+	if len(fetched) == 0 {
+		return nil, lastErr
+	}
+	fmt.Printf("INFO: Fetched %d of %d scenes found.\n", len(fetched), len(ranked))
+	return fetched, nil
+}
+
+// FetchImages fetches up to n of the best-ranked scenes in the request's time
+// range (see FetchBestImage), best first. Providers without a catalog
+// return a single image of the whole range.
+func (f *Fetcher) FetchImages(ctx context.Context, req ImageRequest, target time.Time, n int) ([]*SatelliteImage, error) {
+	var images []*SatelliteImage
+	scenes, err := f.fetchRanked(ctx, req, target, n, func(req ImageRequest) error {
+		img, err := f.FetchImage(ctx, req)
+		if err == nil {
+			images = append(images, img)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, scene := range scenes {
+		images[i].SceneID = scene.ID
+		images[i].AcquiredAt = scene.AcquiredAt
+	}
+	return images, nil
+}
+
+// FetchRasters is FetchImages for rasters.
+func (f *Fetcher) FetchRasters(ctx context.Context, req ImageRequest, target time.Time, n int) ([]*Raster, error) {
+	var rasters []*Raster
+	scenes, err := f.fetchRanked(ctx, req, target, n, func(req ImageRequest) error {
+		raster, err := f.FetchRaster(ctx, req)
+		if err == nil {
+			rasters = append(rasters, raster)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, scene := range scenes {
+		rasters[i].SceneID = scene.ID
+		rasters[i].AcquiredAt = scene.AcquiredAt
+	}
+	return rasters, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
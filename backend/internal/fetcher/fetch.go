@@ -1,161 +1,125 @@
-// internal/fetcher/fetch.go (Updated Version)
+// internal/fetcher/fetch.go
 
 package fetcher
 
 import (
-	// ... keep all your existing imports: bytes, encoding/json, fmt, etc.
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"image"
-	"image/png"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
-	"strings"
-	"sync"
 	"time"
 )
 
-// authTokenResponse, SatelliteImage, and Fetcher struct remain exactly the same.
-type authTokenResponse struct {
-	AccessToken string `json:"access_token"`
-	ExpiresIn   int    `json:"expires_in"`
-}
+// SatelliteImage is a single displayable image returned by a provider.
 type SatelliteImage struct {
-	ID         string
+	ID string
+	// SceneID is the provider's ID of the acquisition the image was made
+	// from, when it was chosen through a catalog search. Composites list
+	// their source scenes, comma-separated.
+	SceneID    string
 	AcquiredAt time.Time
 	ImageData  image.Image
+	// Mask marks the pixels of ImageData, row by row from the top left of its
+	// bounds, that aren't covered by cloud, cloud shadow or missing data.
+	// Nil means every pixel is usable.
+	Mask Mask
 }
+
+// Fetcher is what the rest of the backend uses to get imagery. It delegates
+// the actual retrieval to an ImageryProvider.
 type Fetcher struct {
-	client       *http.Client
-	clientID     string
-	clientSecret string
-	token        string
-	tokenExpiry  time.Time
-	mu           sync.Mutex
+	provider ImageryProvider
+	// searchWindow is how far on either side of a target date the
+	// ...ForLocation methods look for scenes.
+	searchWindow time.Duration
 }
 
-// NewFetcher, getAccessToken, and ensureValidToken remain exactly the same.
+// NewFetcher creates a Fetcher for the provider selected by IMAGERY_PROVIDER:
+// "sentinelhub" (the default) or "local", which reads archived scenes from
+// LOCAL_IMAGERY_DIR. IMAGERY_SEARCH_WINDOW (e.g. "168h") overrides
+// DefaultSearchWindow.
 func NewFetcher() (*Fetcher, error) {
-	clientID := os.Getenv("SENTINELHUB_CLIENT_ID")
-	clientSecret := os.Getenv("SENTINELHUB_CLIENT_SECRET")
-	if clientID == "" || clientSecret == "" {
-		return nil, fmt.Errorf("environment variables SENTINELHUB_CLIENT_ID and SENTINELHUB_CLIENT_SECRET must be set")
-	}
-	return &Fetcher{
-		client:       &http.Client{Timeout: time.Minute * 2},
-		clientID:     clientID,
-		clientSecret: clientSecret,
-	}, nil
-}
-func (f *Fetcher) getAccessToken() error {
-	// ... this function's code does not change
-	tokenURL := "https://services.sentinel-hub.com/oauth/token"
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
-	data.Set("client_id", f.clientID)
-	data.Set("client_secret", f.clientSecret)
-	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
-	if err != nil { return fmt.Errorf("failed to create token request: %w", err) }
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := f.client.Do(req)
-	if err != nil { return fmt.Errorf("failed to execute token request: %w", err) }
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to get token, status: %s, body: %s", resp.Status, string(bodyBytes))
-	}
-	var tokenResp authTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return fmt.Errorf("failed to decode token response: %w", err)
+	var provider ImageryProvider
+	switch name := os.Getenv("IMAGERY_PROVIDER"); name {
+	case "", "sentinelhub":
+		shProvider, err := NewSentinelHubProvider()
+		if err != nil {
+			return nil, err
+		}
+		provider = shProvider
+	case "local":
+		dir := os.Getenv("LOCAL_IMAGERY_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("environment variable LOCAL_IMAGERY_DIR must be set for the local imagery provider")
+		}
+		localProvider, err := NewLocalProvider(dir)
+		if err != nil {
+			return nil, err
+		}
+		provider = localProvider
+	default:
+		return nil, fmt.Errorf("unknown IMAGERY_PROVIDER %q (expected \"sentinelhub\" or \"local\")", name)
 	}
-	f.token = tokenResp.AccessToken
-	f.tokenExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn-60) * time.Second)
-	fmt.Println("INFO: Successfully fetched new Sentinel Hub access token.")
-	return nil
-}
-func (f *Fetcher) ensureValidToken() error {
-	// ... this function's code does not change
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	if f.token == "" || time.Now().After(f.tokenExpiry) {
-		fmt.Println("INFO: Access token is expired or missing. Fetching a new one.")
-		return f.getAccessToken()
+
+	f := NewFetcherWithProvider(provider)
+	if d, err := time.ParseDuration(os.Getenv("IMAGERY_SEARCH_WINDOW")); err == nil && d >= 0 {
+		f.searchWindow = d
 	}
-	return nil
+	return f, nil
 }
 
-// --- THIS IS THE MODIFIED FUNCTION ---
-// It now accepts a `bbox` (bounding box) slice of float64.
-func (f *Fetcher) FetchImageForLocation(bbox []float64, date time.Time) (*SatelliteImage, error) {
-	if err := f.ensureValidToken(); err != nil {
-		return nil, err
-	}
+// NewFetcherWithProvider creates a Fetcher backed by the given provider.
+func NewFetcherWithProvider(provider ImageryProvider) *Fetcher {
+	return &Fetcher{provider: provider, searchWindow: DefaultSearchWindow}
+}
 
-	fmt.Printf("INFO: Fetching image from Sentinel Hub for bbox %v on %s\n", bbox, date.Format("2006-01-02"))
+// Provider returns the provider behind this Fetcher.
+func (f *Fetcher) Provider() ImageryProvider {
+	return f.provider
+}
 
-	requestURL := "https://services.sentinel-hub.com/api/v1/process"
-	evalscript := `
-		//VERSION=3
-		function setup() {
-			return { input: ["B04", "B03", "B02"], output: { bands: 3 } };
-		}
-		function evaluatePixel(sample) {
-			return [2.5 * sample.B04, 2.5 * sample.B03, 2.5 * sample.B02];
-		}`
+// FetchImageForLocation fetches a 512x512 true-colour image of the bbox from
+// the best scene acquired within the search window around the 24 hours
+// starting at date (see FetchBestImage).
+func (f *Fetcher) FetchImageForLocation(bbox []float64, date time.Time) (*SatelliteImage, error) {
+	return f.FetchBestImage(context.Background(), ImageRequest{
+		BBox:   bbox,
+		From:   date.Add(-f.searchWindow),
+		To:     date.Add(24*time.Hour + f.searchWindow),
+		Bands:  DefaultRGBBands,
+		Width:  512,
+		Height: 512,
+	}, date)
+}
 
-	// The request body now uses the 'bbox' passed into the function.
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"input": map[string]interface{}{
-			"bounds": map[string]interface{}{
-				"bbox": bbox, // Use the dynamic bbox here
-				"properties": map[string]interface{}{
-					"crs": "http://www.opengis.net/def/crs/OGC/1.3/CRS84",
-				},
-			},
-			"data": []map[string]interface{}{
-				{
-					"type": "sentinel-2-l2a",
-					"dataFilter": map[string]interface{}{
-						"timeRange": map[string]string{
-							"from": date.UTC().Format(time.RFC3339),
-							"to":   date.UTC().Add(24 * time.Hour).Format(time.RFC3339),
-						},
-					},
-				},
-			},
-		},
-		"output": map[string]interface{}{
-			"width":  512,
-			"height": 512,
-			"format": map[string]string{ "type": "image/png" },
-		},
-		"evalscript": evalscript,
-	})
-	// ...The rest of the function (creating and sending the request) is identical...
+// FetchRasterForLocation fetches the given bands and spectral indices (e.g.
+// "B08", "NDVI") as a 512x512 float32 raster from the best scene acquired
+// within the search window around the 24 hours starting at date.
+func (f *Fetcher) FetchRasterForLocation(bbox []float64, date time.Time, bands []string) (*Raster, error) {
+	return f.FetchBestRaster(context.Background(), ImageRequest{
+		BBox:   bbox,
+		From:   date.Add(-f.searchWindow),
+		To:     date.Add(24*time.Hour + f.searchWindow),
+		Bands:  bands,
+		Width:  512,
+		Height: 512,
+	}, date)
+}
 
-	if err != nil { return nil, fmt.Errorf("failed to marshal request body: %w", err) }
-	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(requestBody))
-	if err != nil { return nil, fmt.Errorf("failed to create process request: %w", err) }
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+f.token)
-	resp, err := f.client.Do(req)
-	if err != nil { return nil, fmt.Errorf("failed to execute process request: %w", err) }
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to fetch image, status: %s, body: %s", resp.Status, string(bodyBytes))
+// FetchRaster fetches a multi-band float32 raster using the full set of request options.
+func (f *Fetcher) FetchRaster(ctx context.Context, req ImageRequest) (*Raster, error) {
+	raster, err := f.provider.FetchRaster(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("%s provider: %w", f.provider.Name(), err)
 	}
-	img, err := png.Decode(resp.Body)
-	if err != nil { return nil, fmt.Errorf("failed to decode image: %w", err) }
-	fmt.Printf("INFO: Successfully fetched and decoded image from Sentinel Hub.\n")
+	return raster, nil
+}
 
-	result := &SatelliteImage{
-		ID:         fmt.Sprintf("SH_IMG_BBOX%v_%d", bbox, date.Unix()),
-		AcquiredAt: date,
-		ImageData:  img,
+// FetchImage fetches an image using the full set of request options.
+func (f *Fetcher) FetchImage(ctx context.Context, req ImageRequest) (*SatelliteImage, error) {
+	img, err := f.provider.FetchImage(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("%s provider: %w", f.provider.Name(), err)
 	}
-	return result, nil
-}
\ No newline at end of file
+	return img, nil
+}
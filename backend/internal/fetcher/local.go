@@ -0,0 +1,347 @@
+// internal/fetcher/local.go
+
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Register JPEG so archived scenes can be stored in either format.
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"geowatch-backend/internal/geotiff"
+)
+
+// SceneMetadata is the JSON sidecar stored next to every archived scene.
+// A scene "tile_2023.png" is described by "tile_2023.json".
+type SceneMetadata struct {
+	ID         string    `json:"id"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	// BBox is minLon, minLat, maxLon, maxLat covered by the image.
+	BBox []float64 `json:"bbox"`
+	// Bands names the image channels in order (R, G, B, A for colour images).
+	Bands []string `json:"bands"`
+	// CloudCover is the scene's cloud cover in percent, if known.
+	CloudCover *float64 `json:"cloud_cover,omitempty"`
+}
+
+// LocalProvider serves archived scenes from a directory on disk so the
+// detection pipeline can run offline.
+type LocalProvider struct {
+	dir string
+}
+
+// NewLocalProvider creates a provider reading scenes from dir.
+func NewLocalProvider(dir string) (*LocalProvider, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open local imagery directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("local imagery path %s is not a directory", dir)
+	}
+	return &LocalProvider{dir: dir}, nil
+}
+
+// Name implements ImageryProvider.
+func (p *LocalProvider) Name() string {
+	return "local"
+}
+
+// localScene pairs a scene's metadata with the path of its image file.
+type localScene struct {
+	SceneMetadata
+	path string
+}
+
+// FetchImage implements ImageryProvider. It picks the scene inside the time
+// range that overlaps the bbox the most, crops it to the bbox and resamples it
+// to the requested size.
+func (p *LocalProvider) FetchImage(ctx context.Context, req ImageRequest) (*SatelliteImage, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	scene, err := p.findScene(ctx, req, imageExtensions)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := bandChannels(scene.Bands, req.Bands)
+	if err != nil {
+		return nil, fmt.Errorf("scene %s: %w", scene.ID, err)
+	}
+
+	file, err := os.Open(scene.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scene %s: %w", scene.path, err)
+	}
+	defer file.Close()
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode scene %s: %w", scene.path, err)
+	}
+
+	fmt.Printf("INFO: Serving local scene %s for bbox %v\n", scene.ID, req.BBox)
+
+	out := image.NewNRGBA(image.Rect(0, 0, req.Width, req.Height))
+	srcBounds := src.Bounds()
+	for y := 0; y < req.Height; y++ {
+		for x := 0; x < req.Width; x++ {
+			sx, sy, ok := scenePixel(scene.BBox, srcBounds, req, x, y)
+			if !ok {
+				// Outside the archived scene: leave fully transparent.
+				continue
+			}
+			c := color.NRGBAModel.Convert(src.At(sx, sy)).(color.NRGBA)
+			values := [4]uint8{c.R, c.G, c.B, c.A}
+			px := color.NRGBA{A: 255}
+			switch len(channels) {
+			case 1:
+				px.R, px.G, px.B = values[channels[0]], values[channels[0]], values[channels[0]]
+			default:
+				px.R = values[channels[0]]
+				px.G = values[channels[1]]
+				if len(channels) > 2 {
+					px.B = values[channels[2]]
+				}
+				if len(channels) > 3 {
+					px.A = values[channels[3]]
+				}
+			}
+			out.SetNRGBA(x, y, px)
+		}
+	}
+
+	return &SatelliteImage{
+		ID:         fmt.Sprintf("LOCAL_%s_BBOX%v", scene.ID, req.BBox),
+		AcquiredAt: scene.AcquiredAt,
+		ImageData:  out,
+		// Pixels outside the scene and transparent scene pixels are unusable.
+		Mask: MaskFromAlpha(out),
+	}, nil
+}
+
+// FetchRaster implements ImageryProvider using archived float TIFF scenes.
+// Spectral indices not stored in the scene are computed from its raw bands.
+// A scene with an SCL or CLM band is cloud-masked with it.
+func (p *LocalProvider) FetchRaster(ctx context.Context, req ImageRequest) (*Raster, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	scene, err := p.findScene(ctx, req, rasterExtensions)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(scene.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scene %s: %w", scene.path, err)
+	}
+	defer file.Close()
+	tiff, err := geotiff.Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode scene %s: %w", scene.path, err)
+	}
+	if len(tiff.Bands) != len(scene.Bands) {
+		return nil, fmt.Errorf("scene %s has %d bands but its metadata names %d", scene.ID, len(tiff.Bands), len(scene.Bands))
+	}
+
+	fmt.Printf("INFO: Serving local raster %s for bbox %v\n", scene.ID, req.BBox)
+
+	// Crop and resample every stored band, then pick or derive what was asked for.
+	full := NewRaster(fmt.Sprintf("LOCAL_%s_BBOX%v", scene.ID, req.BBox), scene.AcquiredAt,
+		req.BBox, req.Width, req.Height, scene.Bands)
+	bounds := image.Rect(0, 0, tiff.Width, tiff.Height)
+	nan := float32(math.NaN())
+	for y := 0; y < req.Height; y++ {
+		for x := 0; x < req.Width; x++ {
+			sx, sy, ok := scenePixel(scene.BBox, bounds, req, x, y)
+			for b := range full.Data {
+				v := nan
+				if ok {
+					v = tiff.At(b, sx, sy)
+					if tiff.NoData != nil && float64(v) == *tiff.NoData {
+						v = nan
+					}
+				}
+				full.Data[b][y*req.Width+x] = v
+			}
+		}
+	}
+	full.applyMask()
+	return full.Select(req.Bands)
+}
+
+var (
+	imageExtensions  = []string{".png", ".jpg", ".jpeg"}
+	rasterExtensions = []string{".tif", ".tiff"}
+)
+
+// SearchScenes implements SceneCatalog from the scenes' metadata sidecars.
+func (p *LocalProvider) SearchScenes(ctx context.Context, req ImageRequest) ([]Scene, error) {
+	found, err := p.listScenes(ctx, req, append(append([]string(nil), imageExtensions...), rasterExtensions...))
+	if err != nil {
+		return nil, err
+	}
+	scenes := make([]Scene, 0, len(found))
+	for _, s := range found {
+		scene := Scene{ID: s.ID, AcquiredAt: s.AcquiredAt, CloudCover: -1, BBox: s.BBox}
+		if s.CloudCover != nil {
+			scene.CloudCover = *s.CloudCover
+		}
+		scenes = append(scenes, scene)
+	}
+	return scenes, nil
+}
+
+// listScenes returns the scenes with one of the given file extensions that
+// were acquired in the request's time range and overlap its bbox.
+func (p *LocalProvider) listScenes(ctx context.Context, req ImageRequest, extensions []string) ([]localScene, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local imagery directory: %w", err)
+	}
+
+	var scenes []localScene
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if entry.IsDir() || !hasExtension(entry.Name(), extensions) {
+			continue
+		}
+		path := filepath.Join(p.dir, entry.Name())
+		meta, err := readSceneMetadata(path)
+		if err != nil {
+			fmt.Printf("WARNING: Skipping local scene %s: %v\n", entry.Name(), err)
+			continue
+		}
+		if meta.AcquiredAt.Before(req.From) || !meta.AcquiredAt.Before(req.To) {
+			continue
+		}
+		if bboxOverlap(meta.BBox, req.BBox) <= 0 {
+			continue
+		}
+		scenes = append(scenes, localScene{SceneMetadata: *meta, path: path})
+	}
+	return scenes, nil
+}
+
+// findScene scans the directory for scenes with one of the given file extensions
+// and returns the best match for the request.
+func (p *LocalProvider) findScene(ctx context.Context, req ImageRequest, extensions []string) (*localScene, error) {
+	scenes, err := p.listScenes(ctx, req, extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *localScene
+	bestOverlap := 0.0
+	for i := range scenes {
+		overlap := bboxOverlap(scenes[i].BBox, req.BBox)
+		// Prefer the largest overlap; on a tie, the most recent acquisition.
+		if best == nil || overlap > bestOverlap ||
+			(overlap == bestOverlap && scenes[i].AcquiredAt.After(best.AcquiredAt)) {
+			best = &scenes[i]
+			bestOverlap = overlap
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no local scene covers bbox %v between %s and %s",
+			req.BBox, req.From.Format(time.RFC3339), req.To.Format(time.RFC3339))
+	}
+	return best, nil
+}
+
+// readSceneMetadata loads the JSON sidecar for an image file.
+func readSceneMetadata(imagePath string) (*SceneMetadata, error) {
+	sidecar := strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + ".json"
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		return nil, fmt.Errorf("missing metadata sidecar: %w", err)
+	}
+	var meta SceneMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata sidecar: %w", err)
+	}
+	if len(meta.BBox) != 4 {
+		return nil, fmt.Errorf("metadata bbox must have 4 values")
+	}
+	if meta.ID == "" {
+		meta.ID = strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+	}
+	return &meta, nil
+}
+
+func hasExtension(name string, extensions []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// imageChannels is how many channels (R, G, B, A) a scene image can hold.
+const imageChannels = 4
+
+// bandChannels maps each requested band to its channel index in the scene.
+// A sidecar may list more bands than the image has channels; requesting one
+// of those is an error.
+func bandChannels(sceneBands, requested []string) ([]int, error) {
+	channels := make([]int, len(requested))
+	for i, band := range requested {
+		idx := -1
+		for j, b := range sceneBands {
+			if strings.EqualFold(b, band) {
+				idx = j
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("band %s is not available (scene has %v)", band, sceneBands)
+		}
+		if idx >= imageChannels {
+			return nil, fmt.Errorf("band %s is listed as channel %d, but scene images have only %d channels", band, idx+1, imageChannels)
+		}
+		channels[i] = idx
+	}
+	return channels, nil
+}
+
+// bboxOverlap returns the area (in square degrees) shared by two bboxes.
+func bboxOverlap(a, b []float64) float64 {
+	w := min(a[2], b[2]) - max(a[0], b[0])
+	h := min(a[3], b[3]) - max(a[1], b[1])
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+	return w * h
+}
+
+// scenePixel maps output pixel (x, y) to the nearest pixel in the scene image.
+// It reports false when the point lies outside the scene.
+func scenePixel(sceneBBox []float64, bounds image.Rectangle, req ImageRequest, x, y int) (int, int, bool) {
+	// Centre of the output pixel in geographic coordinates.
+	lon := req.BBox[0] + (float64(x)+0.5)*(req.BBox[2]-req.BBox[0])/float64(req.Width)
+	lat := req.BBox[3] - (float64(y)+0.5)*(req.BBox[3]-req.BBox[1])/float64(req.Height)
+	if lon < sceneBBox[0] || lon >= sceneBBox[2] || lat <= sceneBBox[1] || lat > sceneBBox[3] {
+		return 0, 0, false
+	}
+	fx := (lon - sceneBBox[0]) / (sceneBBox[2] - sceneBBox[0])
+	fy := (sceneBBox[3] - lat) / (sceneBBox[3] - sceneBBox[1])
+	sx := bounds.Min.X + int(fx*float64(bounds.Dx()))
+	sy := bounds.Min.Y + int(fy*float64(bounds.Dy()))
+	return sx, sy, true
+}
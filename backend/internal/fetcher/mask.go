@@ -0,0 +1,137 @@
+// internal/fetcher/mask.go
+
+package fetcher
+
+import (
+	"image"
+	"math"
+)
+
+// Mask marks which pixels of an image or raster hold usable data, row by
+// row. Clouds, cloud shadows and pixels without data are false. A nil Mask
+// means every pixel is usable.
+type Mask []bool
+
+// ValidSampleBand is the extra output band providers use to return the mask
+// alongside the requested bands.
+const ValidSampleBand = "VALID"
+
+// Scene classification (SCL) classes of Sentinel-2 L2A that are masked out:
+// no data, saturated or defective, cloud shadow, cloud (medium and high
+// probability) and thin cirrus. Snow, water and land stay usable.
+var InvalidSCLClasses = []int{0, 1, 3, 8, 9, 10}
+
+// Valid reports whether pixel i is usable.
+func (m Mask) Valid(i int) bool {
+	return m == nil || m[i]
+}
+
+// ValidFraction returns the share of usable pixels, 1 for a nil mask.
+func (m Mask) ValidFraction() float64 {
+	if m == nil {
+		return 1
+	}
+	if len(m) == 0 {
+		return 0
+	}
+	valid := 0
+	for _, ok := range m {
+		if ok {
+			valid++
+		}
+	}
+	return float64(valid) / float64(len(m))
+}
+
+// CombineMasks returns a mask that is usable only where both masks are. Nil
+// masks count as all usable, so the result is nil when both are nil.
+func CombineMasks(a, b Mask) Mask {
+	switch {
+	case a == nil && b == nil:
+		return nil
+	case a == nil:
+		return append(Mask(nil), b...)
+	case b == nil:
+		return append(Mask(nil), a...)
+	}
+	out := make(Mask, len(a))
+	for i := range out {
+		out[i] = a[i] && i < len(b) && b[i]
+	}
+	return out
+}
+
+// MaskFromSCL builds a mask from Sentinel-2 scene classification values,
+// rejecting InvalidSCLClasses and missing values.
+func MaskFromSCL(scl []float32) Mask {
+	invalid := map[int]bool{}
+	for _, class := range InvalidSCLClasses {
+		invalid[class] = true
+	}
+	mask := make(Mask, len(scl))
+	for i, v := range scl {
+		mask[i] = !isNaN32(v) && !invalid[int(math.Round(float64(v)))]
+	}
+	return mask
+}
+
+// MaskFromCLM builds a mask from the Sentinel Hub cloud mask band (CLM), in
+// which 0 is clear, 1 is cloud and 255 is no data.
+func MaskFromCLM(clm []float32) Mask {
+	mask := make(Mask, len(clm))
+	for i, v := range clm {
+		mask[i] = v == 0
+	}
+	return mask
+}
+
+// MaskFromValues builds a mask from a band of 0/1 validity values, as
+// returned in ValidSampleBand.
+func MaskFromValues(values []float32) Mask {
+	mask := make(Mask, len(values))
+	for i, v := range values {
+		mask[i] = !isNaN32(v) && v > 0.5
+	}
+	return mask
+}
+
+// MaskFromAlpha treats fully transparent pixels of a displayable image as
+// unusable, which is how providers mark clouds and missing data in PNGs.
+func MaskFromAlpha(img image.Image) Mask {
+	bounds := img.Bounds()
+	mask := make(Mask, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			mask[(y-bounds.Min.Y)*bounds.Dx()+(x-bounds.Min.X)] = a > 0
+		}
+	}
+	return mask
+}
+
+// maskFromBands builds a raster mask from a cloud band if the raster has
+// one (SCL, CLM or ValidSampleBand) and from pixels missing in any band.
+func maskFromBands(r *Raster) Mask {
+	var mask Mask
+	switch {
+	case r.BandIndex(ValidSampleBand) >= 0:
+		mask = MaskFromValues(r.Data[r.BandIndex(ValidSampleBand)])
+	case r.BandIndex("SCL") >= 0:
+		mask = MaskFromSCL(r.Data[r.BandIndex("SCL")])
+	case r.BandIndex("CLM") >= 0:
+		mask = MaskFromCLM(r.Data[r.BandIndex("CLM")])
+	default:
+		mask = make(Mask, r.Width*r.Height)
+		for i := range mask {
+			mask[i] = true
+		}
+	}
+	for _, band := range r.Data {
+		for i, v := range band {
+			if isNaN32(v) {
+				mask[i] = false
+			}
+		}
+	}
+	return mask
+}
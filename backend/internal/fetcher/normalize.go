@@ -0,0 +1,265 @@
+// internal/fetcher/normalize.go
+
+package fetcher
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Normalization selects how the target image of a comparison is brought to
+// the radiometry of the reference image, so that differences in season, sun
+// angle or atmosphere aren't mistaken for change.
+type Normalization string
+
+const (
+	// NormalizeNone leaves the image as it is.
+	NormalizeNone Normalization = "none"
+	// NormalizeHistogram matches each channel's histogram to the reference.
+	NormalizeHistogram Normalization = "histogram_match"
+	// NormalizePIF fits a per-channel linear gain and offset on
+	// pseudo-invariant features: pixels that look the same in both images
+	// apart from illumination, such as roads, roofs and bare rock.
+	NormalizePIF Normalization = "pif"
+)
+
+// DefaultPIFPercentile is the share (in percent) of jointly usable pixels
+// with the least change that are taken as pseudo-invariant features.
+const DefaultPIFPercentile = 20
+
+// minPIFPixels is the fewest invariant pixels a PIF regression is fitted on.
+const minPIFPixels = 100
+
+// ParseNormalization checks a normalisation name, defaulting to
+// NormalizeNone when empty.
+func ParseNormalization(name string) (Normalization, error) {
+	switch n := Normalization(strings.ToLower(name)); n {
+	case "", NormalizeNone:
+		return NormalizeNone, nil
+	case NormalizeHistogram, NormalizePIF:
+		return n, nil
+	default:
+		return "", fmt.Errorf("unknown normalization %q (expected %q, %q or %q)", name, NormalizeNone, NormalizeHistogram, NormalizePIF)
+	}
+}
+
+// ChannelFit is the linear mapping target*Gain + Offset fitted for one
+// colour channel by PIF regression.
+type ChannelFit struct {
+	Gain   float64 `json:"gain"`
+	Offset float64 `json:"offset"`
+}
+
+// MatchHistogram remaps each RGB channel of target so that its cumulative
+// histogram matches the reference's. Only pixels usable in both images are
+// counted; every pixel of target is remapped.
+func MatchHistogram(reference, target *SatelliteImage) (*SatelliteImage, error) {
+	pixels, err := jointPixels(reference, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(pixels) == 0 {
+		return nil, fmt.Errorf("no pixels are usable in both images")
+	}
+
+	var luts [3][256]uint8
+	for ch := 0; ch < 3; ch++ {
+		var refHist, tgtHist [256]int
+		for _, p := range pixels {
+			refHist[p.ref[ch]]++
+			tgtHist[p.tgt[ch]]++
+		}
+		refCDF, tgtCDF := cdf(refHist), cdf(tgtHist)
+		u := 0
+		for v := 0; v < 256; v++ {
+			for u < 255 && refCDF[u] < tgtCDF[v] {
+				u++
+			}
+			luts[ch][v] = uint8(u)
+		}
+	}
+
+	fmt.Printf("INFO: Matched histograms of %s to %s over %d pixels.\n", target.ID, reference.ID, len(pixels))
+	return applyLUTs(target, luts, "_histmatched"), nil
+}
+
+// PIFNormalize fits, per RGB channel, reference = Gain*target + Offset on the
+// pseudo-invariant pixels and applies it to target. The invariant pixels are
+// the percentile (0-100) of jointly usable pixels whose standardised colours
+// differ least between the images. The fitted channels are returned too.
+func PIFNormalize(reference, target *SatelliteImage, percentile float64) (*SatelliteImage, [3]ChannelFit, error) {
+	var fits [3]ChannelFit
+	if percentile <= 0 || percentile > 100 {
+		return nil, fits, fmt.Errorf("PIF percentile must be between 0 and 100, got %v", percentile)
+	}
+	pixels, err := jointPixels(reference, target)
+	if err != nil {
+		return nil, fits, err
+	}
+	if len(pixels) < minPIFPixels {
+		return nil, fits, fmt.Errorf("only %d pixels are usable in both images, need %d", len(pixels), minPIFPixels)
+	}
+
+	// Standardise each channel so that a global brightness or contrast
+	// difference doesn't count as change when picking invariant pixels.
+	var refMean, refStd, tgtMean, tgtStd [3]float64
+	for ch := 0; ch < 3; ch++ {
+		refMean[ch], refStd[ch] = meanStd(pixels, func(p jointPixel) uint8 { return p.ref[ch] })
+		tgtMean[ch], tgtStd[ch] = meanStd(pixels, func(p jointPixel) uint8 { return p.tgt[ch] })
+	}
+	distances := make([]float64, len(pixels))
+	for i, p := range pixels {
+		var sum float64
+		for ch := 0; ch < 3; ch++ {
+			d := standardise(p.ref[ch], refMean[ch], refStd[ch]) - standardise(p.tgt[ch], tgtMean[ch], tgtStd[ch])
+			sum += d * d
+		}
+		distances[i] = sum
+	}
+	sorted := append([]float64(nil), distances...)
+	sort.Float64s(sorted)
+	cut := sorted[int(math.Ceil(percentile/100*float64(len(sorted))))-1]
+
+	var invariant []jointPixel
+	for i, p := range pixels {
+		if distances[i] <= cut {
+			invariant = append(invariant, p)
+		}
+	}
+	if len(invariant) < minPIFPixels {
+		return nil, fits, fmt.Errorf("only %d pseudo-invariant pixels found, need %d", len(invariant), minPIFPixels)
+	}
+
+	var luts [3][256]uint8
+	for ch := 0; ch < 3; ch++ {
+		fits[ch] = fitLinear(invariant, ch)
+		for v := 0; v < 256; v++ {
+			luts[ch][v] = clamp8(fits[ch].Gain*float64(v) + fits[ch].Offset)
+		}
+	}
+
+	fmt.Printf("INFO: PIF normalisation of %s to %s on %d invariant pixels: gains %.3f/%.3f/%.3f, offsets %.1f/%.1f/%.1f.\n",
+		target.ID, reference.ID, len(invariant), fits[0].Gain, fits[1].Gain, fits[2].Gain, fits[0].Offset, fits[1].Offset, fits[2].Offset)
+	return applyLUTs(target, luts, "_pif"), fits, nil
+}
+
+// jointPixel holds the RGB values of one pixel in both images.
+type jointPixel struct {
+	ref, tgt [3]uint8
+}
+
+// jointPixels collects the pixels usable in both images.
+func jointPixels(reference, target *SatelliteImage) ([]jointPixel, error) {
+	if reference == nil || target == nil || reference.ImageData == nil || target.ImageData == nil {
+		return nil, fmt.Errorf("cannot normalise nil images")
+	}
+	refBounds, tgtBounds := reference.ImageData.Bounds(), target.ImageData.Bounds()
+	if refBounds.Dx() != tgtBounds.Dx() || refBounds.Dy() != tgtBounds.Dy() {
+		return nil, fmt.Errorf("image dimensions do not match")
+	}
+	width, height := refBounds.Dx(), refBounds.Dy()
+	if reference.Mask != nil && len(reference.Mask) != width*height || target.Mask != nil && len(target.Mask) != width*height {
+		return nil, fmt.Errorf("image masks do not match the image size")
+	}
+	mask := CombineMasks(reference.Mask, target.Mask)
+
+	pixels := make([]jointPixel, 0, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !mask.Valid(y*width + x) {
+				continue
+			}
+			r := color.NRGBAModel.Convert(reference.ImageData.At(refBounds.Min.X+x, refBounds.Min.Y+y)).(color.NRGBA)
+			t := color.NRGBAModel.Convert(target.ImageData.At(tgtBounds.Min.X+x, tgtBounds.Min.Y+y)).(color.NRGBA)
+			if r.A == 0 || t.A == 0 {
+				continue
+			}
+			pixels = append(pixels, jointPixel{ref: [3]uint8{r.R, r.G, r.B}, tgt: [3]uint8{t.R, t.G, t.B}})
+		}
+	}
+	return pixels, nil
+}
+
+// applyLUTs returns a copy of img with each RGB channel passed through its
+// lookup table. Alpha and the mask are kept.
+func applyLUTs(img *SatelliteImage, luts [3][256]uint8, suffix string) *SatelliteImage {
+	bounds := img.ImageData.Bounds()
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.ImageData.At(x, y)).(color.NRGBA)
+			out.SetNRGBA(x, y, color.NRGBA{R: luts[0][c.R], G: luts[1][c.G], B: luts[2][c.B], A: c.A})
+		}
+	}
+	return &SatelliteImage{
+		ID:         img.ID + suffix,
+		SceneID:    img.SceneID,
+		AcquiredAt: img.AcquiredAt,
+		ImageData:  out,
+		Mask:       img.Mask,
+	}
+}
+
+// cdf turns a histogram into a cumulative distribution between 0 and 1.
+func cdf(hist [256]int) [256]float64 {
+	var out [256]float64
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	running := 0
+	for i, n := range hist {
+		running += n
+		out[i] = float64(running) / float64(total)
+	}
+	return out
+}
+
+func meanStd(pixels []jointPixel, value func(jointPixel) uint8) (float64, float64) {
+	var sum float64
+	for _, p := range pixels {
+		sum += float64(value(p))
+	}
+	mean := sum / float64(len(pixels))
+	var variance float64
+	for _, p := range pixels {
+		d := float64(value(p)) - mean
+		variance += d * d
+	}
+	return mean, math.Sqrt(variance / float64(len(pixels)))
+}
+
+func standardise(v uint8, mean, std float64) float64 {
+	if std == 0 {
+		return 0
+	}
+	return (float64(v) - mean) / std
+}
+
+// fitLinear fits reference = gain*target + offset by least squares for one
+// channel. A channel without spread in the target keeps its gain at 1.
+func fitLinear(pixels []jointPixel, ch int) ChannelFit {
+	var sumX, sumY, sumXX, sumXY float64
+	for _, p := range pixels {
+		x, y := float64(p.tgt[ch]), float64(p.ref[ch])
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	n := float64(len(pixels))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return ChannelFit{Gain: 1, Offset: (sumY - sumX) / n}
+	}
+	gain := (n*sumXY - sumX*sumY) / denominator
+	return ChannelFit{Gain: gain, Offset: (sumY - gain*sumX) / n}
+}
+
+func clamp8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
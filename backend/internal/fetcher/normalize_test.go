@@ -0,0 +1,171 @@
+// internal/fetcher/normalize_test.go
+
+package fetcher
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// testImage builds a width x height image whose RGB values are given by
+// colour for each pixel index.
+func testImage(id string, width, height int, colour func(i int) [3]uint8) *SatelliteImage {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		c := colour(i)
+		img.SetNRGBA(i%width, i/width, color.NRGBA{R: c[0], G: c[1], B: c[2], A: 255})
+	}
+	return &SatelliteImage{ID: id, ImageData: img}
+}
+
+// gradient gives every pixel of a 20x20 image a distinct, spread out colour.
+func gradient(i int) [3]uint8 {
+	return [3]uint8{uint8(20 + i%200), uint8(30 + (i*7)%180), uint8(10 + (i*13)%220)}
+}
+
+func TestParseNormalization(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Normalization
+		wantErr bool
+	}{
+		{"", NormalizeNone, false},
+		{"none", NormalizeNone, false},
+		{"PIF", NormalizePIF, false},
+		{"histogram_match", NormalizeHistogram, false},
+		{"gamma", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseNormalization(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseNormalization(%q) = %q, %v; want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPIFNormalizeRecoversLinearFit(t *testing.T) {
+	gains := [3]float64{0.5, 1.2, 0.8}
+	offsets := [3]float64{20, -10, 30}
+	target := testImage("target", 20, 20, gradient)
+	transform := func(i int) [3]uint8 {
+		c := gradient(i)
+		var out [3]uint8
+		for ch := range out {
+			out[ch] = clamp8(gains[ch]*float64(c[ch]) + offsets[ch])
+		}
+		return out
+	}
+
+	tests := []struct {
+		name       string
+		reference  *SatelliteImage
+		percentile float64
+	}{
+		{"all pixels invariant", testImage("reference", 20, 20, transform), 100},
+		{"ignores changed pixels", testImage("reference", 20, 20, func(i int) [3]uint8 {
+			if i%10 == 0 {
+				// A tenth of the area changed completely.
+				return [3]uint8{255 - gradient(i)[0], 0, 255}
+			}
+			return transform(i)
+		}), 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalised, fits, err := PIFNormalize(tt.reference, target, tt.percentile)
+			if err != nil {
+				t.Fatalf("PIFNormalize() failed: %v", err)
+			}
+			for ch, fit := range fits {
+				if math.Abs(fit.Gain-gains[ch]) > 0.02 || math.Abs(fit.Offset-offsets[ch]) > 2 {
+					t.Errorf("channel %d fit = %+v, want gain %v and offset %v", ch, fit, gains[ch], offsets[ch])
+				}
+			}
+			want := testImage("want", 20, 20, transform).ImageData.(*image.NRGBA)
+			got := normalised.ImageData.(*image.NRGBA)
+			for i := 0; i < len(got.Pix); i++ {
+				if d := int(got.Pix[i]) - int(want.Pix[i]); d < -2 || d > 2 {
+					t.Fatalf("normalised sample %d = %d, want %d", i, got.Pix[i], want.Pix[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPIFNormalizeErrors(t *testing.T) {
+	big := testImage("big", 20, 20, gradient)
+	small := testImage("small", 5, 5, gradient)
+	masked := testImage("masked", 20, 20, gradient)
+	masked.Mask = make(Mask, 400)
+	noisy := testImage("noisy", 20, 20, func(i int) [3]uint8 {
+		c := gradient(i)
+		return [3]uint8{c[0] + uint8(i%7), c[1], c[2]}
+	})
+
+	tests := []struct {
+		name              string
+		reference, target *SatelliteImage
+		percentile        float64
+	}{
+		{"percentile zero", big, big, 0},
+		{"percentile above 100", big, big, 150},
+		{"nil image", nil, big, 20},
+		{"different sizes", big, small, 20},
+		{"too few pixels", small, small, 20},
+		{"fully masked", masked, big, 20},
+		{"too few invariant pixels", big, noisy, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := PIFNormalize(tt.reference, tt.target, tt.percentile); err == nil {
+				t.Error("PIFNormalize() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestMatchHistogram(t *testing.T) {
+	reference := testImage("reference", 20, 20, gradient)
+	tests := []struct {
+		name   string
+		target func(i int) [3]uint8
+	}{
+		{"brighter", func(i int) [3]uint8 {
+			c := gradient(i)
+			return [3]uint8{c[0] + 30, c[1] + 20, c[2] + 10}
+		}},
+		{"contrast stretched", func(i int) [3]uint8 {
+			c := gradient(i)
+			return [3]uint8{c[0] / 2, c[1] / 2, c[2] / 2}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, err := MatchHistogram(reference, testImage("target", 20, 20, tt.target))
+			if err != nil {
+				t.Fatalf("MatchHistogram() failed: %v", err)
+			}
+			got := matched.ImageData.(*image.NRGBA)
+			want := reference.ImageData.(*image.NRGBA)
+			for i := 0; i < len(got.Pix); i++ {
+				// Halving merges neighbouring values, so allow one step.
+				if d := int(got.Pix[i]) - int(want.Pix[i]); d < -1 || d > 1 {
+					t.Fatalf("matched sample %d = %d, want %d", i, got.Pix[i], want.Pix[i])
+				}
+			}
+			if matched.ID != "target_histmatched" {
+				t.Errorf("matched image ID = %q, want %q", matched.ID, "target_histmatched")
+			}
+		})
+	}
+}
+
+func TestMatchHistogramNeedsUsablePixels(t *testing.T) {
+	reference := testImage("reference", 4, 4, gradient)
+	reference.Mask = make(Mask, 16)
+	if _, err := MatchHistogram(reference, testImage("target", 4, 4, gradient)); err == nil {
+		t.Error("MatchHistogram() of a fully masked image succeeded, want an error")
+	}
+}
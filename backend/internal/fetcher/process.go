@@ -1,8 +1,8 @@
 // The purpose of internal/fetcher/process.go is to handle any pre-processing or cleaning of the images after they are downloaded but before they are compared. This is a common step in real-world image analysis pipelines.
 // Why is this useful?
-// Normalization: Satellite images can have different brightness or contrast due to the time of day or atmospheric conditions. A processing step can help normalize this.
+// Normalization: Satellite images can have different brightness or contrast due to the time of day or atmospheric conditions. ProcessImageWithOptions normalizes this with histogram matching or pseudo-invariant-feature regression against the other image.
 // Filtering: We could apply filters to reduce "noise" in the image, which might lead to a cleaner comparison and fewer false positives.
-// Cloud Masking (Advanced): A very common problem is clouds obscuring the view. An advanced processing step could try to detect clouds and exclude those areas from the comparison.
+// Cloud Masking: A very common problem is clouds obscuring the view. Providers already mark clouds, shadows and missing data in SatelliteImage.Mask, which processing keeps so detection can exclude those areas from the comparison.
 
 
 package fetcher
@@ -13,16 +13,58 @@ import (
 	"image/color"
 )
 
+// ProcessOptions selects the processing steps applied by ProcessImageWithOptions.
+type ProcessOptions struct {
+	// Normalization brings the image to the radiometry of Reference, which
+	// is usually the "before" image of a comparison.
+	Normalization Normalization `json:"normalization,omitempty"`
+	Reference     *SatelliteImage `json:"-"`
+	// PIFPercentile is used by NormalizePIF, DefaultPIFPercentile if zero.
+	PIFPercentile float64 `json:"pif_percentile,omitempty"`
+	// Brightness is added to every channel after normalisation.
+	Brightness int `json:"brightness,omitempty"`
+}
+
 // ProcessImage applies adjustments to a raw satellite image to prepare it for comparison.
 // For this implementation, we'll add a simple brightness adjustment.
 //
 // 'brightnessChange' can be positive (to brighten) or negative (to darken). A value
 // between -100 and 100 is reasonable.
 func ProcessImage(satImage *SatelliteImage, brightnessChange int) (*SatelliteImage, error) {
+	return ProcessImageWithOptions(satImage, ProcessOptions{Brightness: brightnessChange})
+}
+
+// ProcessImageWithOptions is ProcessImage with selectable steps: relative
+// radiometric normalisation against a reference image (histogram matching or
+// pseudo-invariant-feature regression), then the brightness adjustment.
+func ProcessImageWithOptions(satImage *SatelliteImage, opts ProcessOptions) (*SatelliteImage, error) {
 	if satImage == nil || satImage.ImageData == nil {
 		return nil, fmt.Errorf("cannot process a nil image")
 	}
 
+	normalization, err := ParseNormalization(string(opts.Normalization))
+	if err != nil {
+		return nil, err
+	}
+	if normalization != NormalizeNone && opts.Reference == nil {
+		return nil, fmt.Errorf("%s normalisation needs a reference image", normalization)
+	}
+	switch normalization {
+	case NormalizeHistogram:
+		if satImage, err = MatchHistogram(opts.Reference, satImage); err != nil {
+			return nil, err
+		}
+	case NormalizePIF:
+		percentile := opts.PIFPercentile
+		if percentile == 0 {
+			percentile = DefaultPIFPercentile
+		}
+		if satImage, _, err = PIFNormalize(opts.Reference, satImage, percentile); err != nil {
+			return nil, err
+		}
+	}
+	brightnessChange := opts.Brightness
+
 	fmt.Printf("INFO: Processing image %s with brightness adjustment: %d\n", satImage.ID, brightnessChange)
 
 	// Get the original image's properties.
@@ -55,8 +97,10 @@ func ProcessImage(satImage *SatelliteImage, brightnessChange int) (*SatelliteIma
 	// Return a new SatelliteImage struct with the processed image data.
 	return &SatelliteImage{
 		ID:         satImage.ID + "_processed",
+		SceneID:    satImage.SceneID,
 		AcquiredAt: satImage.AcquiredAt,
 		ImageData:  processedImg,
+		Mask:       satImage.Mask,
 	}, nil
 }
 
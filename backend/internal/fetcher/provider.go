@@ -0,0 +1,60 @@
+// internal/fetcher/provider.go
+
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultRGBBands are the Sentinel-2 bands used for a true-colour image.
+var DefaultRGBBands = []string{"B04", "B03", "B02"}
+
+// ImageRequest describes what we want from an imagery source: where, when,
+// which bands and at what pixel resolution.
+type ImageRequest struct {
+	// BBox is minLon, minLat, maxLon, maxLat in WGS84.
+	BBox []float64
+	// From and To bound the acquisition time range (To is exclusive).
+	From time.Time
+	To   time.Time
+	// Bands lists the band names to retrieve, e.g. "B04", "B03", "B02".
+	Bands []string
+	// Width and Height are the output size in pixels.
+	Width  int
+	Height int
+}
+
+// Validate checks that the request is complete enough to be served by a provider.
+func (r ImageRequest) Validate() error {
+	if len(r.BBox) != 4 {
+		return fmt.Errorf("bbox must have exactly 4 values: minLon,minLat,maxLon,maxLat")
+	}
+	if r.BBox[0] >= r.BBox[2] || r.BBox[1] >= r.BBox[3] {
+		return fmt.Errorf("bbox min values must be smaller than max values: %v", r.BBox)
+	}
+	if !r.To.After(r.From) {
+		return fmt.Errorf("time range end (%s) must be after start (%s)", r.To, r.From)
+	}
+	if len(r.Bands) == 0 {
+		return fmt.Errorf("at least one band must be requested")
+	}
+	if r.Width <= 0 || r.Height <= 0 {
+		return fmt.Errorf("output size must be positive, got %dx%d", r.Width, r.Height)
+	}
+	return nil
+}
+
+// ImageryProvider is implemented by every source of satellite imagery.
+// Callers go through Fetcher and never need to know which provider is behind it,
+// so adding a new source only means adding a new implementation of this interface.
+type ImageryProvider interface {
+	// Name identifies the provider in logs and image IDs.
+	Name() string
+	// FetchImage returns a displayable image for the request.
+	FetchImage(ctx context.Context, req ImageRequest) (*SatelliteImage, error)
+	// FetchRaster returns the requested bands as float32 values. Bands may
+	// include spectral indices such as "NDVI" (see SpectralIndices).
+	FetchRaster(ctx context.Context, req ImageRequest) (*Raster, error)
+}
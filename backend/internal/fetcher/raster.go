@@ -0,0 +1,185 @@
+// internal/fetcher/raster.go
+
+package fetcher
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Raster is a multi-band float32 image, used for scientific analysis where the
+// 8-bit display values of SatelliteImage are not good enough.
+type Raster struct {
+	ID string
+	// SceneID is the provider's ID of the acquisition, when it was chosen
+	// through a catalog search. Composites list their source scenes,
+	// comma-separated.
+	SceneID    string
+	AcquiredAt time.Time
+	// BBox is minLon, minLat, maxLon, maxLat covered by the raster.
+	BBox   []float64
+	Width  int
+	Height int
+	// Bands names each entry of Data, e.g. "B04" or "NDVI".
+	Bands []string
+	// Data holds Width*Height values per band, row by row. NaN means no data.
+	Data [][]float32
+	// Mask marks the pixels free of cloud, cloud shadow and missing data.
+	// Nil means every pixel is usable.
+	Mask Mask
+}
+
+// NewRaster allocates an empty raster with the given bands.
+func NewRaster(id string, acquiredAt time.Time, bbox []float64, width, height int, bands []string) *Raster {
+	data := make([][]float32, len(bands))
+	for i := range data {
+		data[i] = make([]float32, width*height)
+	}
+	return &Raster{
+		ID:         id,
+		AcquiredAt: acquiredAt,
+		BBox:       bbox,
+		Width:      width,
+		Height:     height,
+		Bands:      append([]string(nil), bands...),
+		Data:       data,
+	}
+}
+
+// BandIndex returns the position of a band, or -1 if the raster doesn't have it.
+func (r *Raster) BandIndex(name string) int {
+	for i, b := range r.Bands {
+		if strings.EqualFold(b, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Band returns the values of a band by name.
+func (r *Raster) Band(name string) ([]float32, error) {
+	idx := r.BandIndex(name)
+	if idx < 0 {
+		return nil, fmt.Errorf("raster %s has no band %s (has %v)", r.ID, name, r.Bands)
+	}
+	return r.Data[idx], nil
+}
+
+// SpectralIndex is a normalised difference (A - B) / (A + B) of two Sentinel-2 bands.
+type SpectralIndex struct {
+	Name string
+	A, B string
+}
+
+// SpectralIndices are the derived indices that can be requested as bands.
+var SpectralIndices = map[string]SpectralIndex{
+	"NDVI": {Name: "NDVI", A: "B08", B: "B04"}, // vegetation
+	"NDWI": {Name: "NDWI", A: "B03", B: "B08"}, // water
+	"NDBI": {Name: "NDBI", A: "B11", B: "B08"}, // built-up
+	"NBR":  {Name: "NBR", A: "B08", B: "B12"},  // burn ratio
+}
+
+// LookupIndex reports whether name is a spectral index rather than a raw band.
+func LookupIndex(name string) (SpectralIndex, bool) {
+	idx, ok := SpectralIndices[strings.ToUpper(name)]
+	return idx, ok
+}
+
+// SourceBands returns the raw bands needed to produce the requested bands and
+// indices, without duplicates and in first-use order.
+func SourceBands(bands []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(b string) {
+		b = strings.ToUpper(b)
+		if !seen[b] {
+			seen[b] = true
+			out = append(out, b)
+		}
+	}
+	for _, b := range bands {
+		if idx, ok := LookupIndex(b); ok {
+			add(idx.A)
+			add(idx.B)
+		} else {
+			add(b)
+		}
+	}
+	return out
+}
+
+// NormalizedDifference computes (a - b) / (a + b) pixel by pixel. Pixels where
+// either input is missing or the sum is zero are set to NaN.
+func NormalizedDifference(a, b []float32) []float32 {
+	out := make([]float32, len(a))
+	for i := range a {
+		sum := a[i] + b[i]
+		if isNaN32(a[i]) || isNaN32(b[i]) || sum == 0 {
+			out[i] = float32(math.NaN())
+			continue
+		}
+		out[i] = (a[i] - b[i]) / sum
+	}
+	return out
+}
+
+// ComputeIndex derives a spectral index from the raw bands in the raster.
+func (r *Raster) ComputeIndex(name string) ([]float32, error) {
+	idx, ok := LookupIndex(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown spectral index %s", name)
+	}
+	a, err := r.Band(idx.A)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute %s: %w", idx.Name, err)
+	}
+	b, err := r.Band(idx.B)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute %s: %w", idx.Name, err)
+	}
+	return NormalizedDifference(a, b), nil
+}
+
+// Select returns a raster containing exactly the requested bands, in order.
+// Spectral indices missing from r are computed from its raw bands.
+func (r *Raster) Select(bands []string) (*Raster, error) {
+	out := &Raster{
+		ID:         r.ID,
+		SceneID:    r.SceneID,
+		AcquiredAt: r.AcquiredAt,
+		BBox:       r.BBox,
+		Width:      r.Width,
+		Height:     r.Height,
+		Mask:       r.Mask,
+	}
+	for _, name := range bands {
+		values, err := r.Band(name)
+		if err != nil {
+			if _, isIndex := LookupIndex(name); !isIndex {
+				return nil, err
+			}
+			if values, err = r.ComputeIndex(name); err != nil {
+				return nil, err
+			}
+		}
+		out.Bands = append(out.Bands, strings.ToUpper(name))
+		out.Data = append(out.Data, values)
+	}
+	return out, nil
+}
+
+// applyMask sets the raster's mask from its cloud or validity band (see
+// maskFromBands) and drops ValidSampleBand, which only exists to carry it.
+func (r *Raster) applyMask() {
+	r.Mask = maskFromBands(r)
+	if idx := r.BandIndex(ValidSampleBand); idx >= 0 {
+		r.Bands = append(r.Bands[:idx:idx], r.Bands[idx+1:]...)
+		r.Data = append(r.Data[:idx:idx], r.Data[idx+1:]...)
+	}
+}
+
+func isNaN32(v float32) bool {
+	return v != v
+}
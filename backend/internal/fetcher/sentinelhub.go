@@ -0,0 +1,421 @@
+// internal/fetcher/sentinelhub.go
+
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"geowatch-backend/internal/geotiff"
+)
+
+const (
+	sentinelHubTokenURL   = "https://services.sentinel-hub.com/oauth/token"
+	sentinelHubProcessURL = "https://services.sentinel-hub.com/api/v1/process"
+	sentinelHubCatalogURL = "https://services.sentinel-hub.com/api/v1/catalog/1.0.0/search"
+)
+
+// sentinelHubCatalogPages bounds how many pages of search results are read.
+const sentinelHubCatalogPages = 5
+
+type authTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// SentinelHubProvider fetches Sentinel-2 L2A imagery from the Sentinel Hub process API.
+type SentinelHubProvider struct {
+	client       *http.Client
+	clientID     string
+	clientSecret string
+	token        string
+	tokenExpiry  time.Time
+	mu           sync.Mutex
+}
+
+// NewSentinelHubProvider creates a provider using the OAuth client credentials
+// from SENTINELHUB_CLIENT_ID and SENTINELHUB_CLIENT_SECRET.
+func NewSentinelHubProvider() (*SentinelHubProvider, error) {
+	clientID := os.Getenv("SENTINELHUB_CLIENT_ID")
+	clientSecret := os.Getenv("SENTINELHUB_CLIENT_SECRET")
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("environment variables SENTINELHUB_CLIENT_ID and SENTINELHUB_CLIENT_SECRET must be set")
+	}
+	return &SentinelHubProvider{
+		client:       &http.Client{Timeout: time.Minute * 2},
+		clientID:     clientID,
+		clientSecret: clientSecret,
+	}, nil
+}
+
+// Name implements ImageryProvider.
+func (p *SentinelHubProvider) Name() string {
+	return "sentinelhub"
+}
+
+func (p *SentinelHubProvider) getAccessToken(ctx context.Context) error {
+	data := url.Values{}
+	data.Set("grant_type", "client_credentials")
+	data.Set("client_id", p.clientID)
+	data.Set("client_secret", p.clientSecret)
+	req, err := http.NewRequestWithContext(ctx, "POST", sentinelHubTokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute token request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to get token, status: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+	var tokenResp authTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return fmt.Errorf("failed to decode token response: %w", err)
+	}
+	p.token = tokenResp.AccessToken
+	p.tokenExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn-60) * time.Second)
+	fmt.Println("INFO: Successfully fetched new Sentinel Hub access token.")
+	return nil
+}
+
+// ensureValidToken returns a usable access token, refreshing it if needed.
+func (p *SentinelHubProvider) ensureValidToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == "" || time.Now().After(p.tokenExpiry) {
+		fmt.Println("INFO: Access token is expired or missing. Fetching a new one.")
+		if err := p.getAccessToken(ctx); err != nil {
+			return "", err
+		}
+	}
+	return p.token, nil
+}
+
+// catalogSearchResponse is the part of a Catalog API (STAC) search response
+// that we use.
+type catalogSearchResponse struct {
+	Features []struct {
+		ID         string    `json:"id"`
+		BBox       []float64 `json:"bbox"`
+		Properties struct {
+			Datetime   time.Time `json:"datetime"`
+			CloudCover *float64  `json:"eo:cloud_cover"`
+		} `json:"properties"`
+	} `json:"features"`
+	Context struct {
+		Next *int `json:"next"`
+	} `json:"context"`
+}
+
+// SearchScenes implements SceneCatalog using the Sentinel Hub Catalog API.
+func (p *SentinelHubProvider) SearchScenes(ctx context.Context, req ImageRequest) ([]Scene, error) {
+	token, err := p.ensureValidToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("INFO: Searching Sentinel Hub catalog for bbox %v between %s and %s\n",
+		req.BBox, req.From.Format("2006-01-02"), req.To.Format("2006-01-02"))
+
+	search := map[string]interface{}{
+		"bbox":        req.BBox,
+		"datetime":    req.From.UTC().Format(time.RFC3339) + "/" + req.To.UTC().Format(time.RFC3339),
+		"collections": []string{"sentinel-2-l2a"},
+		"limit":       100,
+	}
+	var scenes []Scene
+	for page := 0; page < sentinelHubCatalogPages; page++ {
+		requestBody, err := json.Marshal(search)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal catalog search: %w", err)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", sentinelHubCatalogURL, bytes.NewBuffer(requestBody))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create catalog request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+		resp, err := p.client.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute catalog request: %w", err)
+		}
+		var result catalogSearchResponse
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("catalog search failed, status: %s, body: %s", resp.Status, string(bodyBytes))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode catalog response: %w", err)
+		}
+
+		for _, feature := range result.Features {
+			scene := Scene{
+				ID:         feature.ID,
+				AcquiredAt: feature.Properties.Datetime,
+				CloudCover: -1,
+				BBox:       feature.BBox,
+			}
+			if feature.Properties.CloudCover != nil {
+				scene.CloudCover = *feature.Properties.CloudCover
+			}
+			scenes = append(scenes, scene)
+		}
+		if result.Context.Next == nil {
+			break
+		}
+		search["next"] = *result.Context.Next
+	}
+	return scenes, nil
+}
+
+// FetchImage implements ImageryProvider. Between one and four bands can be
+// requested; they are scaled by 2.5 for display and returned as a PNG. With
+// up to three bands (two are padded with a zero blue channel), clouds, cloud shadows and missing data (from the SCL
+// band) are returned as transparent pixels and set in the image's Mask.
+func (p *SentinelHubProvider) FetchImage(ctx context.Context, req ImageRequest) (*SatelliteImage, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if len(req.Bands) > 4 {
+		return nil, fmt.Errorf("a displayable image supports at most 4 bands, got %d", len(req.Bands))
+	}
+
+	fmt.Printf("INFO: Fetching image from Sentinel Hub for bbox %v between %s and %s\n",
+		req.BBox, req.From.Format("2006-01-02"), req.To.Format("2006-01-02"))
+
+	withMask := len(req.Bands) < 4
+	body, err := p.process(ctx, req, displayEvalscript(req.Bands, withMask), "image/png")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	img, err := png.Decode(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	fmt.Printf("INFO: Successfully fetched and decoded image from Sentinel Hub.\n")
+
+	satImage := &SatelliteImage{
+		ID:         fmt.Sprintf("SH_IMG_BBOX%v_%d", req.BBox, req.From.Unix()),
+		AcquiredAt: req.From,
+		ImageData:  img,
+	}
+	if withMask {
+		satImage.Mask = MaskFromAlpha(img)
+		fmt.Printf("INFO: %.0f%% of the image is free of cloud and shadow.\n", 100*satImage.Mask.ValidFraction())
+	}
+	return satImage, nil
+}
+
+// process sends a request to the process API and returns the response body.
+// The caller is responsible for closing it.
+func (p *SentinelHubProvider) process(ctx context.Context, req ImageRequest, evalscript, mimeType string) (io.ReadCloser, error) {
+	token, err := p.ensureValidToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"input": map[string]interface{}{
+			"bounds": map[string]interface{}{
+				"bbox": req.BBox,
+				"properties": map[string]interface{}{
+					"crs": "http://www.opengis.net/def/crs/OGC/1.3/CRS84",
+				},
+			},
+			"data": []map[string]interface{}{
+				{
+					"type": "sentinel-2-l2a",
+					"dataFilter": map[string]interface{}{
+						"timeRange": map[string]string{
+							"from": req.From.UTC().Format(time.RFC3339),
+							"to":   req.To.UTC().Format(time.RFC3339),
+						},
+					},
+				},
+			},
+		},
+		"output": map[string]interface{}{
+			"width":  req.Width,
+			"height": req.Height,
+			"responses": []map[string]interface{}{
+				{"identifier": "default", "format": map[string]string{"type": mimeType}},
+			},
+		},
+		"evalscript": evalscript,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", sentinelHubProcessURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create process request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute process request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to fetch image, status: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+	return resp.Body, nil
+}
+
+// displayEvalscript builds an evalscript returning the given bands brightened by 2.5,
+// which is what we have always used for true-colour previews. withMask adds an
+// alpha band that is 0 over clouds, cloud shadows and missing data. A PNG has
+// either one or three colour channels before its alpha, so two bands are
+// padded with a zero band to keep the flag in the alpha channel.
+func displayEvalscript(bands []string, withMask bool) string {
+	quoted := make([]string, len(bands))
+	scaled := make([]string, len(bands))
+	for i, b := range bands {
+		quoted[i] = fmt.Sprintf("%q", b)
+		scaled[i] = "2.5 * sample." + b
+	}
+	if withMask && len(scaled) == 2 {
+		scaled = append(scaled, "0")
+	}
+	if !withMask {
+		return fmt.Sprintf(`
+		//VERSION=3
+		function setup() {
+			return { input: [%s], output: { bands: %d } };
+		}
+		function evaluatePixel(sample) {
+			return [%s];
+		}`, strings.Join(quoted, ", "), len(bands), strings.Join(scaled, ", "))
+	}
+	return fmt.Sprintf(`
+		//VERSION=3
+		function setup() {
+			return { input: [%s, "SCL", "dataMask"], output: { bands: %d } };
+		}
+		%s
+		function evaluatePixel(sample) {
+			return [%s, isValid(sample) ? 1 : 0];
+		}`, strings.Join(quoted, ", "), len(scaled)+1, validEvalFunction(), strings.Join(scaled, ", "))
+}
+
+// validEvalFunction is the evalscript function deciding whether a pixel is
+// usable, matching InvalidSCLClasses.
+func validEvalFunction() string {
+	classes := make([]string, len(InvalidSCLClasses))
+	for i, c := range InvalidSCLClasses {
+		classes[i] = fmt.Sprint(c)
+	}
+	return fmt.Sprintf(`function isValid(sample) {
+			return sample.dataMask === 1 && [%s].indexOf(sample.SCL) < 0;
+		}`, strings.Join(classes, ", "))
+}
+
+// FetchRaster implements ImageryProvider. The bands are requested as FLOAT32
+// reflectances in a TIFF; spectral indices are computed by the evalscript so
+// only one request is needed. An extra band carries the cloud and shadow
+// mask derived from SCL, which ends up in the raster's Mask.
+func (p *SentinelHubProvider) FetchRaster(ctx context.Context, req ImageRequest) (*Raster, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("INFO: Fetching %v raster from Sentinel Hub for bbox %v between %s and %s\n",
+		req.Bands, req.BBox, req.From.Format("2006-01-02"), req.To.Format("2006-01-02"))
+
+	body, err := p.process(ctx, req, rasterEvalscript(req.Bands), "image/tiff")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	tiff, err := geotiff.Read(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode raster: %w", err)
+	}
+	if len(tiff.Bands) != len(req.Bands)+1 {
+		return nil, fmt.Errorf("expected %d bands from Sentinel Hub, got %d", len(req.Bands)+1, len(tiff.Bands))
+	}
+	if tiff.Width != req.Width || tiff.Height != req.Height {
+		return nil, fmt.Errorf("expected a %dx%d raster from Sentinel Hub, got %dx%d", req.Width, req.Height, tiff.Width, tiff.Height)
+	}
+	fmt.Printf("INFO: Successfully fetched and decoded %d-band raster from Sentinel Hub.\n", len(tiff.Bands))
+
+	bands := make([]string, len(req.Bands), len(req.Bands)+1)
+	for i, b := range req.Bands {
+		bands[i] = strings.ToUpper(b)
+	}
+	raster := &Raster{
+		ID:         fmt.Sprintf("SH_RASTER_BBOX%v_%d", req.BBox, req.From.Unix()),
+		AcquiredAt: req.From,
+		BBox:       req.BBox,
+		Width:      tiff.Width,
+		Height:     tiff.Height,
+		Bands:      append(bands, ValidSampleBand),
+		Data:       tiff.Bands,
+	}
+	raster.applyMask()
+	fmt.Printf("INFO: %.0f%% of the raster is free of cloud and shadow.\n", 100*raster.Mask.ValidFraction())
+	return raster, nil
+}
+
+// rasterEvalscript builds an evalscript that returns each requested band or
+// spectral index as a FLOAT32 value, followed by a band that is 1 for usable
+// pixels and 0 over clouds and shadows. Pixels without data come back as NaN.
+func rasterEvalscript(bands []string) string {
+	inputs := SourceBands(bands)
+	quoted := make([]string, len(inputs))
+	units := make([]string, len(inputs))
+	for i, b := range inputs {
+		quoted[i] = fmt.Sprintf("%q", b)
+		units[i] = `"REFLECTANCE"`
+	}
+	outputs := make([]string, len(bands))
+	for i, b := range bands {
+		if idx, ok := LookupIndex(b); ok {
+			outputs[i] = fmt.Sprintf("nd(sample.%s, sample.%s)", idx.A, idx.B)
+		} else {
+			outputs[i] = "sample." + strings.ToUpper(b)
+		}
+	}
+	nan := strings.TrimSuffix(strings.Repeat("NaN, ", len(bands)), ", ")
+	return fmt.Sprintf(`
+		//VERSION=3
+		function setup() {
+			return {
+				input: [{ bands: [%s, "SCL", "dataMask"], units: [%s, "DN", "DN"] }],
+				output: { bands: %d, sampleType: "FLOAT32" }
+			};
+		}
+		function nd(a, b) {
+			return (a + b) === 0 ? NaN : (a - b) / (a + b);
+		}
+		%s
+		function evaluatePixel(sample) {
+			if (sample.dataMask === 0) {
+				return [%s, 0];
+			}
+			return [%s, isValid(sample) ? 1 : 0];
+		}`, strings.Join(quoted, ", "), strings.Join(units, ", "), len(bands)+1, validEvalFunction(), nan, strings.Join(outputs, ", "))
+}
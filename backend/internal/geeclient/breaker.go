@@ -0,0 +1,86 @@
+// internal/geeclient/breaker.go
+
+package geeclient
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// breaker is a consecutive-failure circuit breaker. After threshold failures
+// in a row it opens and rejects calls for cooldown; then it lets a single
+// trial call through and closes again if that call succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+	now       func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a call may be made now.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || b.now().Before(b.openUntil) {
+		return false
+	}
+	// Half-open: allow one call to test the service.
+	b.trial = true
+	return true
+}
+
+// success records a call that reached a healthy service.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures >= b.threshold && b.threshold > 0 {
+		fmt.Println("INFO: Analysis service recovered, closing circuit breaker.")
+	}
+	b.failures = 0
+	b.trial = false
+}
+
+// failure records a failed call, opening the breaker at the threshold.
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+		fmt.Printf("WARNING: Analysis service failed %d times in a row, opening circuit breaker for %s.\n", b.failures, b.cooldown)
+	}
+}
+
+// release ends a call whose outcome says nothing about the service's health.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// remaining returns how long the breaker stays open.
+func (b *breaker) remaining() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return 0
+	}
+	return max(b.openUntil.Sub(b.now()), 0)
+}
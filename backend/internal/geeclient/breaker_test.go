@@ -0,0 +1,162 @@
+// internal/geeclient/breaker_test.go
+
+package geeclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for the breaker.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func TestBreaker(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := newBreaker(2, time.Minute)
+	b.now = clock.now
+
+	b.failure()
+	if !b.allow() {
+		t.Fatal("breaker opened below the threshold")
+	}
+	b.failure()
+	if b.allow() {
+		t.Fatal("breaker is closed after reaching the threshold")
+	}
+	if got := b.remaining(); got != time.Minute {
+		t.Errorf("remaining() = %s, want 1m", got)
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	if !b.allow() {
+		t.Fatal("breaker didn't let a trial call through after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("breaker let a second call through while half-open")
+	}
+	b.failure()
+	if b.allow() {
+		t.Fatal("breaker closed after a failed trial call")
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	if !b.allow() {
+		t.Fatal("breaker didn't let a trial call through after the second cooldown")
+	}
+	b.success()
+	if !b.allow() || !b.allow() || b.remaining() != 0 {
+		t.Fatal("breaker didn't close after a successful trial call")
+	}
+}
+
+func TestBreakerReleaseEndsTrial(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	b := newBreaker(1, time.Second)
+	b.now = clock.now
+	b.failure()
+	clock.t = clock.t.Add(time.Second)
+	if !b.allow() {
+		t.Fatal("breaker didn't let a trial call through")
+	}
+	b.release()
+	if !b.allow() {
+		t.Error("breaker didn't allow a new trial after a released one")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		b.failure()
+	}
+	if !b.allow() || b.remaining() != 0 {
+		t.Error("a breaker with no threshold opened")
+	}
+}
+
+func TestAnalyzeRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		calls    int32
+		wantErr  bool
+		// retryable is whether the returned ServiceError may succeed later.
+		retryable bool
+	}{
+		{"succeeds first time", []int{200}, 1, false, false},
+		{"retries server errors", []int{503, 500, 200}, 3, false, false},
+		{"gives up after the retries", []int{500, 502, 503, 200}, 3, true, true},
+		{"doesn't retry rejected requests", []int{400, 200}, 1, true, false},
+		{"retries rate limiting", []int{429, 200}, 2, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[calls.Add(1)-1]
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					w.Write([]byte(`{"error": "failed"}`))
+					return
+				}
+				w.Header().Set("Content-Type", "image/png")
+				w.Write([]byte("png"))
+			}))
+			defer server.Close()
+
+			client := New(Config{BaseURL: server.URL, Timeout: time.Second, MaxRetries: 2, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+			resp, err := client.Analyze(context.Background(), AnalysisRequest{})
+			if got := calls.Load(); got != tt.calls {
+				t.Errorf("service was called %d times, want %d", got, tt.calls)
+			}
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Analyze() failed: %v", err)
+				}
+				if string(resp.Data) != "png" || resp.ContentType != "image/png" {
+					t.Errorf("Analyze() = %+v, want the PNG", resp)
+				}
+				return
+			}
+			var serviceErr *ServiceError
+			if !errors.As(err, &serviceErr) {
+				t.Fatalf("Analyze() error = %v, want a ServiceError", err)
+			}
+			if serviceErr.Message != "failed" || serviceErr.Retryable() != tt.retryable {
+				t.Errorf("ServiceError = %+v, want message %q and retryable %v", serviceErr, "failed", tt.retryable)
+			}
+		})
+	}
+}
+
+func TestAnalyzeOpensBreaker(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := New(Config{BaseURL: server.URL, Timeout: time.Second, BreakerThreshold: 2, BreakerCooldown: time.Hour})
+	for i := 0; i < 2; i++ {
+		if _, err := client.Analyze(context.Background(), AnalysisRequest{}); err == nil {
+			t.Fatal("Analyze() succeeded against a failing service")
+		}
+	}
+	if _, err := client.Analyze(context.Background(), AnalysisRequest{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Analyze() with an open breaker = %v, want ErrCircuitOpen", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("service was called %d times, want 2", calls.Load())
+	}
+	if client.RetryAfter() <= 0 {
+		t.Error("RetryAfter() is zero while the breaker is open")
+	}
+}
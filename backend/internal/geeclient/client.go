@@ -0,0 +1,246 @@
+// internal/geeclient/client.go
+
+// Package geeclient talks to the Python GEE analysis service. Each attempt is
+// bound to the caller's context and a per-attempt timeout, transient failures
+// are retried with jittered exponential backoff while the caller's deadline
+// allows, and a circuit breaker stops
+// calling the service for a while once it keeps failing.
+package geeclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OutputMagnitude asks the service for the raw change magnitude grid instead
+// of the palette PNG. The body is little-endian float32 samples, row by row
+// from the north-west corner, with negative values marking pixels without
+// data. The X-Grid-Width, X-Grid-Height and X-Grid-BBox headers describe the
+// grid.
+const OutputMagnitude = "magnitude"
+
+// AnalysisRequest is the body of an analysis request.
+type AnalysisRequest struct {
+	AOI       [][][]float64 `json:"aoi"`
+	StartDate string        `json:"startDate"`
+	EndDate   string        `json:"endDate"`
+	// Format is the result format: "png" (default) or "geotiff".
+	Format string `json:"format,omitempty"`
+	// Output tells the Python service what to return: the palette PNG by
+	// default, or the raw change magnitude (OutputMagnitude) for GeoTIFF export.
+	Output string `json:"output,omitempty"`
+	// Pipeline, when set, is a processing pipeline (see internal/pipeline).
+	// Earth Engine can't run it, so such analyses are made by the backend
+	// from imagery of the same July windows, processed by the pipeline's
+	// steps before the images are compared.
+	Pipeline json.RawMessage `json:"pipeline,omitempty"`
+}
+
+// HasPipeline reports whether the request declares a processing pipeline.
+func (r AnalysisRequest) HasPipeline() bool {
+	return len(r.Pipeline) > 0 && string(r.Pipeline) != "null"
+}
+
+// Response is a successful answer from the service.
+type Response struct {
+	Data        []byte
+	ContentType string
+	Header      http.Header
+}
+
+// ErrCircuitOpen is returned without calling the service while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("analysis service is unavailable (circuit breaker open)")
+
+// ServiceError is returned when the service answers with an error status.
+// Message is the "error" field of the service's JSON body when it has one.
+type ServiceError struct {
+	StatusCode int
+	Message    string
+	Body       string
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("analysis service returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// Retryable reports whether the request may succeed if sent again.
+func (e *ServiceError) Retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Config controls timeouts, retries and the circuit breaker.
+type Config struct {
+	// BaseURL is the service address, e.g. http://python-gee-service:5000.
+	BaseURL string
+	// Timeout bounds a single attempt. The caller's context still applies.
+	Timeout time.Duration
+	// MaxRetries is how many times a failed attempt is retried.
+	MaxRetries int
+	// BaseBackoff and MaxBackoff bound the wait before a retry, which grows
+	// exponentially and is picked at random below the bound.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// BreakerThreshold consecutive failures open the circuit breaker for
+	// BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// DefaultConfig returns the settings used when nothing else is configured.
+// Earth Engine composites are slow, so the attempt timeout is generous.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:          5 * time.Minute,
+		MaxRetries:       2,
+		BaseBackoff:      500 * time.Millisecond,
+		MaxBackoff:       10 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+// Client calls the analysis service.
+type Client struct {
+	config     Config
+	httpClient *http.Client
+	breaker    *breaker
+}
+
+// New creates a Client.
+func New(config Config) *Client {
+	return &Client{
+		config:     config,
+		httpClient: &http.Client{},
+		breaker:    newBreaker(config.BreakerThreshold, config.BreakerCooldown),
+	}
+}
+
+// Analyze sends an analysis request to /analyze-changes and returns the
+// service's response.
+func (c *Client) Analyze(ctx context.Context, request AnalysisRequest) (*Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode analysis request: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := c.backoff(attempt)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+				// The caller's deadline would pass before the retry starts.
+				return nil, lastErr
+			}
+			fmt.Printf("WARNING: Analysis service attempt %d failed (%v), retrying in %s.\n", attempt, lastErr, wait.Round(time.Millisecond))
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		if !c.breaker.allow() {
+			if lastErr != nil {
+				// The breaker opened during our own retries; the failure
+				// that caused it is more useful to the caller.
+				return nil, lastErr
+			}
+			return nil, ErrCircuitOpen
+		}
+		resp, err := c.do(ctx, body)
+		if err == nil {
+			c.breaker.success()
+			return resp, nil
+		}
+		lastErr = err
+
+		var serviceErr *ServiceError
+		if errors.As(err, &serviceErr) && !serviceErr.Retryable() {
+			// The service is healthy; the request itself was rejected.
+			c.breaker.success()
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about the service.
+			c.breaker.release()
+			return nil, ctx.Err()
+		}
+		c.breaker.failure()
+	}
+	return nil, lastErr
+}
+
+// RetryAfter returns how long the circuit breaker stays open, or zero when
+// calls are allowed.
+func (c *Client) RetryAfter() time.Duration {
+	return c.breaker.remaining()
+}
+
+// do makes one attempt.
+func (c *Client) do(ctx context.Context, body []byte) (*Response, error) {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+
+	url := strings.TrimSuffix(c.config.BaseURL, "/") + "/analyze-changes"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for the analysis service: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the analysis service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the analysis service response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newServiceError(resp.StatusCode, data)
+	}
+	return &Response{Data: data, ContentType: resp.Header.Get("Content-Type"), Header: resp.Header}, nil
+}
+
+// backoff returns a random wait below BaseBackoff*2^(attempt-1), capped at
+// MaxBackoff ("full jitter").
+func (c *Client) backoff(attempt int) time.Duration {
+	limit := c.config.BaseBackoff << (attempt - 1)
+	if limit <= 0 || (c.config.MaxBackoff > 0 && limit > c.config.MaxBackoff) {
+		limit = c.config.MaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+func newServiceError(status int, body []byte) *ServiceError {
+	serviceErr := &ServiceError{StatusCode: status, Body: string(body)}
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		serviceErr.Message = payload.Error
+	} else {
+		serviceErr.Message = strings.TrimSpace(string(body))
+		if serviceErr.Message == "" {
+			serviceErr.Message = http.StatusText(status)
+		}
+	}
+	return serviceErr
+}
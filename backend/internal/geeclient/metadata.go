@@ -0,0 +1,112 @@
+// internal/geeclient/metadata.go
+
+package geeclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MetadataHeader is the response header in which the service describes how
+// a result was produced, as JSON matching Metadata.
+const MetadataHeader = "X-Analysis-Metadata"
+
+// Composite describes one of the two yearly composites of an analysis.
+type Composite struct {
+	Year int `json:"year"`
+	// Collection is the Earth Engine image collection the scenes came from,
+	// e.g. LANDSAT/LC09/C02/T1_L2, or the imagery provider for analyses with
+	// a processing pipeline.
+	Collection string `json:"collection"`
+	// StartDate and EndDate bound the acquisition window (end exclusive).
+	StartDate  string   `json:"start_date"`
+	EndDate    string   `json:"end_date"`
+	SceneCount int      `json:"scene_count"`
+	SceneIDs   []string `json:"scene_ids"`
+}
+
+// Stretch is the range of change magnitude mapped onto the palette.
+type Stretch struct {
+	Min float64 `json:"min"`
+	P98 float64 `json:"p98"`
+}
+
+// Metadata describes how an analysis result was produced, so that analysts
+// can interpret and reproduce it.
+type Metadata struct {
+	Before Composite `json:"before"`
+	After  Composite `json:"after"`
+	// Stretch and Palette describe the colouring of PNG overlays.
+	Stretch *Stretch `json:"stretch,omitempty"`
+	Palette []string `json:"palette,omitempty"`
+	// BBox is [minLon, minLat, maxLon, maxLat] of the result grid.
+	BBox   []float64 `json:"bbox"`
+	Width  int       `json:"width"`
+	Height int       `json:"height"`
+	CRS    string    `json:"crs"`
+	// Tiles is the number of sub-requests mosaicked into the result, zero
+	// when it came from a single request.
+	Tiles int `json:"tiles,omitempty"`
+	// Pipeline is the processing pipeline of the request as it ran, with
+	// default settings filled in and skipped optional steps marked.
+	Pipeline json.RawMessage `json:"pipeline,omitempty"`
+}
+
+// Metadata parses the service's description of the result. It returns nil
+// without an error when the service didn't send one.
+func (r *Response) Metadata() (*Metadata, error) {
+	value := r.Header.Get(MetadataHeader)
+	if value == "" {
+		return nil, nil
+	}
+	var metadata Metadata
+	if err := json.Unmarshal([]byte(value), &metadata); err != nil {
+		return nil, fmt.Errorf("analysis service returned invalid metadata: %w", err)
+	}
+	return &metadata, nil
+}
+
+// Merge folds the metadata of another tile of the same analysis into m: the
+// scene lists are united and the bbox grows to cover both. The grid size and
+// stretch are left alone, as they belong to the mosaic rather than the tiles.
+func (m *Metadata) Merge(other *Metadata) {
+	if other == nil {
+		return
+	}
+	m.Before.merge(other.Before)
+	m.After.merge(other.After)
+	if len(m.BBox) != 4 {
+		m.BBox = append([]float64(nil), other.BBox...)
+	} else if len(other.BBox) == 4 {
+		m.BBox = []float64{
+			math.Min(m.BBox[0], other.BBox[0]),
+			math.Min(m.BBox[1], other.BBox[1]),
+			math.Max(m.BBox[2], other.BBox[2]),
+			math.Max(m.BBox[3], other.BBox[3]),
+		}
+	}
+	if m.CRS == "" {
+		m.CRS = other.CRS
+	}
+}
+
+// merge unites the scenes of two tiles' composites. Neighbouring tiles
+// usually share scenes, so they are deduplicated by ID.
+func (c *Composite) merge(other Composite) {
+	if c.Collection == "" {
+		c.Year, c.Collection, c.StartDate, c.EndDate = other.Year, other.Collection, other.StartDate, other.EndDate
+	}
+	seen := make(map[string]bool, len(c.SceneIDs)+len(other.SceneIDs))
+	ids := make([]string, 0, len(c.SceneIDs)+len(other.SceneIDs))
+	for _, id := range append(append([]string(nil), c.SceneIDs...), other.SceneIDs...) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	c.SceneIDs = ids
+	c.SceneCount = len(ids)
+}
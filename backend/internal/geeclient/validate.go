@@ -0,0 +1,259 @@
+// internal/geeclient/validate.go
+
+package geeclient
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"geowatch-backend/internal/geo"
+	"geowatch-backend/internal/pipeline"
+)
+
+// Sensor is a Landsat mission the analysis service builds composites from.
+type Sensor struct {
+	Name       string
+	Collection string
+	// FirstYear is the first year whose July composite uses this sensor.
+	FirstYear int
+}
+
+// Sensors lists the missions used by the analysis service, newest first, as
+// selected in gee-service/app.py.
+var Sensors = []Sensor{
+	{Name: "Landsat 9", Collection: "LANDSAT/LC09/C02/T1_L2", FirstYear: 2022},
+	{Name: "Landsat 8", Collection: "LANDSAT/LC08/C02/T1_L2", FirstYear: 2013},
+	{Name: "Landsat 7", Collection: "LANDSAT/LE07/C02/T1_L2", FirstYear: 1999},
+	{Name: "Landsat 5", Collection: "LANDSAT/LT05/C02/T1_L2", FirstYear: 1984},
+}
+
+// SensorForYear returns the sensor used for a year, or false if the year is
+// before the first Landsat Collection 2 imagery.
+func SensorForYear(year int) (Sensor, bool) {
+	for _, s := range Sensors {
+		if year >= s.FirstYear {
+			return s, true
+		}
+	}
+	return Sensor{}, false
+}
+
+// Policy holds the limits an analysis request must respect.
+type Policy struct {
+	// MaxAreaSqKm is the largest AOI accepted, by geodesic area.
+	MaxAreaSqKm float64
+	// MaxVertices bounds the total number of AOI positions.
+	MaxVertices int
+}
+
+// DefaultPolicy returns the limits the frontend enforces as well.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAreaSqKm: 100000,
+		MaxVertices: 2000,
+	}
+}
+
+// FieldError is a problem with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is returned by Validate when a request is invalid.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	parts := make([]string, len(v))
+	for i, e := range v {
+		parts[i] = e.Field + ": " + e.Message
+	}
+	return "invalid analysis request: " + strings.Join(parts, "; ")
+}
+
+func (v *ValidationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the request against the policy before it is sent to the
+// analysis service. now decides which years have imagery. It returns
+// ValidationErrors listing every problem found, or nil.
+func (r AnalysisRequest) Validate(policy Policy, now time.Time) error {
+	var errs ValidationErrors
+	validateAOI(r.AOI, policy, &errs)
+
+	start, okStart := validateDate("startDate", r.StartDate, now, &errs)
+	end, okEnd := validateDate("endDate", r.EndDate, now, &errs)
+	if okStart && okEnd {
+		if !start.Before(end) {
+			errs.add("endDate", "must be after startDate")
+		} else if start.Year() == end.Year() {
+			// The service compares yearly July composites.
+			errs.add("endDate", "must be in a later year than startDate; analyses compare yearly composites")
+		}
+	}
+
+	switch r.Format {
+	case "", "png", "geotiff":
+	default:
+		errs.add("format", "must be 'png' or 'geotiff'")
+	}
+	if r.HasPipeline() {
+		if _, err := pipeline.Parse(r.Pipeline); err != nil {
+			errs.add("pipeline", "%v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateAOI checks that the AOI is a valid polygon: closed rings of at
+// least four valid positions that don't cross themselves, and an area within
+// the policy.
+func validateAOI(aoi [][][]float64, policy Policy, errs *ValidationErrors) {
+	if len(aoi) == 0 {
+		errs.add("aoi", "is required: a GeoJSON Polygon coordinate array")
+		return
+	}
+
+	vertices := 0
+	for _, ring := range aoi {
+		vertices += len(ring)
+	}
+	if policy.MaxVertices > 0 && vertices > policy.MaxVertices {
+		errs.add("aoi", "has %d positions; at most %d are allowed", vertices, policy.MaxVertices)
+		return
+	}
+
+	polygon := make(geo.Polygon, 0, len(aoi))
+	valid := true
+	for i, ring := range aoi {
+		field := fmt.Sprintf("aoi[%d]", i)
+		if len(ring) < 4 {
+			errs.add(field, "must have at least 4 positions, got %d", len(ring))
+			valid = false
+			continue
+		}
+		r := make(geo.Ring, 0, len(ring))
+		ringValid := true
+		for j, pos := range ring {
+			posField := fmt.Sprintf("%s[%d]", field, j)
+			if len(pos) < 2 || len(pos) > 3 {
+				errs.add(posField, "must be [longitude, latitude]")
+				ringValid = false
+				continue
+			}
+			lon, lat := pos[0], pos[1]
+			switch {
+			case math.IsNaN(lon) || math.IsInf(lon, 0) || lon < -180 || lon > 180:
+				errs.add(posField, "longitude %g is outside [-180, 180]", lon)
+				ringValid = false
+			case math.IsNaN(lat) || math.IsInf(lat, 0) || lat < -90 || lat > 90:
+				errs.add(posField, "latitude %g is outside [-90, 90]", lat)
+				ringValid = false
+			}
+			r = append(r, geo.Point{lon, lat})
+		}
+		if !ringValid {
+			valid = false
+			continue
+		}
+		if r[0] != r[len(r)-1] {
+			errs.add(field, "is not closed: the first and last positions must be equal")
+			valid = false
+			continue
+		}
+		if selfIntersects(r) {
+			errs.add(field, "crosses itself")
+			valid = false
+			continue
+		}
+		if math.Abs(geo.RingArea(r)) == 0 {
+			errs.add(field, "has no area")
+			valid = false
+			continue
+		}
+		polygon = append(polygon, r)
+	}
+	if !valid {
+		return
+	}
+
+	areaSqKm := polygon.Area() / 1e6
+	if policy.MaxAreaSqKm > 0 && areaSqKm > policy.MaxAreaSqKm {
+		errs.add("aoi", "covers %.0f km²; at most %.0f km² can be analysed at once", areaSqKm, policy.MaxAreaSqKm)
+	}
+}
+
+// validateDate parses a YYYY-MM-DD date and checks that imagery exists for
+// its year: a Landsat mission must cover it and, since composites use July
+// imagery, the year must be over.
+func validateDate(field, value string, now time.Time, errs *ValidationErrors) (time.Time, bool) {
+	if value == "" {
+		errs.add(field, "is required (YYYY-MM-DD)")
+		return time.Time{}, false
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		errs.add(field, "%q is not a YYYY-MM-DD date", value)
+		return time.Time{}, false
+	}
+	if _, ok := SensorForYear(t.Year()); !ok {
+		oldest := Sensors[len(Sensors)-1]
+		errs.add(field, "no imagery before %d (%s)", oldest.FirstYear, oldest.Name)
+		return t, false
+	}
+	if t.Year() >= now.Year() {
+		errs.add(field, "imagery for %d is not available yet; the latest year is %d", t.Year(), now.Year()-1)
+		return t, false
+	}
+	return t, true
+}
+
+// selfIntersects reports whether two non-adjacent edges of a closed ring
+// cross or touch.
+func selfIntersects(r geo.Ring) bool {
+	n := len(r) - 1 // number of edges
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			// Adjacent edges share an endpoint, as do the first and last.
+			if j == i+1 || (i == 0 && j == n-1) {
+				continue
+			}
+			if segmentsIntersect(r[i], r[i+1], r[j], r[j+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func segmentsIntersect(a, b, c, d geo.Point) bool {
+	d1 := orientation(c, d, a)
+	d2 := orientation(c, d, b)
+	d3 := orientation(a, b, c)
+	d4 := orientation(a, b, d)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && onSegment(c, d, a)) ||
+		(d2 == 0 && onSegment(c, d, b)) ||
+		(d3 == 0 && onSegment(a, b, c)) ||
+		(d4 == 0 && onSegment(a, b, d))
+}
+
+// orientation is the cross product of (b-a) and (c-a).
+func orientation(a, b, c geo.Point) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// onSegment reports whether p, known to be collinear with a-b, lies on it.
+func onSegment(a, b, p geo.Point) bool {
+	return math.Min(a[0], b[0]) <= p[0] && p[0] <= math.Max(a[0], b[0]) &&
+		math.Min(a[1], b[1]) <= p[1] && p[1] <= math.Max(a[1], b[1])
+}
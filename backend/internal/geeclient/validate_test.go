@@ -0,0 +1,131 @@
+// internal/geeclient/validate_test.go
+
+package geeclient
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// square returns a closed counter-clockwise ring of the given size in degrees.
+func square(lon, lat, size float64) [][]float64 {
+	return [][]float64{{lon, lat}, {lon + size, lat}, {lon + size, lat + size}, {lon, lat + size}, {lon, lat}}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	valid := AnalysisRequest{
+		AOI:       [][][]float64{square(36.8, -1.3, 0.1)},
+		StartDate: "2020-07-01",
+		EndDate:   "2024-07-01",
+	}
+	tests := []struct {
+		name   string
+		modify func(r *AnalysisRequest)
+		// fields lists the fields that must be reported, none for a valid request.
+		fields []string
+	}{
+		{"valid", func(r *AnalysisRequest) {}, nil},
+		{"valid with a hole", func(r *AnalysisRequest) {
+			hole := square(36.82, -1.28, 0.02)
+			for i, j := 0, len(hole)-1; i < j; i, j = i+1, j-1 {
+				hole[i], hole[j] = hole[j], hole[i]
+			}
+			r.AOI = append(r.AOI, hole)
+		}, nil},
+		{"geotiff format", func(r *AnalysisRequest) { r.Format = "geotiff" }, nil},
+		{"missing AOI", func(r *AnalysisRequest) { r.AOI = nil }, []string{"aoi"}},
+		{"too few positions", func(r *AnalysisRequest) { r.AOI[0] = r.AOI[0][:3] }, []string{"aoi[0]"}},
+		{"not closed", func(r *AnalysisRequest) {
+			r.AOI[0] = [][]float64{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0.5}}
+		}, []string{"aoi[0]"}},
+		{"self-intersecting", func(r *AnalysisRequest) {
+			r.AOI[0] = [][]float64{{0, 0}, {1, 1}, {1, 0}, {0, 1}, {0, 0}}
+		}, []string{"aoi[0]"}},
+		{"no area", func(r *AnalysisRequest) {
+			r.AOI[0] = [][]float64{{0, 0}, {1, 0}, {2, 0}, {0, 0}}
+		}, []string{"aoi[0]"}},
+		{"longitude out of range", func(r *AnalysisRequest) { r.AOI[0][1] = []float64{181, -1.3} }, []string{"aoi[0][1]"}},
+		{"latitude is NaN", func(r *AnalysisRequest) { r.AOI[0][2] = []float64{36.9, math.NaN()} }, []string{"aoi[0][2]"}},
+		{"position with one value", func(r *AnalysisRequest) { r.AOI[0][1] = []float64{36.9} }, []string{"aoi[0][1]"}},
+		{"too large", func(r *AnalysisRequest) { r.AOI[0] = square(20, 0, 5) }, []string{"aoi"}},
+		{"missing dates", func(r *AnalysisRequest) { r.StartDate, r.EndDate = "", "" }, []string{"startDate", "endDate"}},
+		{"malformed date", func(r *AnalysisRequest) { r.StartDate = "2020/07/01" }, []string{"startDate"}},
+		{"before Landsat", func(r *AnalysisRequest) { r.StartDate = "1980-07-01" }, []string{"startDate"}},
+		{"current year", func(r *AnalysisRequest) { r.EndDate = "2025-01-15" }, []string{"endDate"}},
+		{"same year", func(r *AnalysisRequest) { r.StartDate, r.EndDate = "2020-01-01", "2020-12-01" }, []string{"endDate"}},
+		{"end before start", func(r *AnalysisRequest) { r.StartDate, r.EndDate = "2022-07-01", "2020-07-01" }, []string{"endDate"}},
+		{"unknown format", func(r *AnalysisRequest) { r.Format = "jpeg" }, []string{"format"}},
+		{"pipeline", func(r *AnalysisRequest) { r.Pipeline = []byte(`{"steps": [{"name": "crop"}]}`) }, nil},
+		{"null pipeline", func(r *AnalysisRequest) { r.Pipeline = []byte(`null`) }, nil},
+		{"unknown pipeline step", func(r *AnalysisRequest) { r.Pipeline = []byte(`{"steps": [{"name": "sharpen"}]}`) }, []string{"pipeline"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := valid
+			request.AOI = [][][]float64{square(36.8, -1.3, 0.1)}
+			tt.modify(&request)
+
+			err := request.Validate(DefaultPolicy(), now)
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			var fieldErrs ValidationErrors
+			if !errors.As(err, &fieldErrs) {
+				t.Fatalf("Validate() = %v, want ValidationErrors", err)
+			}
+			for _, field := range tt.fields {
+				found := false
+				for _, e := range fieldErrs {
+					found = found || e.Field == field
+				}
+				if !found {
+					t.Errorf("Validate() = %v, want an error for %s", err, field)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateVertexLimit(t *testing.T) {
+	ring := make([][]float64, 0, 11)
+	for i := 0; i < 10; i++ {
+		angle := 2 * math.Pi * float64(i) / 10
+		ring = append(ring, []float64{math.Cos(angle) / 100, math.Sin(angle) / 100})
+	}
+	ring = append(ring, ring[0])
+	request := AnalysisRequest{AOI: [][][]float64{ring}, StartDate: "2020-07-01", EndDate: "2024-07-01"}
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := request.Validate(Policy{MaxVertices: 11}, now); err != nil {
+		t.Errorf("Validate() with 11 of 11 positions = %v, want no error", err)
+	}
+	if err := request.Validate(Policy{MaxVertices: 10}, now); err == nil {
+		t.Error("Validate() with 11 of 10 positions succeeded, want an error")
+	}
+}
+
+func TestSensorForYear(t *testing.T) {
+	tests := []struct {
+		year int
+		want string
+		ok   bool
+	}{
+		{1983, "", false},
+		{1984, "Landsat 5", true},
+		{2005, "Landsat 7", true},
+		{2013, "Landsat 8", true},
+		{2024, "Landsat 9", true},
+	}
+	for _, tt := range tests {
+		sensor, ok := SensorForYear(tt.year)
+		if ok != tt.ok || sensor.Name != tt.want {
+			t.Errorf("SensorForYear(%d) = %q, %v; want %q, %v", tt.year, sensor.Name, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// internal/geo/geo.go
+
+// Package geo holds the small amount of vector geometry the backend needs:
+// WGS84 polygons, their GeoJSON encoding and geodesic area.
+package geo
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// EarthRadius is the WGS84 equatorial radius in metres, as used by Turf.js and
+// most web mapping libraries for spherical area.
+const EarthRadius = 6378137.0
+
+// Point is a longitude/latitude pair in degrees.
+type Point [2]float64
+
+// Ring is a closed sequence of points; the first and last points are equal.
+type Ring []Point
+
+// Polygon is an exterior ring followed by zero or more holes. Following the
+// GeoJSON right-hand rule, the exterior is counter-clockwise and holes clockwise.
+type Polygon []Ring
+
+// geoJSONPolygon is the GeoJSON encoding of a Polygon.
+type geoJSONPolygon struct {
+	Type        string    `json:"type"`
+	Coordinates [][]Point `json:"coordinates"`
+}
+
+// MarshalGeoJSON encodes the polygon as a GeoJSON Polygon geometry.
+func (p Polygon) MarshalGeoJSON() ([]byte, error) {
+	coords := make([][]Point, len(p))
+	for i, r := range p {
+		coords[i] = r
+	}
+	return json.Marshal(geoJSONPolygon{Type: "Polygon", Coordinates: coords})
+}
+
+// BBox returns minLon, minLat, maxLon, maxLat of the exterior ring.
+func (p Polygon) BBox() []float64 {
+	if len(p) == 0 || len(p[0]) == 0 {
+		return nil
+	}
+	bbox := []float64{p[0][0][0], p[0][0][1], p[0][0][0], p[0][0][1]}
+	for _, pt := range p[0] {
+		bbox[0] = math.Min(bbox[0], pt[0])
+		bbox[1] = math.Min(bbox[1], pt[1])
+		bbox[2] = math.Max(bbox[2], pt[0])
+		bbox[3] = math.Max(bbox[3], pt[1])
+	}
+	return bbox
+}
+
+// Area returns the geodesic area of the polygon in square metres: the
+// exterior area minus the area of its holes.
+func (p Polygon) Area() float64 {
+	if len(p) == 0 {
+		return 0
+	}
+	area := math.Abs(RingArea(p[0]))
+	for _, hole := range p[1:] {
+		area -= math.Abs(RingArea(hole))
+	}
+	return math.Max(area, 0)
+}
+
+// Contains reports whether pt lies inside the exterior ring and outside all
+// holes.
+func (p Polygon) Contains(pt Point) bool {
+	if len(p) == 0 || !p[0].Contains(pt) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if hole.Contains(pt) {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains reports whether pt lies inside the ring, using the even-odd ray
+// casting test.
+func (r Ring) Contains(pt Point) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		a, b := r[i], r[j]
+		if (a[1] > pt[1]) != (b[1] > pt[1]) && pt[0] < (b[0]-a[0])*(pt[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// RingArea returns the signed spherical area of a ring in square metres,
+// positive for counter-clockwise rings. It uses the method from
+// Chamberlain & Duquette, "Some Algorithms for Polygons on a Sphere" (2007).
+func RingArea(r Ring) float64 {
+	n := len(r)
+	if n < 3 {
+		return 0
+	}
+	// Ignore the closing point if it repeats the first one.
+	if r[0] == r[n-1] {
+		n--
+	}
+	if n < 3 {
+		return 0
+	}
+	var total float64
+	for i := 0; i < n; i++ {
+		lower := r[(i+n-1)%n]
+		upper := r[(i+1)%n]
+		total += (radians(upper[0]) - radians(lower[0])) * math.Sin(radians(r[i][1]))
+	}
+	return -total * EarthRadius * EarthRadius / 2
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
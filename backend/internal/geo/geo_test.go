@@ -0,0 +1,53 @@
+// internal/geo/geo_test.go
+
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPolygonContains(t *testing.T) {
+	// A 4x4 square with a 2x2 hole in the middle.
+	polygon := Polygon{
+		{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+		{{1, 1}, {1, 3}, {3, 3}, {3, 1}, {1, 1}},
+	}
+	tests := []struct {
+		name string
+		pt   Point
+		want bool
+	}{
+		{"inside", Point{0.5, 0.5}, true},
+		{"in the hole", Point{2, 2}, false},
+		{"between hole and exterior", Point{3.5, 2}, true},
+		{"outside", Point{5, 2}, false},
+		{"left of the polygon", Point{-1, 2}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := polygon.Contains(tt.pt); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.pt, got, tt.want)
+			}
+		})
+	}
+	if (Polygon{}).Contains(Point{0, 0}) {
+		t.Error("an empty polygon contains a point")
+	}
+}
+
+func TestArea(t *testing.T) {
+	// One degree square at the equator is about 12,391 km² on the WGS84 equatorial sphere.
+	square := Ring{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}
+	if area := (Polygon{square}).Area() / 1e6; math.Abs(area-12391) > 5 {
+		t.Errorf("Area() = %.0f km², want about 12391", area)
+	}
+	if RingArea(square) <= 0 || RingArea(square.Reversed()) >= 0 {
+		t.Error("RingArea() should be positive for counter-clockwise rings and negative for clockwise ones")
+	}
+	hole := Ring{{0.25, 0.25}, {0.25, 0.75}, {0.75, 0.75}, {0.75, 0.25}, {0.25, 0.25}}
+	whole, withHole := (Polygon{square}).Area(), (Polygon{square, hole}).Area()
+	if math.Abs(withHole/whole-0.75) > 0.001 {
+		t.Errorf("a quarter-sized hole leaves %.3f of the area, want 0.75", withHole/whole)
+	}
+}
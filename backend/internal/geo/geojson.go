@@ -0,0 +1,46 @@
+// internal/geo/geojson.go
+
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ParsePolygons decodes a GeoJSON Polygon or MultiPolygon geometry into its
+// polygons. Other geometry types are rejected.
+func ParsePolygons(data []byte) ([]Polygon, error) {
+	var geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if err := json.Unmarshal(data, &geometry); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON geometry: %w", err)
+	}
+
+	switch geometry.Type {
+	case "Polygon":
+		var p Polygon
+		if err := json.Unmarshal(geometry.Coordinates, &p); err != nil {
+			return nil, fmt.Errorf("invalid Polygon coordinates: %w", err)
+		}
+		return []Polygon{p}, nil
+	case "MultiPolygon":
+		var mp []Polygon
+		if err := json.Unmarshal(geometry.Coordinates, &mp); err != nil {
+			return nil, fmt.Errorf("invalid MultiPolygon coordinates: %w", err)
+		}
+		return mp, nil
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", geometry.Type)
+	}
+}
+
+// Reversed returns a copy of the ring with its orientation flipped.
+func (r Ring) Reversed() Ring {
+	out := make(Ring, len(r))
+	for i, pt := range r {
+		out[len(r)-1-i] = pt
+	}
+	return out
+}
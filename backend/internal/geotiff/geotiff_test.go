@@ -0,0 +1,113 @@
+// internal/geotiff/geotiff_test.go
+
+package geotiff
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	nan := math.NaN()
+	zero := 0.0
+	tests := []struct {
+		name          string
+		width, height int
+		bands         int
+		bbox          []float64
+		noData        *float64
+	}{
+		{"single band", 3, 2, 1, nil, nil},
+		{"georeferenced with NaN no-data", 4, 3, 2, []float64{36.5, -1.5, 37.5, -0.75}, &nan},
+		{"zero no-data", 1, 5, 3, []float64{-74, 40.7, -73.9, 40.8}, &zero},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &Image{Width: tt.width, Height: tt.height, NoData: tt.noData}
+			for b := 0; b < tt.bands; b++ {
+				band := make([]float32, tt.width*tt.height)
+				for i := range band {
+					band[i] = float32(b*1000+i) / 7
+				}
+				img.Bands = append(img.Bands, band)
+			}
+			img.Bands[0][0] = float32(math.NaN())
+			if tt.bbox != nil {
+				if err := img.SetBBox(tt.bbox); err != nil {
+					t.Fatalf("SetBBox() failed: %v", err)
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := Write(&buf, img); err != nil {
+				t.Fatalf("Write() failed: %v", err)
+			}
+			got, err := Read(&buf)
+			if err != nil {
+				t.Fatalf("Read() failed: %v", err)
+			}
+
+			if got.Width != img.Width || got.Height != img.Height || len(got.Bands) != len(img.Bands) {
+				t.Fatalf("read %dx%d with %d bands, want %dx%d with %d", got.Width, got.Height, len(got.Bands),
+					img.Width, img.Height, len(img.Bands))
+			}
+			for b := range img.Bands {
+				for i, want := range img.Bands[b] {
+					v := got.Bands[b][i]
+					if v != want && !(math.IsNaN(float64(v)) && math.IsNaN(float64(want))) {
+						t.Fatalf("band %d sample %d = %v, want %v", b, i, v, want)
+					}
+				}
+			}
+
+			gotBBox := got.BBox()
+			if tt.bbox == nil {
+				if gotBBox != nil {
+					t.Errorf("BBox() = %v, want nil for an image without georeferencing", gotBBox)
+				}
+			} else {
+				for i := range tt.bbox {
+					if math.Abs(gotBBox[i]-tt.bbox[i]) > 1e-9 {
+						t.Fatalf("BBox() = %v, want %v", gotBBox, tt.bbox)
+					}
+				}
+			}
+
+			switch {
+			case tt.noData == nil && got.NoData != nil:
+				t.Errorf("NoData = %v, want none", *got.NoData)
+			case tt.noData != nil && got.NoData == nil:
+				t.Errorf("NoData is missing, want %v", *tt.noData)
+			case tt.noData != nil && !(*got.NoData == *tt.noData || math.IsNaN(*got.NoData) && math.IsNaN(*tt.noData)):
+				t.Errorf("NoData = %v, want %v", *got.NoData, *tt.noData)
+			}
+		})
+	}
+}
+
+func TestEncodeRejectsInvalidImages(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *Image
+	}{
+		{"no pixels", &Image{Bands: [][]float32{{}}}},
+		{"no bands", &Image{Width: 1, Height: 1}},
+		{"short band", &Image{Width: 2, Height: 2, Bands: [][]float32{{1, 2, 3}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Encode(tt.img); err == nil {
+				t.Error("Encode() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsNonTIFF(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("PK\x03\x04 not a tiff")} {
+		if _, err := Decode(data); err == nil {
+			t.Errorf("Decode(%q) succeeded, want an error", data)
+		}
+	}
+}
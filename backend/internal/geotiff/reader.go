@@ -0,0 +1,368 @@
+// internal/geotiff/reader.go
+
+// Package geotiff reads multi-band TIFF rasters, such as the FLOAT32 output of
+// the Sentinel Hub process API, into plain float32 slices, and writes float32
+// rasters back out as GeoTIFFs georeferenced in EPSG:4326.
+//
+// Only the subset of TIFF that imagery services actually produce is supported:
+// stripped or tiled layouts, chunky or planar bands, no compression or DEFLATE,
+// and 8/16/32-bit unsigned integer or 32/64-bit float samples.
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// TIFF tag IDs used by the reader and writer.
+const (
+	tagImageWidth                = 256
+	tagImageLength               = 257
+	tagBitsPerSample             = 258
+	tagCompression               = 259
+	tagPhotometricInterpretation = 262
+	tagStripOffsets              = 273
+	tagSamplesPerPixel           = 277
+	tagRowsPerStrip              = 278
+	tagStripByteCounts           = 279
+	tagPlanarConfiguration       = 284
+	tagPredictor                 = 317
+	tagTileWidth                 = 322
+	tagTileLength                = 323
+	tagTileOffsets               = 324
+	tagTileByteCounts            = 325
+	tagExtraSamples              = 338
+	tagSampleFormat              = 339
+	tagModelPixelScale           = 33550
+	tagModelTiepoint             = 33922
+	tagGeoKeyDirectory           = 34735
+	tagGDALNoData                = 42113
+)
+
+// TIFF field types.
+const (
+	typeByte   = 1
+	typeASCII  = 2
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+)
+
+const (
+	compressionNone        = 1
+	compressionDeflate     = 8
+	compressionDeflateOld  = 32946
+	sampleFormatUint       = 1
+	sampleFormatFloat      = 3
+	planarConfigChunky     = 1
+	planarConfigSeparate   = 2
+	predictorNone          = 1
+	defaultRowsPerStripAll = math.MaxUint32
+)
+
+// Image is a decoded raster with one float32 slice per band, stored row by row.
+type Image struct {
+	Width  int
+	Height int
+	// Bands holds Width*Height samples per band.
+	Bands [][]float32
+	// PixelScale and Tiepoint are the GeoTIFF model tags, when present.
+	PixelScale []float64
+	Tiepoint   []float64
+	// NoData is the GDAL no-data value, when present.
+	NoData *float64
+}
+
+// At returns the sample of band b at pixel (x, y).
+func (img *Image) At(b, x, y int) float32 {
+	return img.Bands[b][y*img.Width+x]
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	// raw is the value bytes, either inline or read from the value offset.
+	raw []byte
+}
+
+// Read decodes a TIFF from r.
+func Read(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiff data: %w", err)
+	}
+	return Decode(data)
+}
+
+// Decode decodes a TIFF held in memory.
+func Decode(data []byte) (*Image, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("tiff data too short")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a tiff file")
+	}
+	if order.Uint16(data[2:4]) != 42 {
+		if order.Uint16(data[2:4]) == 43 {
+			return nil, fmt.Errorf("BigTIFF is not supported")
+		}
+		return nil, fmt.Errorf("not a tiff file")
+	}
+
+	entries, err := readIFD(data, order, order.Uint32(data[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{data: data, order: order, entries: entries}
+	return d.decode()
+}
+
+func readIFD(data []byte, order binary.ByteOrder, offset uint32) (map[uint16]ifdEntry, error) {
+	if int(offset)+2 > len(data) {
+		return nil, fmt.Errorf("invalid IFD offset")
+	}
+	n := int(order.Uint16(data[offset:]))
+	entries := make(map[uint16]ifdEntry, n)
+	for i := 0; i < n; i++ {
+		pos := int(offset) + 2 + i*12
+		if pos+12 > len(data) {
+			return nil, fmt.Errorf("truncated IFD")
+		}
+		tag := order.Uint16(data[pos:])
+		typ := order.Uint16(data[pos+2:])
+		count := order.Uint32(data[pos+4:])
+		size := typeSize(typ) * int(count)
+		var raw []byte
+		if size <= 4 {
+			raw = data[pos+8 : pos+8+size]
+		} else {
+			valueOffset := int(order.Uint32(data[pos+8:]))
+			if valueOffset+size > len(data) {
+				return nil, fmt.Errorf("tag %d points outside the file", tag)
+			}
+			raw = data[valueOffset : valueOffset+size]
+		}
+		entries[tag] = ifdEntry{typ: typ, count: count, raw: raw}
+	}
+	return entries, nil
+}
+
+func typeSize(typ uint16) int {
+	switch typ {
+	case typeByte, typeASCII, 6, 7:
+		return 1
+	case typeShort, 8:
+		return 2
+	case typeLong, 9, 11:
+		return 4
+	case 5, 10, typeDouble:
+		return 8
+	default:
+		return 0
+	}
+}
+
+type decoder struct {
+	data    []byte
+	order   binary.ByteOrder
+	entries map[uint16]ifdEntry
+}
+
+// uints returns the integer values of a tag.
+func (d *decoder) uints(tag uint16) []uint32 {
+	e, ok := d.entries[tag]
+	if !ok {
+		return nil
+	}
+	values := make([]uint32, e.count)
+	for i := range values {
+		switch e.typ {
+		case typeByte:
+			values[i] = uint32(e.raw[i])
+		case typeShort:
+			values[i] = uint32(d.order.Uint16(e.raw[i*2:]))
+		case typeLong:
+			values[i] = d.order.Uint32(e.raw[i*4:])
+		}
+	}
+	return values
+}
+
+// uint returns the first value of a tag, or def when it is absent.
+func (d *decoder) uint(tag uint16, def uint32) uint32 {
+	if v := d.uints(tag); len(v) > 0 {
+		return v[0]
+	}
+	return def
+}
+
+func (d *decoder) doubles(tag uint16) []float64 {
+	e, ok := d.entries[tag]
+	if !ok || e.typ != typeDouble {
+		return nil
+	}
+	values := make([]float64, e.count)
+	for i := range values {
+		values[i] = math.Float64frombits(d.order.Uint64(e.raw[i*8:]))
+	}
+	return values
+}
+
+func (d *decoder) decode() (*Image, error) {
+	width := int(d.uint(tagImageWidth, 0))
+	height := int(d.uint(tagImageLength, 0))
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("tiff is missing image dimensions")
+	}
+	samples := int(d.uint(tagSamplesPerPixel, 1))
+	bits := int(d.uint(tagBitsPerSample, 1))
+	for _, b := range d.uints(tagBitsPerSample) {
+		if int(b) != bits {
+			return nil, fmt.Errorf("mixed bits per sample are not supported")
+		}
+	}
+	format := int(d.uint(tagSampleFormat, sampleFormatUint))
+	switch {
+	case format == sampleFormatFloat && (bits == 32 || bits == 64):
+	case format == sampleFormatUint && (bits == 8 || bits == 16 || bits == 32):
+	default:
+		return nil, fmt.Errorf("unsupported sample format %d with %d bits", format, bits)
+	}
+	compression := d.uint(tagCompression, compressionNone)
+	if compression != compressionNone && compression != compressionDeflate && compression != compressionDeflateOld {
+		return nil, fmt.Errorf("unsupported tiff compression %d", compression)
+	}
+	if p := d.uint(tagPredictor, predictorNone); p != predictorNone {
+		return nil, fmt.Errorf("unsupported tiff predictor %d", p)
+	}
+	planar := d.uint(tagPlanarConfiguration, planarConfigChunky)
+
+	img := &Image{
+		Width:      width,
+		Height:     height,
+		Bands:      make([][]float32, samples),
+		PixelScale: d.doubles(tagModelPixelScale),
+		Tiepoint:   d.doubles(tagModelTiepoint),
+	}
+	for b := range img.Bands {
+		img.Bands[b] = make([]float32, width*height)
+	}
+	if e, ok := d.entries[tagGDALNoData]; ok {
+		var v float64
+		if _, err := fmt.Sscanf(string(bytes.TrimRight(e.raw, "\x00")), "%g", &v); err == nil {
+			img.NoData = &v
+		}
+	}
+
+	// Describe the chunk grid: strips are tiles that span the full width.
+	chunkW, chunkH := width, int(d.uint(tagRowsPerStrip, defaultRowsPerStripAll))
+	offsets, counts := d.uints(tagStripOffsets), d.uints(tagStripByteCounts)
+	if _, tiled := d.entries[tagTileWidth]; tiled {
+		chunkW, chunkH = int(d.uint(tagTileWidth, 0)), int(d.uint(tagTileLength, 0))
+		offsets, counts = d.uints(tagTileOffsets), d.uints(tagTileByteCounts)
+	}
+	if chunkH > height {
+		chunkH = height
+	}
+	if chunkW <= 0 || chunkH <= 0 || len(offsets) == 0 || len(offsets) != len(counts) {
+		return nil, fmt.Errorf("tiff has an invalid strip or tile layout")
+	}
+	across := (width + chunkW - 1) / chunkW
+	down := (height + chunkH - 1) / chunkH
+	planes := 1
+	samplesPerChunk := samples
+	if planar == planarConfigSeparate {
+		planes = samples
+		samplesPerChunk = 1
+	}
+	if len(offsets) < across*down*planes {
+		return nil, fmt.Errorf("tiff has %d chunks, expected %d", len(offsets), across*down*planes)
+	}
+
+	bytesPerSample := bits / 8
+	for plane := 0; plane < planes; plane++ {
+		for cy := 0; cy < down; cy++ {
+			for cx := 0; cx < across; cx++ {
+				i := plane*across*down + cy*across + cx
+				start, size := int(offsets[i]), int(counts[i])
+				if start+size > len(d.data) {
+					return nil, fmt.Errorf("tiff chunk %d points outside the file", i)
+				}
+				chunk := d.data[start : start+size]
+				if compression != compressionNone {
+					inflated, err := inflate(chunk)
+					if err != nil {
+						return nil, fmt.Errorf("failed to inflate tiff chunk %d: %w", i, err)
+					}
+					chunk = inflated
+				}
+				// Strips at the bottom may be shorter, tiles are always padded.
+				rows := chunkH
+				if chunkW == width && (cy+1)*chunkH > height {
+					rows = height - cy*chunkH
+				}
+				if len(chunk) < chunkW*rows*samplesPerChunk*bytesPerSample {
+					return nil, fmt.Errorf("tiff chunk %d is truncated", i)
+				}
+				for ry := 0; ry < rows; ry++ {
+					y := cy*chunkH + ry
+					if y >= height {
+						break
+					}
+					for rx := 0; rx < chunkW; rx++ {
+						x := cx*chunkW + rx
+						if x >= width {
+							break
+						}
+						for s := 0; s < samplesPerChunk; s++ {
+							pos := ((ry*chunkW+rx)*samplesPerChunk + s) * bytesPerSample
+							band := s
+							if planes > 1 {
+								band = plane
+							}
+							img.Bands[band][y*width+x] = d.sample(chunk[pos:], format, bits)
+						}
+					}
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+func (d *decoder) sample(b []byte, format, bits int) float32 {
+	if format == sampleFormatFloat {
+		if bits == 64 {
+			return float32(math.Float64frombits(d.order.Uint64(b)))
+		}
+		return math.Float32frombits(d.order.Uint32(b))
+	}
+	switch bits {
+	case 8:
+		return float32(b[0])
+	case 16:
+		return float32(d.order.Uint16(b))
+	default:
+		return float32(d.order.Uint32(b))
+	}
+}
+
+func inflate(chunk []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(chunk))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
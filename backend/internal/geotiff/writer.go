@@ -0,0 +1,268 @@
+// internal/geotiff/writer.go
+
+package geotiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// GeoKey IDs and values written to describe an EPSG:4326 raster.
+const (
+	geoKeyModelType        = 1024
+	geoKeyRasterType       = 1025
+	geoKeyGeographicType   = 2048
+	geoKeyGeogAngularUnits = 2054
+
+	modelTypeGeographic = 2
+	rasterPixelIsArea   = 1
+	epsgWGS84           = 4326
+	angularUnitDegree   = 9102
+
+	photometricBlackIsZero = 1
+	extraSampleUnspecified = 0
+)
+
+// SetBBox georeferences the image so that it exactly covers bbox
+// ([minLon, minLat, maxLon, maxLat] in EPSG:4326), with the first row at the
+// northern edge.
+func (img *Image) SetBBox(bbox []float64) error {
+	if len(bbox) != 4 || bbox[2] <= bbox[0] || bbox[3] <= bbox[1] {
+		return fmt.Errorf("invalid bbox %v", bbox)
+	}
+	if img.Width <= 0 || img.Height <= 0 {
+		return fmt.Errorf("image has no pixels")
+	}
+	img.PixelScale = []float64{
+		(bbox[2] - bbox[0]) / float64(img.Width),
+		(bbox[3] - bbox[1]) / float64(img.Height),
+		0,
+	}
+	img.Tiepoint = []float64{0, 0, 0, bbox[0], bbox[3], 0}
+	return nil
+}
+
+// BBox returns the area covered by a georeferenced image, or nil if it has no
+// pixel scale and tiepoint.
+func (img *Image) BBox() []float64 {
+	if len(img.PixelScale) < 2 || len(img.Tiepoint) < 6 {
+		return nil
+	}
+	minLon := img.Tiepoint[3] - img.Tiepoint[0]*img.PixelScale[0]
+	maxLat := img.Tiepoint[4] + img.Tiepoint[1]*img.PixelScale[1]
+	return []float64{
+		minLon,
+		maxLat - float64(img.Height)*img.PixelScale[1],
+		minLon + float64(img.Width)*img.PixelScale[0],
+		maxLat,
+	}
+}
+
+// Write encodes img as an uncompressed little-endian float32 GeoTIFF with
+// interleaved bands. Georeferencing and the no-data value are written when
+// set on the image; the CRS is always EPSG:4326.
+func Write(w io.Writer, img *Image) error {
+	data, err := Encode(img)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Encode is like Write but returns the encoded file.
+func Encode(img *Image) ([]byte, error) {
+	if img.Width <= 0 || img.Height <= 0 {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+	if len(img.Bands) == 0 {
+		return nil, fmt.Errorf("image has no bands")
+	}
+	for b, band := range img.Bands {
+		if len(band) != img.Width*img.Height {
+			return nil, fmt.Errorf("band %d has %d samples, want %d", b, len(band), img.Width*img.Height)
+		}
+	}
+
+	order := binary.LittleEndian
+	bands := len(img.Bands)
+	rowBytes := img.Width * bands * 4
+
+	e := encoder{order: order}
+	e.longs(tagImageLength, uint32(img.Height))
+	e.longs(tagImageWidth, uint32(img.Width))
+	e.shorts(tagBitsPerSample, repeat(32, bands)...)
+	e.shorts(tagCompression, compressionNone)
+	e.shorts(tagPhotometricInterpretation, photometricBlackIsZero)
+	e.shorts(tagSamplesPerPixel, uint16(bands))
+	e.longs(tagRowsPerStrip, 1)
+	e.shorts(tagPlanarConfiguration, planarConfigChunky)
+	if bands > 1 {
+		e.shorts(tagExtraSamples, repeat(extraSampleUnspecified, bands-1)...)
+	}
+	e.shorts(tagSampleFormat, repeat(sampleFormatFloat, bands)...)
+	if len(img.PixelScale) > 0 {
+		e.doubles(tagModelPixelScale, img.PixelScale...)
+	}
+	if len(img.Tiepoint) > 0 {
+		e.doubles(tagModelTiepoint, img.Tiepoint...)
+	}
+	e.shorts(tagGeoKeyDirectory,
+		1, 1, 0, 4,
+		geoKeyModelType, 0, 1, modelTypeGeographic,
+		geoKeyRasterType, 0, 1, rasterPixelIsArea,
+		geoKeyGeographicType, 0, 1, epsgWGS84,
+		geoKeyGeogAngularUnits, 0, 1, angularUnitDegree,
+	)
+	if img.NoData != nil {
+		e.ascii(tagGDALNoData, formatNoData(*img.NoData))
+	}
+
+	// One strip per row. The offsets are known once the IFD size is.
+	offsets := make([]uint32, img.Height)
+	counts := repeat32(uint32(rowBytes), img.Height)
+	e.longs(tagStripOffsets, offsets...)
+	e.longs(tagStripByteCounts, counts...)
+
+	ifdSize := 2 + 12*len(e.entries) + 4
+	valuesSize := e.externalSize()
+	pixelStart := 8 + ifdSize + valuesSize
+	if int64(pixelStart)+int64(rowBytes)*int64(img.Height) > math.MaxUint32 {
+		return nil, fmt.Errorf("image is too large for a classic TIFF")
+	}
+	for y := range offsets {
+		offsets[y] = uint32(pixelStart + y*rowBytes)
+	}
+	e.longs(tagStripOffsets, offsets...)
+
+	var buf bytes.Buffer
+	buf.Grow(pixelStart + rowBytes*img.Height)
+	buf.WriteString("II")
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+	e.writeIFD(&buf, 8+ifdSize)
+
+	sample := make([]byte, 4)
+	for i := 0; i < img.Width*img.Height; i++ {
+		for _, band := range img.Bands {
+			order.PutUint32(sample, math.Float32bits(band[i]))
+			buf.Write(sample)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// encoder collects IFD entries before they are laid out.
+type encoder struct {
+	order   binary.ByteOrder
+	entries map[uint16]ifdEntry
+}
+
+func (e *encoder) set(tag, typ uint16, count int, raw []byte) {
+	if e.entries == nil {
+		e.entries = map[uint16]ifdEntry{}
+	}
+	e.entries[tag] = ifdEntry{typ: typ, count: uint32(count), raw: raw}
+}
+
+func (e *encoder) shorts(tag uint16, values ...uint16) {
+	raw := make([]byte, 2*len(values))
+	for i, v := range values {
+		e.order.PutUint16(raw[2*i:], v)
+	}
+	e.set(tag, typeShort, len(values), raw)
+}
+
+func (e *encoder) longs(tag uint16, values ...uint32) {
+	raw := make([]byte, 4*len(values))
+	for i, v := range values {
+		e.order.PutUint32(raw[4*i:], v)
+	}
+	e.set(tag, typeLong, len(values), raw)
+}
+
+func (e *encoder) doubles(tag uint16, values ...float64) {
+	raw := make([]byte, 8*len(values))
+	for i, v := range values {
+		e.order.PutUint64(raw[8*i:], math.Float64bits(v))
+	}
+	e.set(tag, typeDouble, len(values), raw)
+}
+
+func (e *encoder) ascii(tag uint16, s string) {
+	raw := append([]byte(s), 0)
+	e.set(tag, typeASCII, len(raw), raw)
+}
+
+// externalSize is the number of bytes needed for values that don't fit in
+// their IFD entry. Each value is padded to an even offset.
+func (e *encoder) externalSize() int {
+	size := 0
+	for _, entry := range e.entries {
+		if len(entry.raw) > 4 {
+			size += len(entry.raw) + len(entry.raw)%2
+		}
+	}
+	return size
+}
+
+// writeIFD writes the entries sorted by tag, followed by the out-of-line
+// values starting at valuesOffset.
+func (e *encoder) writeIFD(buf *bytes.Buffer, valuesOffset int) {
+	tags := make([]int, 0, len(e.entries))
+	for tag := range e.entries {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	var values bytes.Buffer
+	binary.Write(buf, e.order, uint16(len(tags)))
+	for _, tag := range tags {
+		entry := e.entries[uint16(tag)]
+		binary.Write(buf, e.order, uint16(tag))
+		binary.Write(buf, e.order, entry.typ)
+		binary.Write(buf, e.order, entry.count)
+		if len(entry.raw) <= 4 {
+			inline := make([]byte, 4)
+			copy(inline, entry.raw)
+			buf.Write(inline)
+			continue
+		}
+		binary.Write(buf, e.order, uint32(valuesOffset+values.Len()))
+		values.Write(entry.raw)
+		if len(entry.raw)%2 == 1 {
+			values.WriteByte(0)
+		}
+	}
+	binary.Write(buf, e.order, uint32(0)) // no further IFDs
+	buf.Write(values.Bytes())
+}
+
+// formatNoData formats a no-data value the way GDAL expects it.
+func formatNoData(v float64) string {
+	if math.IsNaN(v) {
+		return "nan"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func repeat(v uint16, n int) []uint16 {
+	values := make([]uint16, n)
+	for i := range values {
+		values[i] = v
+	}
+	return values
+}
+
+func repeat32(v uint32, n int) []uint32 {
+	values := make([]uint32, n)
+	for i := range values {
+		values[i] = v
+	}
+	return values
+}
@@ -0,0 +1,234 @@
+// internal/jobs/manager.go
+
+// Package jobs runs long analyses in the background. Jobs are persisted in the
+// analysis_jobs table so clients can poll them and unfinished work is picked up
+// again after a restart.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"geowatch-backend/internal/storage"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrQueueFull is returned by Submit when every worker is busy and the queue
+// has no room left.
+var ErrQueueFull = errors.New("analysis job queue is full")
+
+// ProgressFunc lets a Runner report how far along it is (0-100).
+type ProgressFunc func(progress int, message string)
+
+// Result is what a Runner produces for a finished job.
+type Result struct {
+	Data        []byte
+	ContentType string
+}
+
+// Runner does the actual work of a job.
+type Runner func(ctx context.Context, job *storage.Job, report ProgressFunc) (*Result, error)
+
+// Config controls the worker pool.
+type Config struct {
+	// Workers is the number of jobs run concurrently.
+	Workers int
+	// QueueSize is how many jobs may wait for a free worker.
+	QueueSize int
+	// ResultsDir is where job results are written.
+	ResultsDir string
+	// JobTimeout bounds how long a single job may run.
+	JobTimeout time.Duration
+	// Notify, if set, is called once a job has succeeded or failed and its
+	// outcome has been stored. message is the error of a failed job.
+	Notify func(id string, status storage.JobStatus, message string)
+}
+
+// Manager queues jobs and runs them on a fixed pool of workers.
+type Manager struct {
+	pool   *pgxpool.Pool
+	runner Runner
+	config Config
+	queue  chan string
+	wg     sync.WaitGroup
+}
+
+// NewManager creates a Manager. Call Start to begin processing jobs.
+func NewManager(pool *pgxpool.Pool, runner Runner, config Config) (*Manager, error) {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+	if config.JobTimeout <= 0 {
+		config.JobTimeout = 15 * time.Minute
+	}
+	if err := os.MkdirAll(config.ResultsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create job results directory: %w", err)
+	}
+	return &Manager{
+		pool:   pool,
+		runner: runner,
+		config: config,
+		queue:  make(chan string, config.QueueSize),
+	}, nil
+}
+
+// Start launches the workers and re-queues jobs left unfinished by a previous
+// run. Workers stop when ctx is cancelled; use Wait to block until they have.
+func (m *Manager) Start(ctx context.Context) error {
+	unfinished, err := storage.ListUnfinishedJobIDs(ctx, m.pool)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < m.config.Workers; i++ {
+		m.wg.Add(1)
+		go m.worker(ctx)
+	}
+
+	if len(unfinished) > 0 {
+		fmt.Printf("INFO: Resuming %d unfinished analysis jobs.\n", len(unfinished))
+		go func() {
+			for _, id := range unfinished {
+				select {
+				case m.queue <- id:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	fmt.Printf("INFO: Started %d analysis job workers.\n", m.config.Workers)
+	return nil
+}
+
+// Wait blocks until all workers have stopped.
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// Submit stores a new job for the request and queues it.
+func (m *Manager) Submit(ctx context.Context, request json.RawMessage) (*storage.Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	job, err := storage.CreateJob(ctx, m.pool, id, request)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case m.queue <- job.ID:
+		fmt.Printf("INFO: Queued analysis job %s.\n", job.ID)
+		return job, nil
+	default:
+		if err := storage.FailJob(ctx, m.pool, job.ID, ErrQueueFull.Error()); err != nil {
+			fmt.Printf("WARNING: %v\n", err)
+		}
+		return nil, ErrQueueFull
+	}
+}
+
+func (m *Manager) worker(ctx context.Context) {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-m.queue:
+			m.run(ctx, id)
+		}
+	}
+}
+
+// run executes one job and records the outcome.
+func (m *Manager) run(ctx context.Context, id string) {
+	job, err := storage.GetJob(ctx, m.pool, id)
+	if err != nil {
+		fmt.Printf("ERROR: Could not load analysis job %s: %v\n", id, err)
+		return
+	}
+	if err := storage.MarkJobRunning(ctx, m.pool, id); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return
+	}
+	fmt.Printf("INFO: Running analysis job %s.\n", id)
+
+	jobCtx, cancel := context.WithTimeout(ctx, m.config.JobTimeout)
+	defer cancel()
+
+	report := func(progress int, message string) {
+		if err := storage.UpdateJobProgress(ctx, m.pool, id, progress, message); err != nil {
+			fmt.Printf("WARNING: %v\n", err)
+		}
+	}
+
+	result, err := m.runner(jobCtx, job, report)
+	if err == nil {
+		var path string
+		path, err = m.writeResult(id, result)
+		if err == nil {
+			err = storage.CompleteJob(ctx, m.pool, id, path, result.ContentType)
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// The server is shutting down; leave the job as running so it is
+			// resumed on the next start.
+			fmt.Printf("INFO: Analysis job %s interrupted by shutdown.\n", id)
+			return
+		}
+		fmt.Printf("ERROR: Analysis job %s failed: %v\n", id, err)
+		if err := storage.FailJob(ctx, m.pool, id, err.Error()); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+		}
+		m.notify(id, storage.JobFailed, err.Error())
+		return
+	}
+	fmt.Printf("INFO: Analysis job %s finished.\n", id)
+	m.notify(id, storage.JobSucceeded, "")
+}
+
+func (m *Manager) notify(id string, status storage.JobStatus, message string) {
+	if m.config.Notify != nil {
+		m.config.Notify(id, status, message)
+	}
+}
+
+// writeResult stores the result data in the results directory.
+func (m *Manager) writeResult(id string, result *Result) (string, error) {
+	if result == nil {
+		return "", fmt.Errorf("job produced no result")
+	}
+	ext := ".bin"
+	if exts, _ := mime.ExtensionsByType(result.ContentType); len(exts) > 0 {
+		ext = exts[0]
+	}
+	path := filepath.Join(m.config.ResultsDir, id+ext)
+	if err := os.WriteFile(path, result.Data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write job result: %w", err)
+	}
+	return path, nil
+}
+
+// newJobID returns a random 128-bit hex identifier.
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
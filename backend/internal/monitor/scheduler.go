@@ -0,0 +1,364 @@
+// internal/monitor/scheduler.go
+
+// Package monitor periodically analyses saved locations and records the
+// changes it finds as change events.
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"geowatch-backend/internal/composite"
+	"geowatch-backend/internal/detection"
+	"geowatch-backend/internal/fetcher"
+	"geowatch-backend/internal/geo"
+	"geowatch-backend/internal/pipeline"
+	"geowatch-backend/internal/storage"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// EventTypeScheduled is the event_type of change events found by the scheduler.
+const EventTypeScheduled = "scheduled_change_detected"
+
+// Config controls how the scheduler runs.
+type Config struct {
+	// PollInterval is how often the scheduler checks for locations that are due.
+	PollInterval time.Duration
+	// Lookback is the acquisition window searched for each image, ending at the
+	// target date, so that a cloud-free or available scene can be found. The
+	// scene with the most cloud-free coverage wins, the latest on a tie. The
+	// window of the latest image never reaches back to the baseline.
+	Lookback time.Duration
+	// Mode is detection.ModeVisual (the default), which compares true-colour
+	// images, or detection.ModeSpectral, which compares NDVI, NDWI and NDBI
+	// rasters like the GEE service and needs no Earth Engine.
+	Mode detection.Mode
+	// Detection configures the change threshold.
+	Detection detection.Options
+	// MinRegionPixels drops change regions smaller than this.
+	MinRegionPixels int
+	// Normalization brings the latest image to the radiometry of the
+	// baseline before comparing, so illumination changes aren't flagged.
+	Normalization fetcher.Normalization
+	// Pipeline, when set, processes the images of locations that don't
+	// declare their own pipeline, in place of Normalization.
+	Pipeline *pipeline.Pipeline
+	// Composite, when set, combines several scenes of the lookback window
+	// into each image instead of using the single best scene.
+	Composite *composite.Config
+	// MinValidFraction is the share of pixels that must be free of cloud,
+	// shadow and missing data in both images. Cloudier comparisons are
+	// skipped and the baseline is kept for the next run.
+	MinValidFraction float64
+}
+
+// DefaultConfig returns the settings used when nothing else is configured.
+func DefaultConfig() Config {
+	return Config{
+		PollInterval:     15 * time.Minute,
+		Lookback:         10 * 24 * time.Hour,
+		Mode:             detection.ModeVisual,
+		Detection:        detection.Options{Strategy: detection.ThresholdOtsu},
+		MinRegionPixels:  16,
+		MinValidFraction: 0.3,
+		Normalization:    fetcher.NormalizePIF,
+	}
+}
+
+// Scheduler watches monitored locations: for each one that is due it fetches
+// the latest imagery, compares it with the previous baseline and saves any
+// change regions as events.
+type Scheduler struct {
+	store   *storage.Store
+	pool    *pgxpool.Pool
+	fetcher *fetcher.Fetcher
+	config  Config
+	now     func() time.Time
+}
+
+// NewScheduler creates a Scheduler. Call Run to start it.
+func NewScheduler(store *storage.Store, pool *pgxpool.Pool, f *fetcher.Fetcher, config Config) *Scheduler {
+	return &Scheduler{
+		store:   store,
+		pool:    pool,
+		fetcher: f,
+		config:  config,
+		now:     time.Now,
+	}
+}
+
+// Run checks for due locations every PollInterval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	fmt.Printf("INFO: Location monitoring scheduler started (checking every %s).\n", s.config.PollInterval)
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RunOnce(ctx); err != nil {
+			fmt.Printf("ERROR: Scheduled monitoring pass failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			fmt.Println("INFO: Location monitoring scheduler stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce analyses every location that is currently due. A failure for one
+// location is recorded on it and doesn't stop the others.
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	now := s.now()
+	locations, err := s.store.ListDueLocations(ctx, now)
+	if err != nil {
+		return fmt.Errorf("failed to list due locations: %w", err)
+	}
+
+	for _, loc := range locations {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		baseline, err := s.checkLocation(ctx, loc, now)
+		runErr := ""
+		if err != nil {
+			runErr = err.Error()
+			fmt.Printf("ERROR: Monitoring location %d (%s) failed: %v\n", loc.ID, loc.Name, err)
+		}
+		if err := s.store.RecordLocationRun(ctx, loc.ID, now, baseline, runErr); err != nil {
+			fmt.Printf("ERROR: Failed to record monitoring run for location %d: %v\n", loc.ID, err)
+		}
+	}
+	return nil
+}
+
+// checkLocation runs one comparison for a location and returns the acquisition
+// date of the new image, which becomes the next baseline. It returns nil,
+// keeping the baseline, when nothing newer than the baseline was acquired.
+func (s *Scheduler) checkLocation(ctx context.Context, loc storage.MonitoredLocation, now time.Time) (*time.Time, error) {
+	fmt.Printf("INFO: Monitoring location %d (%s).\n", loc.ID, loc.Name)
+
+	// Without a previous run, compare against one cadence ago.
+	baselineDate := now.Add(-loc.Interval)
+	if loc.BaselineDate != nil {
+		baselineDate = *loc.BaselineDate
+	}
+
+	// The baseline window includes the baseline acquisition itself; the
+	// latest window only holds acquisitions strictly after it, so a scene is
+	// never compared with itself or with an older one.
+	from := now.Add(-s.config.Lookback)
+	if after := baselineDate.Add(time.Second); after.After(from) {
+		from = after
+	}
+	if !now.After(from) {
+		fmt.Printf("INFO: No imagery newer than the baseline of location %d can exist yet.\n", loc.ID)
+		return nil, nil
+	}
+
+	var cmp *comparison
+	var err error
+	if s.config.Mode == detection.ModeSpectral {
+		cmp, err = s.compareRasters(ctx, loc, baselineDate, from, now)
+	} else {
+		cmp, err = s.compareImages(ctx, loc, baselineDate, from, now)
+	}
+	if err != nil || cmp == nil {
+		return nil, err
+	}
+	result, acquired := cmp.result, cmp.acquired
+
+	if result.ValidFraction < s.config.MinValidFraction {
+		return nil, fmt.Errorf("only %.0f%% of the area is free of cloud in both images (need %.0f%%)",
+			100*result.ValidFraction, 100*s.config.MinValidFraction)
+	}
+	regions, err := detection.Vectorize(result, loc.BBox, s.config.MinRegionPixels)
+	if err != nil {
+		return nil, err
+	}
+	if len(regions) == 0 {
+		fmt.Printf("INFO: No significant change at location %d.\n", loc.ID)
+		return &acquired, nil
+	}
+
+	description := fmt.Sprintf("Scheduled monitoring of %s: change between %s and %s (%.0f%% of the area cloud-free)",
+		loc.Name, cmp.before, cmp.after, 100*result.ValidFraction)
+	if len(cmp.skipped) > 0 {
+		description += "; skipped processing steps: " + strings.Join(cmp.skipped, ", ")
+	}
+	event := storage.ChangeEvent{
+		LocationID:  loc.ID,
+		EventType:   EventTypeScheduled,
+		Description: description,
+		DetectedAt:  now,
+		Pipeline:    cmp.pipeline,
+	}
+	if _, err := storage.SaveChangeRegions(s.pool, event, regions); err != nil {
+		return nil, err
+	}
+	return &acquired, nil
+}
+
+// comparison is the outcome of comparing a location's baseline with its
+// latest acquisition.
+type comparison struct {
+	result *detection.Result
+	// before and after label the compared acquisitions in event descriptions.
+	before, after string
+	// acquired is when the latest acquisition was made.
+	acquired time.Time
+	// pipeline records how the images were processed, nil if they weren't.
+	pipeline json.RawMessage
+	// skipped describes the optional pipeline steps that failed.
+	skipped []string
+}
+
+// compareImages compares true-colour images of the baseline and of the
+// window [from, now) after running them through the location's pipeline. It
+// returns nil when there is no acquisition newer than the baseline.
+func (s *Scheduler) compareImages(ctx context.Context, loc storage.MonitoredLocation, baselineDate, from, now time.Time) (*comparison, error) {
+	before, err := s.fetchLatest(ctx, loc.BBox, baselineDate.Add(-s.config.Lookback), baselineDate.Add(time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch baseline image: %w", err)
+	}
+	after, err := s.fetchLatest(ctx, loc.BBox, from, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch latest image: %w", err)
+	}
+	if !after.AcquiredAt.After(baselineDate) {
+		fmt.Printf("INFO: No acquisition of location %d newer than the baseline (%s).\n", loc.ID, baselineDate.Format(time.RFC3339))
+		return nil, nil
+	}
+	cmp := &comparison{
+		before:   sceneLabel(before.AcquiredAt, before.SceneID),
+		after:    sceneLabel(after.AcquiredAt, after.SceneID),
+		acquired: after.AcquiredAt,
+	}
+
+	steps, err := s.pipelineFor(loc)
+	if err != nil {
+		return nil, err
+	}
+	before, after, applied, err := steps.Apply(before, after, locationArea(loc))
+	if err != nil {
+		return nil, err
+	}
+	cmp.skipped = applied.SkippedSteps()
+	if cmp.pipeline, err = json.Marshal(applied); err != nil {
+		fmt.Printf("WARNING: Failed to record the pipeline of location %d: %v\n", loc.ID, err)
+		cmp.pipeline = nil
+	}
+
+	if cmp.result, err = detection.VisualChangeWithOptions(before, after, s.config.Detection); err != nil {
+		return nil, err
+	}
+	return cmp, nil
+}
+
+// compareRasters compares the NDVI, NDWI and NDBI of the baseline and of the
+// window [from, now) like the GEE service does. The image pipeline doesn't
+// apply to rasters, so the API rejects location pipelines in spectral mode;
+// pipelines saved before the mode was switched are ignored with a warning.
+// It returns nil when there is no acquisition newer than the baseline.
+func (s *Scheduler) compareRasters(ctx context.Context, loc storage.MonitoredLocation, baselineDate, from, now time.Time) (*comparison, error) {
+	if len(loc.Pipeline) > 0 {
+		fmt.Printf("WARNING: Location %d has a processing pipeline, which spectral monitoring doesn't apply.\n", loc.ID)
+	}
+	before, err := s.fetchLatestRaster(ctx, loc.BBox, baselineDate.Add(-s.config.Lookback), baselineDate.Add(time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch baseline raster: %w", err)
+	}
+	after, err := s.fetchLatestRaster(ctx, loc.BBox, from, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch latest raster: %w", err)
+	}
+	if !after.AcquiredAt.After(baselineDate) {
+		fmt.Printf("INFO: No acquisition of location %d newer than the baseline (%s).\n", loc.ID, baselineDate.Format(time.RFC3339))
+		return nil, nil
+	}
+
+	result, err := detection.SpectralChangeWithOptions(before, after, s.config.Detection)
+	if err != nil {
+		return nil, err
+	}
+	return &comparison{
+		result:   result,
+		before:   sceneLabel(before.AcquiredAt, before.SceneID),
+		after:    sceneLabel(after.AcquiredAt, after.SceneID),
+		acquired: after.AcquiredAt,
+	}, nil
+}
+
+// pipelineFor returns the processing pipeline for a location: its own, the
+// configured default, or a pipeline that only applies Normalization.
+func (s *Scheduler) pipelineFor(loc storage.MonitoredLocation) (*pipeline.Pipeline, error) {
+	if len(loc.Pipeline) > 0 {
+		p, err := pipeline.Parse(loc.Pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("location has an invalid pipeline: %w", err)
+		}
+		return p, nil
+	}
+	if s.config.Pipeline != nil {
+		return s.config.Pipeline, nil
+	}
+	return pipeline.Normalization(s.config.Normalization), nil
+}
+
+// locationArea describes where a location's images lie for the pipeline.
+// Locations that aren't polygons have no area of interest to crop to.
+func locationArea(loc storage.MonitoredLocation) pipeline.Area {
+	polygons, err := geo.ParsePolygons(loc.Geometry)
+	if err != nil {
+		polygons = nil
+	}
+	return pipeline.Area{BBox: loc.BBox, Polygons: polygons}
+}
+
+// sceneLabel names an acquisition in event descriptions by date and, when
+// known, scene ID.
+func sceneLabel(acquiredAt time.Time, sceneID string) string {
+	if sceneID == "" {
+		return acquiredAt.Format("2006-01-02")
+	}
+	return fmt.Sprintf("%s (%s)", acquiredAt.Format("2006-01-02"), sceneID)
+}
+
+// fetchLatest fetches the best image of the bbox acquired in [from, to),
+// preferring acquisitions near to on a tie, or a composite of the window's
+// scenes.
+func (s *Scheduler) fetchLatest(ctx context.Context, bbox []float64, from, to time.Time) (*fetcher.SatelliteImage, error) {
+	req := fetcher.ImageRequest{
+		BBox:   bbox,
+		From:   from,
+		To:     to,
+		Bands:  fetcher.DefaultRGBBands,
+		Width:  512,
+		Height: 512,
+	}
+	if s.config.Composite != nil {
+		return composite.FetchImage(ctx, s.fetcher, req, to, *s.config.Composite)
+	}
+	return s.fetcher.FetchBestImage(ctx, req, to)
+}
+
+// fetchLatestRaster is fetchLatest for the raw bands behind
+// detection.SpectralBands.
+func (s *Scheduler) fetchLatestRaster(ctx context.Context, bbox []float64, from, to time.Time) (*fetcher.Raster, error) {
+	req := fetcher.ImageRequest{
+		BBox:   bbox,
+		From:   from,
+		To:     to,
+		Bands:  fetcher.SourceBands(detection.SpectralBands),
+		Width:  512,
+		Height: 512,
+	}
+	if s.config.Composite != nil {
+		return composite.FetchRaster(ctx, s.fetcher, req, to, *s.config.Composite)
+	}
+	return s.fetcher.FetchBestRaster(ctx, req, to)
+}
@@ -0,0 +1,118 @@
+// internal/monitor/scheduler_test.go
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"geowatch-backend/internal/fetcher"
+	"geowatch-backend/internal/storage"
+)
+
+// fakeProvider serves the same textured image for every request, acquired an
+// hour before the end of the requested window, and records the requests.
+type fakeProvider struct {
+	requests []fetcher.ImageRequest
+	err      error
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) FetchImage(ctx context.Context, req fetcher.ImageRequest) (*fetcher.SatelliteImage, error) {
+	p.requests = append(p.requests, req)
+	if p.err != nil {
+		return nil, p.err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, req.Width, req.Height))
+	for y := 0; y < req.Height; y++ {
+		for x := 0; x < req.Width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: 255})
+		}
+	}
+	return &fetcher.SatelliteImage{ID: "fake", AcquiredAt: req.To.Add(-time.Hour), ImageData: img}, nil
+}
+
+func (p *fakeProvider) FetchRaster(ctx context.Context, req fetcher.ImageRequest) (*fetcher.Raster, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestCheckLocation(t *testing.T) {
+	now := time.Date(2024, 7, 15, 12, 0, 0, 0, time.UTC)
+	baseline := now.Add(-72 * time.Hour)
+	recent := now.Add(-30 * time.Minute)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		baseline *time.Time
+		// wantFrom is the start of the latest image's window, zero if it
+		// mustn't be fetched.
+		wantFrom     time.Time
+		wantAcquired *time.Time
+	}{
+		{"first run", nil, now.Add(-24*time.Hour + time.Second), ptr(now.Add(-time.Hour))},
+		{"previous baseline", &baseline, baseline.Add(time.Second), ptr(now.Add(-time.Hour))},
+		// The provider's latest scene is older than the baseline, so the
+		// baseline is kept.
+		{"nothing newer", &recent, recent.Add(time.Second), nil},
+		{"baseline in the future", &future, time.Time{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakeProvider{}
+			s := NewScheduler(nil, nil, fetcher.NewFetcherWithProvider(p), DefaultConfig())
+			loc := storage.MonitoredLocation{ID: 1, Name: "test", BBox: []float64{0, 0, 0.1, 0.1}, Interval: 24 * time.Hour, BaselineDate: tt.baseline}
+
+			acquired, err := s.checkLocation(context.Background(), loc, now)
+			if err != nil {
+				t.Fatalf("checkLocation() failed: %v", err)
+			}
+			if tt.wantFrom.IsZero() {
+				if len(p.requests) > 1 {
+					t.Errorf("fetched the latest image, want no request for it")
+				}
+			} else {
+				if len(p.requests) != 2 {
+					t.Fatalf("made %d requests, want 2", len(p.requests))
+				}
+				before, after := p.requests[0], p.requests[1]
+				baselineDate := now.Add(-24 * time.Hour)
+				if tt.baseline != nil {
+					baselineDate = *tt.baseline
+				}
+				// The baseline window includes the baseline acquisition;
+				// the latest one starts after it.
+				if !before.To.Equal(baselineDate.Add(time.Second)) {
+					t.Errorf("baseline window ends at %v, want %v", before.To, baselineDate.Add(time.Second))
+				}
+				if !after.From.Equal(tt.wantFrom) || !after.To.Equal(now) {
+					t.Errorf("latest window is [%v, %v), want [%v, %v)", after.From, after.To, tt.wantFrom, now)
+				}
+			}
+			if (acquired == nil) != (tt.wantAcquired == nil) || (acquired != nil && !acquired.Equal(*tt.wantAcquired)) {
+				t.Errorf("checkLocation() = %v, want %v", acquired, tt.wantAcquired)
+			}
+		})
+	}
+}
+
+func ptr(t time.Time) *time.Time { return &t }
+
+func TestCheckLocationFetchError(t *testing.T) {
+	p := &fakeProvider{err: errors.New("provider down")}
+	s := NewScheduler(nil, nil, fetcher.NewFetcherWithProvider(p), DefaultConfig())
+	loc := storage.MonitoredLocation{ID: 1, Name: "test", BBox: []float64{0, 0, 0.1, 0.1}, Interval: 24 * time.Hour}
+
+	acquired, err := s.checkLocation(context.Background(), loc, time.Now())
+	if err == nil {
+		t.Fatal("checkLocation() succeeded, want an error")
+	}
+	if acquired != nil {
+		t.Errorf("checkLocation() moved the baseline to %v after a failure", acquired)
+	}
+}
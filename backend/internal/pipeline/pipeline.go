@@ -0,0 +1,183 @@
+// internal/pipeline/pipeline.go
+
+// Package pipeline runs a declared sequence of processing steps over the two
+// images of a comparison: radiometric normalisation, denoising, resampling,
+// cropping to the area of interest and masking. Pipelines are plain JSON, so
+// they can be stored per location and recorded with the change events they
+// produced, making every event reproducible.
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"geowatch-backend/internal/fetcher"
+	"geowatch-backend/internal/geo"
+)
+
+// StepName identifies the kind of processing a step does.
+type StepName string
+
+const (
+	// StepNormalize brings the "after" image to the radiometry of the
+	// "before" image (see NormalizeParams).
+	StepNormalize StepName = "normalize"
+	// StepDenoise smooths both images with a median or Gaussian filter (see
+	// DenoiseParams).
+	StepDenoise StepName = "denoise"
+	// StepResample scales both images to a fixed size (see ResampleParams).
+	StepResample StepName = "resample"
+	// StepCrop masks out everything outside the area of interest (see
+	// CropParams).
+	StepCrop StepName = "crop"
+	// StepMask masks unusable pixels by brightness and grows the masked
+	// areas (see MaskParams).
+	StepMask StepName = "mask"
+)
+
+// Step is one named, configurable step of a pipeline.
+type Step struct {
+	Name StepName `json:"name"`
+	// Params holds the step's settings, as the matching ...Params type.
+	// Parse fills in the defaults, so a parsed pipeline lists every setting.
+	Params json.RawMessage `json:"params,omitempty"`
+	// Optional steps that fail are skipped with a warning instead of failing
+	// the whole pipeline.
+	Optional bool `json:"optional,omitempty"`
+	// Skipped is set in the record returned by Apply to the reason an
+	// optional step didn't run.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Pipeline is an ordered list of steps applied to both images of a
+// comparison.
+type Pipeline struct {
+	Steps []Step `json:"steps"`
+}
+
+// Area is where the compared images lie: their bbox (minLon, minLat, maxLon,
+// maxLat) and the polygons of the area of interest, if known.
+type Area struct {
+	BBox     []float64
+	Polygons []geo.Polygon
+}
+
+// operation is the parsed form of a step.
+type operation interface {
+	// apply processes the image pair, returning the new pair.
+	apply(before, after *fetcher.SatelliteImage, area Area) (*fetcher.SatelliteImage, *fetcher.SatelliteImage, error)
+}
+
+// Parse decodes and validates a pipeline, filling in every step's default
+// settings. Unknown steps and settings are rejected.
+func Parse(data []byte) (*Pipeline, error) {
+	var p Pipeline
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %w", err)
+	}
+	for i, step := range p.Steps {
+		op, err := step.operation()
+		if err != nil {
+			return nil, fmt.Errorf("pipeline step %d (%s): %w", i+1, step.Name, err)
+		}
+		params, err := json.Marshal(op)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline step %d (%s): %w", i+1, step.Name, err)
+		}
+		p.Steps[i].Params = params
+		p.Steps[i].Skipped = ""
+	}
+	return &p, nil
+}
+
+// Apply runs the steps in order on the image pair. Per-image steps process
+// both images with the same settings; normalisation adjusts after to before.
+// It returns the processed images and a record of the pipeline as it ran,
+// with default settings filled in and skipped optional steps marked.
+func (p *Pipeline) Apply(before, after *fetcher.SatelliteImage, area Area) (*fetcher.SatelliteImage, *fetcher.SatelliteImage, *Pipeline, error) {
+	if before == nil || after == nil || before.ImageData == nil || after.ImageData == nil {
+		return nil, nil, nil, fmt.Errorf("cannot process nil images")
+	}
+	record := &Pipeline{Steps: make([]Step, 0, len(p.Steps))}
+	for i, step := range p.Steps {
+		op, err := step.operation()
+		if err == nil {
+			if step.Params, err = json.Marshal(op); err == nil {
+				var b, a *fetcher.SatelliteImage
+				if b, a, err = op.apply(before, after, area); err == nil {
+					before, after = b, a
+				}
+			}
+		}
+		if err != nil {
+			if !step.Optional {
+				return nil, nil, nil, fmt.Errorf("pipeline step %d (%s) failed: %w", i+1, step.Name, err)
+			}
+			fmt.Printf("WARNING: Skipping optional pipeline step %d (%s): %v\n", i+1, step.Name, err)
+			step.Skipped = err.Error()
+		}
+		record.Steps = append(record.Steps, step)
+	}
+	return before, after, record, nil
+}
+
+// SkippedSteps describes the optional steps that were skipped in a record
+// returned by Apply, as "name (reason)".
+func (p *Pipeline) SkippedSteps() []string {
+	var skipped []string
+	for _, step := range p.Steps {
+		if step.Skipped != "" {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", step.Name, step.Skipped))
+		}
+	}
+	return skipped
+}
+
+// operation decodes the step's settings and checks them.
+func (s Step) operation() (operation, error) {
+	var op interface {
+		operation
+		resolve() error
+	}
+	switch s.Name {
+	case StepNormalize:
+		op = &NormalizeParams{}
+	case StepDenoise:
+		op = &DenoiseParams{}
+	case StepResample:
+		op = &ResampleParams{}
+	case StepCrop:
+		op = &CropParams{}
+	case StepMask:
+		op = &MaskParams{}
+	default:
+		return nil, fmt.Errorf("unknown step %q (expected %q, %q, %q, %q or %q)",
+			s.Name, StepNormalize, StepDenoise, StepResample, StepCrop, StepMask)
+	}
+	if len(s.Params) > 0 && string(s.Params) != "null" {
+		decoder := json.NewDecoder(bytes.NewReader(s.Params))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(op); err != nil {
+			return nil, fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	if err := op.resolve(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// Normalization returns a pipeline with a single optional normalisation
+// step, which is how comparisons were processed before pipelines could be
+// declared: a failed fit is skipped and noted on the change event. It is
+// empty for fetcher.NormalizeNone.
+func Normalization(method fetcher.Normalization) *Pipeline {
+	if method == "" || method == fetcher.NormalizeNone {
+		return &Pipeline{Steps: []Step{}}
+	}
+	params, _ := json.Marshal(NormalizeParams{Method: method})
+	return &Pipeline{Steps: []Step{{Name: StepNormalize, Params: params, Optional: true}}}
+}
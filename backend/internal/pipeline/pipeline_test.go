@@ -0,0 +1,210 @@
+// internal/pipeline/pipeline_test.go
+
+package pipeline
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"geowatch-backend/internal/fetcher"
+	"geowatch-backend/internal/geo"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// params lists the expected settings of each step after defaults.
+		params  []string
+		wantErr string
+	}{
+		{
+			name:   "empty",
+			input:  `{"steps": []}`,
+			params: []string{},
+		},
+		{
+			name:   "defaults are filled in",
+			input:  `{"steps": [{"name": "normalize"}, {"name": "denoise"}, {"name": "crop"}, {"name": "mask"}]}`,
+			params: []string{`{"method":"pif","pif_percentile":20}`, `{"filter":"median","radius":1}`, `{}`, `{}`},
+		},
+		{
+			name:   "gaussian radius follows sigma",
+			input:  `{"steps": [{"name": "denoise", "params": {"filter": "gaussian", "sigma": 2}}]}`,
+			params: []string{`{"filter":"gaussian","radius":6,"sigma":2}`},
+		},
+		{
+			name:   "histogram matching drops the PIF percentile",
+			input:  `{"steps": [{"name": "normalize", "params": {"method": "histogram_match", "pif_percentile": 30}}]}`,
+			params: []string{`{"method":"histogram_match"}`},
+		},
+		{
+			name:   "resample",
+			input:  `{"steps": [{"name": "resample", "params": {"width": 64, "height": 32}}]}`,
+			params: []string{`{"width":64,"height":32,"method":"bilinear"}`},
+		},
+		{"not JSON", `{"steps": [`, nil, "invalid pipeline"},
+		{"unknown field", `{"steps": [], "order": 1}`, nil, "unknown field"},
+		{"unknown step", `{"steps": [{"name": "sharpen"}]}`, nil, `unknown step "sharpen"`},
+		{"unknown param", `{"steps": [{"name": "mask", "params": {"threshold": 3}}]}`, nil, "unknown field"},
+		{"unknown filter", `{"steps": [{"name": "denoise", "params": {"filter": "bilateral"}}]}`, nil, "unknown filter"},
+		{"radius too large", `{"steps": [{"name": "denoise", "params": {"radius": 50}}]}`, nil, "radius"},
+		{"missing resample size", `{"steps": [{"name": "resample"}]}`, nil, "width and height"},
+		{"bad crop geometry", `{"steps": [{"name": "crop", "params": {"geometry": {"type": "Point", "coordinates": [0, 0]}}}]}`, nil, "step 1"},
+		{"inverted brightness", `{"steps": [{"name": "mask", "params": {"min_brightness": 200, "max_brightness": 100}}]}`, nil, "min_brightness"},
+		{"unknown normalisation", `{"steps": [{"name": "normalize", "params": {"method": "gamma"}}]}`, nil, "unknown normalization"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			if len(p.Steps) != len(tt.params) {
+				t.Fatalf("Parse() returned %d steps, want %d", len(p.Steps), len(tt.params))
+			}
+			for i, step := range p.Steps {
+				if string(step.Params) != tt.params[i] {
+					t.Errorf("step %d params = %s, want %s", i+1, step.Params, tt.params[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	p, err := Parse([]byte(`{"steps": [{"name": "denoise", "optional": true}, {"name": "mask", "params": {"dilate": 2}}]}`))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %v", err)
+	}
+	again, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() of its own output failed: %v", err)
+	}
+	if again2, _ := json.Marshal(again); string(again2) != string(data) {
+		t.Errorf("Parse() isn't stable: %s became %s", data, again2)
+	}
+	if !again.Steps[0].Optional {
+		t.Error("the optional flag was lost")
+	}
+}
+
+// uniformImage returns a width x height image of one colour.
+func uniformImage(id string, width, height int, c color.NRGBA) *fetcher.SatelliteImage {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return &fetcher.SatelliteImage{ID: id, ImageData: img}
+}
+
+func TestApply(t *testing.T) {
+	grey := color.NRGBA{R: 100, G: 100, B: 100, A: 255}
+	area := Area{
+		BBox:     []float64{0, 0, 4, 4},
+		Polygons: []geo.Polygon{{{{0, 0}, {2, 0}, {2, 4}, {0, 4}, {0, 0}}}},
+	}
+	tests := []struct {
+		name    string
+		input   string
+		area    Area
+		skipped []StepName
+		// valid is the expected share of usable pixels in the processed image.
+		valid   float64
+		wantErr bool
+	}{
+		{
+			name:  "crop masks outside the polygon",
+			input: `{"steps": [{"name": "crop"}]}`,
+			area:  area,
+			valid: 0.5,
+		},
+		{
+			name:  "mask drops dark pixels",
+			input: `{"steps": [{"name": "mask", "params": {"min_brightness": 150}}]}`,
+			valid: 0,
+		},
+		{
+			name:    "optional step failure is recorded",
+			input:   `{"steps": [{"name": "normalize", "optional": true}, {"name": "denoise"}]}`,
+			skipped: []StepName{StepNormalize},
+			valid:   1,
+		},
+		{
+			name:    "required step failure fails the pipeline",
+			input:   `{"steps": [{"name": "crop"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			// 4x4 images have too few pixels for a PIF fit.
+			before := uniformImage("before", 4, 4, grey)
+			after := uniformImage("after", 4, 4, grey)
+			_, processed, record, err := p.Apply(before, after, tt.area)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Apply() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+
+			var skipped []StepName
+			for _, step := range record.Steps {
+				if step.Skipped != "" {
+					skipped = append(skipped, step.Name)
+				}
+			}
+			if len(skipped) != len(tt.skipped) {
+				t.Fatalf("skipped steps = %v, want %v", skipped, tt.skipped)
+			}
+			for i, name := range tt.skipped {
+				if skipped[i] != name {
+					t.Errorf("skipped step %d = %s, want %s", i, skipped[i], name)
+				}
+			}
+			valid := 1.0
+			if processed.Mask != nil {
+				valid = processed.Mask.ValidFraction()
+			}
+			if valid != tt.valid {
+				t.Errorf("%.2f of the processed image is usable, want %.2f", valid, tt.valid)
+			}
+		})
+	}
+}
+
+func TestNormalization(t *testing.T) {
+	if steps := Normalization(fetcher.NormalizeNone).Steps; len(steps) != 0 {
+		t.Errorf("Normalization(none) has %d steps, want none", len(steps))
+	}
+	steps := Normalization(fetcher.NormalizeHistogram).Steps
+	if len(steps) != 1 || steps[0].Name != StepNormalize || !steps[0].Optional {
+		t.Fatalf("Normalization(histogram_match) = %+v, want one optional normalize step", steps)
+	}
+	if string(steps[0].Params) != `{"method":"histogram_match"}` {
+		t.Errorf("Normalization(histogram_match) params = %s", steps[0].Params)
+	}
+}
@@ -0,0 +1,463 @@
+// internal/pipeline/steps.go
+
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"geowatch-backend/internal/fetcher"
+	"geowatch-backend/internal/geo"
+)
+
+// Denoising filters.
+const (
+	FilterMedian   = "median"
+	FilterGaussian = "gaussian"
+)
+
+// Resampling methods.
+const (
+	ResampleNearest  = "nearest"
+	ResampleBilinear = "bilinear"
+)
+
+// maxResampleSize bounds the width and height a pipeline may resample to.
+const maxResampleSize = 4096
+
+// maxFilterRadius bounds the filter window of denoising and mask growing.
+const maxFilterRadius = 10
+
+// NormalizeParams configures StepNormalize.
+type NormalizeParams struct {
+	// Method is fetcher.NormalizeHistogram or fetcher.NormalizePIF (the
+	// default).
+	Method fetcher.Normalization `json:"method"`
+	// PIFPercentile is used by fetcher.NormalizePIF, default
+	// fetcher.DefaultPIFPercentile.
+	PIFPercentile float64 `json:"pif_percentile,omitempty"`
+}
+
+func (p *NormalizeParams) resolve() error {
+	if p.Method == "" {
+		p.Method = fetcher.NormalizePIF
+	}
+	method, err := fetcher.ParseNormalization(string(p.Method))
+	if err != nil {
+		return err
+	}
+	p.Method = method
+	if p.Method == fetcher.NormalizePIF {
+		if p.PIFPercentile == 0 {
+			p.PIFPercentile = fetcher.DefaultPIFPercentile
+		}
+		if p.PIFPercentile < 0 || p.PIFPercentile > 100 {
+			return fmt.Errorf("pif_percentile must be between 0 and 100, got %v", p.PIFPercentile)
+		}
+	} else {
+		p.PIFPercentile = 0
+	}
+	return nil
+}
+
+func (p *NormalizeParams) apply(before, after *fetcher.SatelliteImage, _ Area) (*fetcher.SatelliteImage, *fetcher.SatelliteImage, error) {
+	switch p.Method {
+	case fetcher.NormalizeHistogram:
+		normalised, err := fetcher.MatchHistogram(before, after)
+		return before, normalised, err
+	case fetcher.NormalizePIF:
+		normalised, _, err := fetcher.PIFNormalize(before, after, p.PIFPercentile)
+		return before, normalised, err
+	}
+	return before, after, nil
+}
+
+// DenoiseParams configures StepDenoise. Only usable neighbours are taken
+// into account, so clouds and missing data don't bleed into clear pixels.
+type DenoiseParams struct {
+	// Filter is FilterMedian (the default) or FilterGaussian.
+	Filter string `json:"filter"`
+	// Radius is the half-width of the filter window in pixels, default 1
+	// for the median filter and 3 sigma for the Gaussian.
+	Radius int `json:"radius"`
+	// Sigma is the standard deviation of the Gaussian in pixels, default 1.
+	Sigma float64 `json:"sigma,omitempty"`
+}
+
+func (p *DenoiseParams) resolve() error {
+	switch p.Filter {
+	case "", FilterMedian:
+		p.Filter = FilterMedian
+		p.Sigma = 0
+		if p.Radius == 0 {
+			p.Radius = 1
+		}
+	case FilterGaussian:
+		if p.Sigma == 0 {
+			p.Sigma = 1
+		}
+		if p.Sigma < 0 {
+			return fmt.Errorf("sigma must be positive, got %v", p.Sigma)
+		}
+		if p.Radius == 0 {
+			p.Radius = int(math.Ceil(3 * p.Sigma))
+		}
+	default:
+		return fmt.Errorf("unknown filter %q (expected %q or %q)", p.Filter, FilterMedian, FilterGaussian)
+	}
+	if p.Radius < 1 || p.Radius > maxFilterRadius {
+		return fmt.Errorf("radius must be between 1 and %d, got %d", maxFilterRadius, p.Radius)
+	}
+	return nil
+}
+
+func (p *DenoiseParams) apply(before, after *fetcher.SatelliteImage, _ Area) (*fetcher.SatelliteImage, *fetcher.SatelliteImage, error) {
+	return p.denoise(before), p.denoise(after), nil
+}
+
+func (p *DenoiseParams) denoise(img *fetcher.SatelliteImage) *fetcher.SatelliteImage {
+	src := toNRGBA(img.ImageData)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	usable := usablePixels(src, img.Mask)
+	out := image.NewNRGBA(src.Rect)
+	copy(out.Pix, src.Pix)
+
+	size := 2*p.Radius + 1
+	weights := make([]float64, size*size)
+	for dy := -p.Radius; dy <= p.Radius; dy++ {
+		for dx := -p.Radius; dx <= p.Radius; dx++ {
+			w := 1.0
+			if p.Filter == FilterGaussian {
+				w = math.Exp(-float64(dx*dx+dy*dy) / (2 * p.Sigma * p.Sigma))
+			}
+			weights[(dy+p.Radius)*size+dx+p.Radius] = w
+		}
+	}
+
+	var values [3][]float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !usable[y*width+x] {
+				continue
+			}
+			var sums [3]float64
+			var total float64
+			for ch := range values {
+				values[ch] = values[ch][:0]
+			}
+			for dy := -p.Radius; dy <= p.Radius; dy++ {
+				ny := y + dy
+				if ny < 0 || ny >= height {
+					continue
+				}
+				for dx := -p.Radius; dx <= p.Radius; dx++ {
+					nx := x + dx
+					if nx < 0 || nx >= width || !usable[ny*width+nx] {
+						continue
+					}
+					c := src.NRGBAAt(nx, ny)
+					rgb := [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+					if p.Filter == FilterMedian {
+						for ch := range values {
+							values[ch] = append(values[ch], rgb[ch])
+						}
+						continue
+					}
+					w := weights[(dy+p.Radius)*size+dx+p.Radius]
+					for ch := range sums {
+						sums[ch] += w * rgb[ch]
+					}
+					total += w
+				}
+			}
+			c := src.NRGBAAt(x, y)
+			if p.Filter == FilterMedian {
+				c.R, c.G, c.B = round8(median(values[0])), round8(median(values[1])), round8(median(values[2]))
+			} else {
+				c.R, c.G, c.B = round8(sums[0]/total), round8(sums[1]/total), round8(sums[2]/total)
+			}
+			out.SetNRGBA(x, y, c)
+		}
+	}
+
+	fmt.Printf("INFO: Denoised %s with a %s filter of radius %d.\n", img.ID, p.Filter, p.Radius)
+	return derived(img, "_"+p.Filter, out, img.Mask)
+}
+
+// ResampleParams configures StepResample. Pixels whose source pixels aren't
+// all usable are masked.
+type ResampleParams struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Method is ResampleBilinear (the default) or ResampleNearest.
+	Method string `json:"method"`
+}
+
+func (p *ResampleParams) resolve() error {
+	switch p.Method {
+	case "":
+		p.Method = ResampleBilinear
+	case ResampleBilinear, ResampleNearest:
+	default:
+		return fmt.Errorf("unknown resampling method %q (expected %q or %q)", p.Method, ResampleBilinear, ResampleNearest)
+	}
+	if p.Width < 1 || p.Height < 1 || p.Width > maxResampleSize || p.Height > maxResampleSize {
+		return fmt.Errorf("width and height must be between 1 and %d, got %dx%d", maxResampleSize, p.Width, p.Height)
+	}
+	return nil
+}
+
+func (p *ResampleParams) apply(before, after *fetcher.SatelliteImage, _ Area) (*fetcher.SatelliteImage, *fetcher.SatelliteImage, error) {
+	return p.resample(before), p.resample(after), nil
+}
+
+func (p *ResampleParams) resample(img *fetcher.SatelliteImage) *fetcher.SatelliteImage {
+	src := toNRGBA(img.ImageData)
+	srcWidth, srcHeight := src.Rect.Dx(), src.Rect.Dy()
+	scaleX, scaleY := float64(srcWidth)/float64(p.Width), float64(srcHeight)/float64(p.Height)
+	out := image.NewNRGBA(image.Rect(0, 0, p.Width, p.Height))
+	var mask fetcher.Mask
+	if img.Mask != nil {
+		mask = make(fetcher.Mask, p.Width*p.Height)
+	}
+
+	for y := 0; y < p.Height; y++ {
+		for x := 0; x < p.Width; x++ {
+			i := y*p.Width + x
+			if p.Method == ResampleNearest {
+				sx := clampInt(int((float64(x)+0.5)*scaleX), srcWidth-1)
+				sy := clampInt(int((float64(y)+0.5)*scaleY), srcHeight-1)
+				out.SetNRGBA(x, y, src.NRGBAAt(sx, sy))
+				if mask != nil {
+					mask[i] = img.Mask[sy*srcWidth+sx]
+				}
+				continue
+			}
+
+			fx := math.Max(0, (float64(x)+0.5)*scaleX-0.5)
+			fy := math.Max(0, (float64(y)+0.5)*scaleY-0.5)
+			x0, y0 := clampInt(int(fx), srcWidth-1), clampInt(int(fy), srcHeight-1)
+			x1, y1 := clampInt(x0+1, srcWidth-1), clampInt(y0+1, srcHeight-1)
+			wx, wy := fx-float64(x0), fy-float64(y0)
+			c00, c10, c01, c11 := src.NRGBAAt(x0, y0), src.NRGBAAt(x1, y0), src.NRGBAAt(x0, y1), src.NRGBAAt(x1, y1)
+			lerp := func(v00, v10, v01, v11 uint8) uint8 {
+				top := float64(v00)*(1-wx) + float64(v10)*wx
+				bottom := float64(v01)*(1-wx) + float64(v11)*wx
+				return round8(top*(1-wy) + bottom*wy)
+			}
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: lerp(c00.R, c10.R, c01.R, c11.R),
+				G: lerp(c00.G, c10.G, c01.G, c11.G),
+				B: lerp(c00.B, c10.B, c01.B, c11.B),
+				A: lerp(c00.A, c10.A, c01.A, c11.A),
+			})
+			if mask != nil {
+				mask[i] = img.Mask[y0*srcWidth+x0] && img.Mask[y0*srcWidth+x1] &&
+					img.Mask[y1*srcWidth+x0] && img.Mask[y1*srcWidth+x1]
+			}
+		}
+	}
+
+	fmt.Printf("INFO: Resampled %s from %dx%d to %dx%d (%s).\n", img.ID, srcWidth, srcHeight, p.Width, p.Height, p.Method)
+	return derived(img, "_resampled", out, mask)
+}
+
+// CropParams configures StepCrop. Pixels whose centre lies outside the
+// geometry are masked and made transparent; the images keep their extent so
+// that results stay georeferenced to the bbox.
+type CropParams struct {
+	// Geometry is a GeoJSON Polygon or MultiPolygon. It defaults to the
+	// area of interest, such as the monitored location's geometry.
+	Geometry json.RawMessage `json:"geometry,omitempty"`
+}
+
+func (p *CropParams) resolve() error {
+	if len(p.Geometry) == 0 || string(p.Geometry) == "null" {
+		p.Geometry = nil
+		return nil
+	}
+	_, err := geo.ParsePolygons(p.Geometry)
+	return err
+}
+
+func (p *CropParams) apply(before, after *fetcher.SatelliteImage, area Area) (*fetcher.SatelliteImage, *fetcher.SatelliteImage, error) {
+	polygons := area.Polygons
+	if p.Geometry != nil {
+		var err error
+		if polygons, err = geo.ParsePolygons(p.Geometry); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(polygons) == 0 {
+		return nil, nil, fmt.Errorf("no polygon to crop to: set a geometry or use a polygon area of interest")
+	}
+	if len(area.BBox) != 4 {
+		return nil, nil, fmt.Errorf("cropping needs the images' bbox")
+	}
+	return p.crop(before, area.BBox, polygons), p.crop(after, area.BBox, polygons), nil
+}
+
+func (p *CropParams) crop(img *fetcher.SatelliteImage, bbox []float64, polygons []geo.Polygon) *fetcher.SatelliteImage {
+	src := toNRGBA(img.ImageData)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	out := image.NewNRGBA(src.Rect)
+	copy(out.Pix, src.Pix)
+	mask := make(fetcher.Mask, width*height)
+
+	for y := 0; y < height; y++ {
+		lat := bbox[3] - (float64(y)+0.5)/float64(height)*(bbox[3]-bbox[1])
+		for x := 0; x < width; x++ {
+			lon := bbox[0] + (float64(x)+0.5)/float64(width)*(bbox[2]-bbox[0])
+			i := y*width + x
+			if insideAny(polygons, geo.Point{lon, lat}) {
+				mask[i] = img.Mask.Valid(i)
+				continue
+			}
+			out.Pix[y*out.Stride+x*4+3] = 0
+		}
+	}
+
+	fmt.Printf("INFO: Cropped %s to the area of interest (%.0f%% of the image usable).\n", img.ID, 100*mask.ValidFraction())
+	return derived(img, "_cropped", out, mask)
+}
+
+// MaskParams configures StepMask. Pixels outside the brightness range are
+// masked, and masked areas are then grown so that the hazy edges of clouds
+// and shadows are excluded too.
+type MaskParams struct {
+	// MinBrightness and MaxBrightness bound the mean of the RGB channels
+	// (0-255) of usable pixels. Zero turns a bound off.
+	MinBrightness int `json:"min_brightness,omitempty"`
+	MaxBrightness int `json:"max_brightness,omitempty"`
+	// Dilate grows masked areas by this many pixels.
+	Dilate int `json:"dilate,omitempty"`
+}
+
+func (p *MaskParams) resolve() error {
+	if p.MinBrightness < 0 || p.MinBrightness > 255 || p.MaxBrightness < 0 || p.MaxBrightness > 255 {
+		return fmt.Errorf("brightness bounds must be between 0 and 255")
+	}
+	if p.MaxBrightness > 0 && p.MinBrightness > p.MaxBrightness {
+		return fmt.Errorf("min_brightness %d is above max_brightness %d", p.MinBrightness, p.MaxBrightness)
+	}
+	if p.Dilate < 0 || p.Dilate > maxFilterRadius {
+		return fmt.Errorf("dilate must be between 0 and %d, got %d", maxFilterRadius, p.Dilate)
+	}
+	return nil
+}
+
+func (p *MaskParams) apply(before, after *fetcher.SatelliteImage, _ Area) (*fetcher.SatelliteImage, *fetcher.SatelliteImage, error) {
+	return p.mask(before), p.mask(after), nil
+}
+
+func (p *MaskParams) mask(img *fetcher.SatelliteImage) *fetcher.SatelliteImage {
+	src := toNRGBA(img.ImageData)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	mask := usablePixels(src, img.Mask)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := src.NRGBAAt(x, y)
+			brightness := (float64(c.R) + float64(c.G) + float64(c.B)) / 3
+			if p.MinBrightness > 0 && brightness < float64(p.MinBrightness) ||
+				p.MaxBrightness > 0 && brightness > float64(p.MaxBrightness) {
+				mask[y*width+x] = false
+			}
+		}
+	}
+
+	if p.Dilate > 0 {
+		grown := make(fetcher.Mask, len(mask))
+		copy(grown, mask)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if mask[y*width+x] {
+					continue
+				}
+				for ny := y - p.Dilate; ny <= y+p.Dilate; ny++ {
+					for nx := x - p.Dilate; nx <= x+p.Dilate; nx++ {
+						if ny >= 0 && ny < height && nx >= 0 && nx < width {
+							grown[ny*width+nx] = false
+						}
+					}
+				}
+			}
+		}
+		mask = grown
+	}
+
+	fmt.Printf("INFO: Masked %s (%.0f%% of the image usable).\n", img.ID, 100*mask.ValidFraction())
+	return derived(img, "_masked", src, mask)
+}
+
+// toNRGBA returns the image as NRGBA with its bounds moved to the origin,
+// matching the row-by-row layout of fetcher.Mask.
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			out.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+	}
+	return out
+}
+
+// usablePixels returns a mask that is true where the image mask allows and
+// the pixel isn't transparent.
+func usablePixels(img *image.NRGBA, mask fetcher.Mask) fetcher.Mask {
+	width := img.Rect.Dx()
+	usable := make(fetcher.Mask, width*img.Rect.Dy())
+	for i := range usable {
+		usable[i] = (mask == nil || i < len(mask) && mask[i]) && img.Pix[(i/width)*img.Stride+(i%width)*4+3] > 0
+	}
+	return usable
+}
+
+// derived returns a processed copy of img with the given pixels and mask.
+func derived(img *fetcher.SatelliteImage, suffix string, data image.Image, mask fetcher.Mask) *fetcher.SatelliteImage {
+	return &fetcher.SatelliteImage{
+		ID:         img.ID + suffix,
+		SceneID:    img.SceneID,
+		AcquiredAt: img.AcquiredAt,
+		ImageData:  data,
+		Mask:       mask,
+	}
+}
+
+// insideAny reports whether pt lies inside any of the polygons.
+func insideAny(polygons []geo.Polygon, pt geo.Point) bool {
+	for _, polygon := range polygons {
+		if polygon.Contains(pt) {
+			return true
+		}
+	}
+	return false
+}
+
+// median returns the median of the values. The slice is sorted in place.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 1 {
+		return values[mid]
+	}
+	return (values[mid-1] + values[mid]) / 2
+}
+
+func round8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+func clampInt(v, max int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
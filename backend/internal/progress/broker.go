@@ -0,0 +1,181 @@
+// internal/progress/broker.go
+
+// Package progress fans out the lifecycle events of running analyses to any
+// number of subscribers, such as Server-Sent Events connections. Recent
+// events are kept for a while so a client that subscribes late, or after the
+// analysis finished, still sees where it stands.
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// Stage is a step in the lifecycle of an analysis.
+type Stage string
+
+const (
+	StageQueued Stage = "queued"
+	// StageFetching covers the Earth Engine request. The before and after
+	// composites are built together in a single request to the analysis
+	// service, so they are reported as one stage, with progress per tile for
+	// tiled analyses.
+	StageFetching Stage = "fetching"
+	// StageProcessing covers the processing pipeline of analyses that
+	// declare one, which the backend runs on the fetched images.
+	StageProcessing     Stage = "processing"
+	StageComputingStats Stage = "computing_stats"
+	StageRendering      Stage = "rendering"
+	StageDone           Stage = "done"
+	StageError          Stage = "error"
+)
+
+// Final reports whether no events follow this stage.
+func (s Stage) Final() bool {
+	return s == StageDone || s == StageError
+}
+
+// Event is one progress update.
+type Event struct {
+	AnalysisID string    `json:"analysis_id"`
+	Stage      Stage     `json:"stage"`
+	Progress   int       `json:"progress"`
+	Message    string    `json:"message,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Reporter publishes progress for one analysis.
+type Reporter func(stage Stage, progress int, message string)
+
+// Discard is a Reporter that drops every event.
+func Discard(Stage, int, string) {}
+
+const (
+	// historySize is how many events are replayed to a new subscriber.
+	historySize = 32
+	// subscriberBuffer is how many events a slow subscriber may fall behind
+	// before further events are dropped for it.
+	subscriberBuffer = 64
+)
+
+// Broker routes events by analysis ID.
+type Broker struct {
+	// Retention is how long the events of a finished analysis are kept.
+	Retention time.Duration
+
+	mu       sync.Mutex
+	analyses map[string]*analysis
+}
+
+type analysis struct {
+	history     []Event
+	subscribers map[chan Event]struct{}
+	finished    bool
+}
+
+// NewBroker creates a Broker that keeps finished analyses for retention.
+func NewBroker(retention time.Duration) *Broker {
+	return &Broker{Retention: retention, analyses: map[string]*analysis{}}
+}
+
+// Reporter returns a Reporter publishing under id. An empty id, or a nil
+// Broker, gives a Reporter that discards events.
+func (b *Broker) Reporter(id string) Reporter {
+	if b == nil || id == "" {
+		return Discard
+	}
+	return func(stage Stage, progress int, message string) {
+		b.Publish(id, stage, progress, message)
+	}
+}
+
+// Publish sends an event to the subscribers of an analysis. After a final
+// stage the subscribers' channels are closed and the analysis is forgotten
+// once Retention has passed.
+func (b *Broker) Publish(id string, stage Stage, progress int, message string) {
+	if b == nil || id == "" {
+		return
+	}
+	event := Event{AnalysisID: id, Stage: stage, Progress: progress, Message: message, Time: time.Now()}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	a := b.get(id)
+	if a.finished {
+		// A new run under the same ID starts a fresh history.
+		a.history = nil
+		a.finished = false
+	}
+	a.history = append(a.history, event)
+	if len(a.history) > historySize {
+		a.history = a.history[len(a.history)-historySize:]
+	}
+	for ch := range a.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// The subscriber isn't keeping up; skip this update for it.
+		}
+	}
+
+	if stage.Final() {
+		a.finished = true
+		for ch := range a.subscribers {
+			close(ch)
+			delete(a.subscribers, ch)
+		}
+		time.AfterFunc(b.Retention, func() { b.expire(id, a) })
+	}
+}
+
+// Subscribe returns a channel receiving the recent events of an analysis
+// followed by new ones as they are published. The channel is closed after a
+// final event. Call the returned function to unsubscribe.
+func (b *Broker) Subscribe(id string) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	a := b.get(id)
+	ch := make(chan Event, subscriberBuffer+historySize)
+	for _, event := range a.history {
+		ch <- event
+	}
+	if a.finished {
+		close(ch)
+		return ch, func() {}
+	}
+	a.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := a.subscribers[ch]; ok {
+			delete(a.subscribers, ch)
+			close(ch)
+		}
+		if len(a.subscribers) == 0 && len(a.history) == 0 && b.analyses[id] == a {
+			delete(b.analyses, id)
+		}
+	}
+}
+
+// get returns the state of an analysis, creating it if needed. The caller
+// must hold b.mu.
+func (b *Broker) get(id string) *analysis {
+	a, ok := b.analyses[id]
+	if !ok {
+		a = &analysis{subscribers: map[chan Event]struct{}{}}
+		b.analyses[id] = a
+	}
+	return a
+}
+
+// expire forgets a finished analysis unless it has been restarted since.
+func (b *Broker) expire(id string, a *analysis) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.analyses[id] == a && a.finished && len(a.subscribers) == 0 {
+		delete(b.analyses, id)
+	}
+}
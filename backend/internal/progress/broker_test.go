@@ -0,0 +1,146 @@
+// internal/progress/broker_test.go
+
+package progress
+
+import (
+	"testing"
+	"time"
+)
+
+// drain collects the events of a channel until it is closed.
+func drain(t *testing.T, ch <-chan Event) []Event {
+	t.Helper()
+	var events []Event
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		case <-timeout:
+			t.Fatalf("channel wasn't closed; got %d events", len(events))
+		}
+	}
+}
+
+func stages(events []Event) []Stage {
+	out := make([]Stage, len(events))
+	for i, e := range events {
+		out[i] = e.Stage
+	}
+	return out
+}
+
+func equalStages(a, b []Stage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubscribeReceivesLiveEvents(t *testing.T) {
+	b := NewBroker(time.Minute)
+	ch, unsubscribe := b.Subscribe("a")
+	defer unsubscribe()
+
+	report := b.Reporter("a")
+	report(StageFetching, 10, "fetching")
+	report(StageRendering, 90, "rendering")
+	report(StageDone, 100, "done")
+
+	events := drain(t, ch)
+	want := []Stage{StageFetching, StageRendering, StageDone}
+	if !equalStages(stages(events), want) {
+		t.Fatalf("got stages %v, want %v", stages(events), want)
+	}
+	if events[0].AnalysisID != "a" || events[0].Progress != 10 || events[0].Message != "fetching" {
+		t.Errorf("first event = %+v", events[0])
+	}
+}
+
+func TestLateSubscriberGetsHistory(t *testing.T) {
+	tests := []struct {
+		name      string
+		published []Stage
+		want      []Stage
+	}{
+		{"finished", []Stage{StageQueued, StageFetching, StageError}, []Stage{StageQueued, StageFetching, StageError}},
+		{"restarted after finishing", []Stage{StageFetching, StageDone, StageQueued}, []Stage{StageQueued}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker(time.Minute)
+			for _, stage := range tt.published {
+				b.Publish("a", stage, 0, "")
+			}
+			ch, unsubscribe := b.Subscribe("a")
+			if tt.want[len(tt.want)-1].Final() {
+				if got := stages(drain(t, ch)); !equalStages(got, tt.want) {
+					t.Errorf("got stages %v, want %v", got, tt.want)
+				}
+				return
+			}
+			unsubscribe()
+			if got := stages(drain(t, ch)); !equalStages(got, tt.want) {
+				t.Errorf("got stages %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	b := NewBroker(time.Minute)
+	for i := 0; i < historySize+10; i++ {
+		b.Publish("a", StageFetching, i, "")
+	}
+	b.Publish("a", StageDone, 100, "")
+	events := drain(t, mustSubscribe(b, "a"))
+	if len(events) != historySize {
+		t.Fatalf("replayed %d events, want %d", len(events), historySize)
+	}
+	if last := events[len(events)-1]; last.Stage != StageDone {
+		t.Errorf("last replayed event = %+v, want the done event", last)
+	}
+}
+
+func TestFinishedAnalysesExpire(t *testing.T) {
+	b := NewBroker(10 * time.Millisecond)
+	b.Publish("a", StageDone, 100, "")
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mu.Lock()
+		_, ok := b.analyses["a"]
+		b.mu.Unlock()
+		if !ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("finished analysis was never forgotten")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDiscardingReporters(t *testing.T) {
+	var nilBroker *Broker
+	nilBroker.Reporter("a")(StageDone, 100, "")
+	nilBroker.Publish("a", StageDone, 100, "")
+
+	b := NewBroker(time.Minute)
+	b.Reporter("")(StageFetching, 1, "")
+	if len(b.analyses) != 0 {
+		t.Errorf("an empty ID created %d analyses", len(b.analyses))
+	}
+}
+
+func mustSubscribe(b *Broker, id string) <-chan Event {
+	ch, _ := b.Subscribe(id)
+	return ch
+}
@@ -0,0 +1,63 @@
+// internal/storage/geojson.go
+
+package storage
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EventProperties are the non-spatial fields of a change event as they appear
+// in a GeoJSON feature.
+type EventProperties struct {
+	LocationID    int       `json:"location_id"`
+	EventType     string    `json:"event_type"`
+	Description   string    `json:"description"`
+	DetectedAt    time.Time `json:"detected_at"`
+	Severity      int       `json:"severity"`
+	AreaSqM       *float64  `json:"area_sq_m,omitempty"`
+	MeanMagnitude *float64  `json:"mean_magnitude,omitempty"`
+	// Pipeline is the processing pipeline the event's images went through.
+	Pipeline json.RawMessage `json:"pipeline,omitempty"`
+}
+
+// EventFeature is a change event as a GeoJSON Feature.
+type EventFeature struct {
+	Type       string          `json:"type"`
+	ID         int             `json:"id"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties EventProperties `json:"properties"`
+}
+
+// EventFeatureCollection is a GeoJSON FeatureCollection of change events.
+type EventFeatureCollection struct {
+	Type     string         `json:"type"`
+	Features []EventFeature `json:"features"`
+}
+
+// ToFeatureCollection converts loaded events to GeoJSON, embedding each
+// geometry as a JSON object rather than an escaped string.
+func ToFeatureCollection(events []ChangeEventWithGeom) EventFeatureCollection {
+	collection := EventFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]EventFeature, 0, len(events)),
+	}
+	for _, e := range events {
+		collection.Features = append(collection.Features, EventFeature{
+			Type:     "Feature",
+			ID:       e.ID,
+			Geometry: json.RawMessage(e.GeoJSON),
+			Properties: EventProperties{
+				LocationID:    e.LocationID,
+				EventType:     e.EventType,
+				Description:   e.Description,
+				DetectedAt:    e.DetectedAt,
+				Severity:      e.Severity,
+				AreaSqM:       e.AreaSqM,
+				MeanMagnitude: e.MeanMagnitude,
+				Pipeline:      e.Pipeline,
+			},
+		})
+	}
+	return collection
+}
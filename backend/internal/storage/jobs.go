@@ -0,0 +1,166 @@
+// internal/storage/jobs.go
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrJobNotFound is returned when no job exists with the requested ID.
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStatus is the lifecycle state of an analysis job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is an analysis job as stored in the analysis_jobs table.
+type Job struct {
+	ID       string          `json:"id"`
+	Status   JobStatus       `json:"status"`
+	Progress int             `json:"progress"`
+	Message  string          `json:"message,omitempty"`
+	Request  json.RawMessage `json:"request"`
+	// ResultPath is where the finished result is stored on disk. It is not
+	// exposed to clients, who download the result through the API instead.
+	ResultPath  string     `json:"-"`
+	ContentType string     `json:"content_type,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+const jobColumns = `id, status, progress, COALESCE(message, ''), request, COALESCE(result_path, ''),
+	COALESCE(content_type, ''), COALESCE(error, ''), created_at, updated_at, started_at, finished_at`
+
+func scanJob(row pgx.Row) (*Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.Status,
+		&job.Progress,
+		&job.Message,
+		&job.Request,
+		&job.ResultPath,
+		&job.ContentType,
+		&job.Error,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CreateJob inserts a new queued job.
+func CreateJob(ctx context.Context, pool *pgxpool.Pool, id string, request json.RawMessage) (*Job, error) {
+	query := `
+		INSERT INTO analysis_jobs (id, status, progress, request, created_at, updated_at)
+		VALUES ($1, $2, 0, $3, NOW(), NOW())
+		RETURNING ` + jobColumns
+	job, err := scanJob(pool.QueryRow(ctx, query, id, JobQueued, request))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert analysis job: %w", err)
+	}
+	return job, nil
+}
+
+// GetJob loads a job by ID, returning ErrJobNotFound if it doesn't exist.
+func GetJob(ctx context.Context, pool *pgxpool.Pool, id string) (*Job, error) {
+	job, err := scanJob(pool.QueryRow(ctx, `SELECT `+jobColumns+` FROM analysis_jobs WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load analysis job %s: %w", id, err)
+	}
+	return job, nil
+}
+
+// ListUnfinishedJobIDs returns the IDs of jobs that are queued or were still
+// running when the server stopped, oldest first, so they can be resumed.
+func ListUnfinishedJobIDs(ctx context.Context, pool *pgxpool.Pool) ([]string, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id FROM analysis_jobs
+		WHERE status IN ($1, $2)
+		ORDER BY created_at`, JobQueued, JobRunning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unfinished analysis jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan analysis job id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// MarkJobRunning moves a job to the running state.
+func MarkJobRunning(ctx context.Context, pool *pgxpool.Pool, id string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE analysis_jobs
+		SET status = $2, progress = 0, message = NULL, error = NULL, started_at = NOW(), updated_at = NOW()
+		WHERE id = $1`, id, JobRunning)
+	if err != nil {
+		return fmt.Errorf("failed to mark analysis job %s as running: %w", id, err)
+	}
+	return nil
+}
+
+// UpdateJobProgress records the progress (0-100) and a short status message.
+func UpdateJobProgress(ctx context.Context, pool *pgxpool.Pool, id string, progress int, message string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE analysis_jobs SET progress = $2, message = $3, updated_at = NOW()
+		WHERE id = $1`, id, progress, message)
+	if err != nil {
+		return fmt.Errorf("failed to update progress of analysis job %s: %w", id, err)
+	}
+	return nil
+}
+
+// CompleteJob marks a job as succeeded and records where its result is stored.
+func CompleteJob(ctx context.Context, pool *pgxpool.Pool, id, resultPath, contentType string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE analysis_jobs
+		SET status = $2, progress = 100, message = NULL, result_path = $3, content_type = $4,
+			finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1`, id, JobSucceeded, resultPath, contentType)
+	if err != nil {
+		return fmt.Errorf("failed to complete analysis job %s: %w", id, err)
+	}
+	return nil
+}
+
+// FailJob marks a job as failed with the given error message.
+func FailJob(ctx context.Context, pool *pgxpool.Pool, id, errorMessage string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE analysis_jobs
+		SET status = $2, error = $3, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1`, id, JobFailed, errorMessage)
+	if err != nil {
+		return fmt.Errorf("failed to mark analysis job %s as failed: %w", id, err)
+	}
+	return nil
+}
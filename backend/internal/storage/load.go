@@ -2,7 +2,11 @@ package storage
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -17,30 +21,156 @@ type ChangeEventWithGeom struct {
 	Description string    `json:"description"`
 	DetectedAt  time.Time `json:"detected_at"`
 	Severity    int       `json:"severity"`
+	// AreaSqM and MeanMagnitude are only set for events saved from vectorized
+	// change regions; whole-scene events leave them empty.
+	AreaSqM       *float64 `json:"area_sq_m,omitempty"`
+	MeanMagnitude *float64 `json:"mean_magnitude,omitempty"`
+	// Pipeline is the processing pipeline the event's images went through.
+	Pipeline json.RawMessage `json:"pipeline,omitempty"`
 	// We'll read the geometry as GeoJSON, which is very frontend-friendly.
 	GeoJSON string `json:"geom_geojson"`
 }
 
-// LoadChangeEventsInBBox finds all change events whose saved geometry intersects
-// with the provided bounding box.
-func LoadChangeEventsInBBox(pool *pgxpool.Pool, bbox []float64) ([]ChangeEventWithGeom, error) {
-	// This query finds events where the event's geometry (`geom`) intersects with
-	// a new polygon (`query_geom`) that we create from the user's request bbox.
+// DefaultEventLimit and MaxEventLimit bound how many events one query returns.
+const (
+	DefaultEventLimit = 100
+	MaxEventLimit     = 1000
+)
+
+// EventFilter narrows down which change events are loaded. Zero values mean
+// "no restriction" for every field.
+type EventFilter struct {
+	// From and To bound detected_at (From inclusive, To exclusive).
+	From *time.Time
+	To   *time.Time
+	// EventTypes keeps only events of one of these types.
+	EventTypes []string
+	// MinSeverity keeps only events with at least this severity.
+	MinSeverity *int
+	// LocationID keeps only events of this location.
+	LocationID *int
+	// Intersects is a GeoJSON geometry the event geometry must intersect.
+	Intersects json.RawMessage
+	// Limit is the page size, DefaultEventLimit if zero.
+	Limit int
+	// After continues a previous page (see EventCursor).
+	After *EventCursor
+}
+
+// EventCursor marks the last event of a page. Events are ordered newest first,
+// so the next page holds events strictly before this (detected_at, id) pair.
+type EventCursor struct {
+	DetectedAt time.Time
+	ID         int
+}
+
+// Encode returns the cursor as an opaque URL-safe token.
+func (c EventCursor) Encode() string {
+	raw := c.DetectedAt.UTC().Format(time.RFC3339Nano) + "|" + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeEventCursor parses a token produced by EventCursor.Encode.
+func DecodeEventCursor(token string) (*EventCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	at, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	detectedAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &EventCursor{DetectedAt: detectedAt, ID: id}, nil
+}
+
+// eventConditions builds the WHERE conditions and arguments for a bbox (which
+// may be nil) and filter. Every value is passed as a query parameter.
+func eventConditions(bbox []float64, filter EventFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(bbox) == 4 {
+		conditions = append(conditions, fmt.Sprintf("ST_Intersects(geom, ST_MakeEnvelope(%s, %s, %s, %s, 4326))",
+			arg(bbox[0]), arg(bbox[1]), arg(bbox[2]), arg(bbox[3])))
+	}
+	if len(filter.Intersects) > 0 {
+		conditions = append(conditions, fmt.Sprintf("ST_Intersects(geom, ST_SetSRID(ST_GeomFromGeoJSON(%s), 4326))",
+			arg(string(filter.Intersects))))
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "detected_at >= "+arg(*filter.From))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "detected_at < "+arg(*filter.To))
+	}
+	if len(filter.EventTypes) > 0 {
+		conditions = append(conditions, "event_type = ANY("+arg(filter.EventTypes)+")")
+	}
+	if filter.MinSeverity != nil {
+		conditions = append(conditions, "severity >= "+arg(*filter.MinSeverity))
+	}
+	if filter.LocationID != nil {
+		conditions = append(conditions, "location_id = "+arg(*filter.LocationID))
+	}
+	if filter.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(detected_at, id) < (%s, %s)",
+			arg(filter.After.DetectedAt), arg(filter.After.ID)))
+	}
+	return conditions, args
+}
+
+// whereClause joins conditions into a WHERE clause, or returns "" if there are none.
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+// LoadChangeEventsInBBox finds the change events whose saved geometry intersects
+// with the provided bounding box and match the filter, newest first. A nil bbox
+// doesn't restrict the area. When more events are available than the page
+// size, a cursor for the next page is returned as well.
+func LoadChangeEventsInBBox(pool *pgxpool.Pool, bbox []float64, filter EventFilter) ([]ChangeEventWithGeom, *EventCursor, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultEventLimit
+	}
+	if limit > MaxEventLimit {
+		limit = MaxEventLimit
+	}
+
+	conditions, args := eventConditions(bbox, filter)
+	args = append(args, limit+1)
 	// ST_AsGeoJSON converts the geometry into a JSON string, perfect for APIs.
-	query := `
-		SELECT id, location_id, event_type, description, detected_at, severity, ST_AsGeoJSON(geom)
+	// We ask for one extra row to know whether there is a next page.
+	query := fmt.Sprintf(`
+		SELECT id, location_id, event_type, description, detected_at, severity, area_sq_m, mean_magnitude, pipeline, ST_AsGeoJSON(geom)
 		FROM change_events
-		WHERE ST_Intersects(geom, ST_MakeEnvelope($1, $2, $3, $4, 4326))
-		ORDER BY detected_at DESC;
-	`
+		%s
+		ORDER BY detected_at DESC, id DESC
+		LIMIT $%d;
+	`, whereClause(conditions), len(args))
 
-	rows, err := pool.Query(context.Background(), query, bbox[0], bbox[1], bbox[2], bbox[3])
+	rows, err := pool.Query(context.Background(), query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute query to load change events: %w", err)
+		return nil, nil, fmt.Errorf("failed to execute query to load change events: %w", wrapGeometryError(err))
 	}
 	defer rows.Close()
 
-	var events []ChangeEventWithGeom
+	events := []ChangeEventWithGeom{}
 
 	// We iterate through all the rows returned by the query.
 	for rows.Next() {
@@ -53,6 +183,9 @@ func LoadChangeEventsInBBox(pool *pgxpool.Pool, bbox []float64) ([]ChangeEventWi
 			&event.Description,
 			&event.DetectedAt,
 			&event.Severity,
+			&event.AreaSqM,
+			&event.MeanMagnitude,
+			&event.Pipeline,
 			&event.GeoJSON,
 		); err != nil {
 			// If one row fails, we log it and continue, so the user still gets partial results.
@@ -63,9 +196,16 @@ func LoadChangeEventsInBBox(pool *pgxpool.Pool, bbox []float64) ([]ChangeEventWi
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error while iterating over event rows: %w", err)
+		return nil, nil, fmt.Errorf("error while iterating over event rows: %w", wrapGeometryError(err))
 	}
-	
+
+	var next *EventCursor
+	if len(events) > limit {
+		events = events[:limit]
+		last := events[len(events)-1]
+		next = &EventCursor{DetectedAt: last.DetectedAt, ID: last.ID}
+	}
+
 	fmt.Printf("INFO: Loaded %d change events from the database.\n", len(events))
-	return events, nil
-}	
\ No newline at end of file
+	return events, next, nil
+}
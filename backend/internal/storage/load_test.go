@@ -0,0 +1,67 @@
+// internal/storage/load_test.go
+
+package storage
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor EventCursor
+	}{
+		{"whole seconds", EventCursor{DetectedAt: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), ID: 1}},
+		{"nanoseconds", EventCursor{DetectedAt: time.Date(2024, 6, 1, 12, 0, 0, 123456789, time.UTC), ID: 42}},
+		{"other time zone", EventCursor{DetectedAt: time.Date(2023, 1, 2, 3, 4, 5, 0, time.FixedZone("EAT", 3*3600)), ID: 987654}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.cursor.Encode()
+			if strings.ContainsAny(token, "+/=") {
+				t.Errorf("token %q isn't URL-safe", token)
+			}
+			got, err := DecodeEventCursor(token)
+			if err != nil {
+				t.Fatalf("DecodeEventCursor(%q) failed: %v", token, err)
+			}
+			if !got.DetectedAt.Equal(tt.cursor.DetectedAt) || got.ID != tt.cursor.ID {
+				t.Errorf("DecodeEventCursor(%q) = %+v, want %+v", token, got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeEventCursorRejectsInvalidTokens(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"not base64", "%%%"},
+		{"no separator", encode("2024-06-01T12:00:00Z")},
+		{"bad time", encode("yesterday|5")},
+		{"bad id", encode("2024-06-01T12:00:00Z|five")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := DecodeEventCursor(tt.token); err == nil {
+				t.Errorf("DecodeEventCursor(%q) = %+v, want an error", tt.token, got)
+			}
+		})
+	}
+}
+
+func TestEventConditionsCursor(t *testing.T) {
+	after := &EventCursor{DetectedAt: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), ID: 7}
+	conditions, args := eventConditions(nil, EventFilter{After: after})
+	if len(conditions) != 1 || conditions[0] != "(detected_at, id) < ($1, $2)" {
+		t.Fatalf("eventConditions() = %v, want the keyset condition", conditions)
+	}
+	if len(args) != 2 || args[0] != after.DetectedAt || args[1] != after.ID {
+		t.Errorf("eventConditions() args = %v, want [%v %v]", args, after.DetectedAt, after.ID)
+	}
+}
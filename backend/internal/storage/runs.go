@@ -0,0 +1,120 @@
+// internal/storage/runs.go
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrRunNotFound is returned when no analysis run exists with the requested ID.
+var ErrRunNotFound = errors.New("analysis run not found")
+
+// ErrRunExists is returned when reserving or saving a run under an ID that
+// another analysis already uses.
+var ErrRunExists = errors.New("analysis run already exists")
+
+// AnalysisRun records a served analysis as stored in the analysis_runs table.
+type AnalysisRun struct {
+	ID          string          `json:"id"`
+	Request     json.RawMessage `json:"request"`
+	ContentType string          `json:"content_type"`
+	CacheHit    bool            `json:"cache_hit"`
+	// JobID is the job that produced the run, empty for POST /changes.
+	JobID string `json:"job_id,omitempty"`
+	// Metadata is the analysis service's description of the result, or
+	// null for results produced before it sent one.
+	Metadata  json.RawMessage `json:"metadata"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+const runColumns = `id, request, content_type, cache_hit, COALESCE(job_id, ''), metadata, created_at`
+
+// ReserveAnalysisID claims id for an analysis that is about to run by
+// inserting a placeholder run, without a content type, that SaveAnalysisRun
+// completes. The insert itself decides between concurrent requests for the
+// same ID: all but one fail with ErrRunExists, as do IDs of existing jobs
+// (which the server generates, so they exist before anyone can know them).
+func ReserveAnalysisID(ctx context.Context, pool *pgxpool.Pool, id string, request json.RawMessage) error {
+	query := `
+		INSERT INTO analysis_runs (id, request, content_type, created_at)
+		SELECT $1, $2, '', NOW()
+		WHERE NOT EXISTS (SELECT 1 FROM analysis_jobs WHERE id = $1)
+		ON CONFLICT (id) DO NOTHING`
+	tag, err := pool.Exec(ctx, query, id, request)
+	if err != nil {
+		return fmt.Errorf("failed to reserve analysis id %s: %w", id, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to reserve analysis id %s: %w", id, ErrRunExists)
+	}
+	return nil
+}
+
+// ReleaseAnalysisID removes the placeholder of an analysis that failed, so
+// its ID can be used again. Completed runs are left alone.
+func ReleaseAnalysisID(ctx context.Context, pool *pgxpool.Pool, id string) error {
+	if _, err := pool.Exec(ctx, `DELETE FROM analysis_runs WHERE id = $1 AND content_type = ''`, id); err != nil {
+		return fmt.Errorf("failed to release analysis id %s: %w", id, err)
+	}
+	return nil
+}
+
+// SaveAnalysisRun records a run. A run of POST /changes completes the
+// placeholder left by ReserveAnalysisID, and a job saving its run again, as a
+// resumed job does, replaces its earlier record; any other run whose ID is
+// already taken fails with ErrRunExists.
+func SaveAnalysisRun(ctx context.Context, pool *pgxpool.Pool, run *AnalysisRun) error {
+	var metadata, jobID interface{}
+	if len(run.Metadata) > 0 {
+		metadata = run.Metadata
+	}
+	if run.JobID != "" {
+		jobID = run.JobID
+	}
+	query := `
+		INSERT INTO analysis_runs (id, request, content_type, cache_hit, job_id, metadata, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (id) DO UPDATE
+		SET request = EXCLUDED.request, content_type = EXCLUDED.content_type,
+			cache_hit = EXCLUDED.cache_hit, metadata = EXCLUDED.metadata, created_at = EXCLUDED.created_at
+		WHERE analysis_runs.job_id = EXCLUDED.job_id
+			OR (analysis_runs.job_id IS NULL AND EXCLUDED.job_id IS NULL AND analysis_runs.content_type = '')
+		RETURNING created_at`
+	err := pool.QueryRow(ctx, query, run.ID, run.Request, run.ContentType, run.CacheHit, jobID, metadata).Scan(&run.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to save analysis run %s: %w", run.ID, ErrRunExists)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save analysis run %s: %w", run.ID, err)
+	}
+	return nil
+}
+
+// GetAnalysisRun loads a run by ID, returning ErrRunNotFound if it doesn't
+// exist or is still running.
+func GetAnalysisRun(ctx context.Context, pool *pgxpool.Pool, id string) (*AnalysisRun, error) {
+	var run AnalysisRun
+	err := pool.QueryRow(ctx, `SELECT `+runColumns+` FROM analysis_runs WHERE id = $1 AND content_type <> ''`, id).Scan(
+		&run.ID,
+		&run.Request,
+		&run.ContentType,
+		&run.CacheHit,
+		&run.JobID,
+		&run.Metadata,
+		&run.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load analysis run %s: %w", id, err)
+	}
+	return &run, nil
+}
@@ -4,10 +4,13 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"image"
 	"time"
 
+	"geowatch-backend/internal/detection"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -18,9 +21,21 @@ type ChangeEvent struct {
 	Description string    // A summary of the event
 	DetectedAt  time.Time // When the analysis was run
 	Severity    int       // A measure of the change, e.g., number of changed pixels
+	// Pipeline records the processing pipeline the images went through, or
+	// is nil when there was none.
+	Pipeline json.RawMessage
 	// The GEOMETRY will be handled by PostGIS functions in the SQL query
 }
 
+// pipelineValue returns the value bound to the pipeline column: NULL when
+// the event has no pipeline.
+func (e ChangeEvent) pipelineValue() interface{} {
+	if len(e.Pipeline) == 0 {
+		return nil
+	}
+	return e.Pipeline
+}
+
 // SaveChangeEvent saves a detected change event to the database.
 // It takes the database connection pool and the event details.
 // bbox is the bounding box used for the analysis, which we'll save as the event's geometry.
@@ -30,8 +45,8 @@ func SaveChangeEvent(pool *pgxpool.Pool, event ChangeEvent, bbox []float64) (int
 	// ST_SetSRID sets the spatial reference system (4326 is standard WGS84 lat/lon).
 	// `RETURNING id` gives us back the ID of the newly created row.
 	query := `
-		INSERT INTO change_events (location_id, event_type, description, detected_at, severity, geom)
-		VALUES ($1, $2, $3, $4, $5, ST_SetSRID(ST_MakeEnvelope($6, $7, $8, $9), 4326))
+		INSERT INTO change_events (location_id, event_type, description, detected_at, severity, pipeline, geom)
+		VALUES ($1, $2, $3, $4, $5, $6, ST_SetSRID(ST_MakeEnvelope($7, $8, $9, $10), 4326))
 		RETURNING id;
 	`
 
@@ -45,6 +60,7 @@ func SaveChangeEvent(pool *pgxpool.Pool, event ChangeEvent, bbox []float64) (int
 		event.Description,
 		event.DetectedAt,
 		event.Severity,
+		event.pipelineValue(),
 		bbox[0], // min Longitude
 		bbox[1], // min Latitude
 		bbox[2], // max Longitude
@@ -59,6 +75,63 @@ func SaveChangeEvent(pool *pgxpool.Pool, event ChangeEvent, bbox []float64) (int
 	return eventID, nil
 }
 
+// SaveChangeRegions saves one change event per vectorized change region, using
+// the region's polygon as the event geometry. Severity is set to the region's
+// pixel count, and its area and mean change magnitude are stored alongside.
+// All events are written in a single transaction; the new IDs are returned in
+// the same order as the regions.
+func SaveChangeRegions(pool *pgxpool.Pool, event ChangeEvent, regions []detection.ChangeRegion) ([]int, error) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction for change regions: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback(ctx)
+
+	// ST_GeomFromGeoJSON parses the polygon; ST_SetSRID tags it as WGS84.
+	query := `
+		INSERT INTO change_events (location_id, event_type, description, detected_at, severity, area_sq_m, mean_magnitude, pipeline, geom)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, ST_SetSRID(ST_GeomFromGeoJSON($9), 4326))
+		RETURNING id;
+	`
+
+	ids := make([]int, 0, len(regions))
+	for i, region := range regions {
+		geojson, err := region.Polygon.MarshalGeoJSON()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode change region %d: %w", i+1, err)
+		}
+		description := fmt.Sprintf("%s (region %d of %d, %.0f m²)", event.Description, i+1, len(regions), region.AreaSqM)
+
+		var eventID int
+		err = tx.QueryRow(
+			ctx,
+			query,
+			event.LocationID,
+			event.EventType,
+			description,
+			event.DetectedAt,
+			region.PixelCount,
+			region.AreaSqM,
+			region.MeanMagnitude,
+			event.pipelineValue(),
+			string(geojson),
+		).Scan(&eventID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert change region %d into database: %w", i+1, err)
+		}
+		ids = append(ids, eventID)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit change regions: %w", err)
+	}
+
+	fmt.Printf("INFO: Successfully saved %d change region events to the database.\n", len(ids))
+	return ids, nil
+}
+
 // CountChangedPixels is a helper function to calculate a simple severity metric.
 // It counts the number of non-transparent pixels in the difference image.
 func CountChangedPixels(diffImage image.Image) int {
@@ -75,4 +148,4 @@ func CountChangedPixels(diffImage image.Image) int {
 		}
 	}
 	return changedPixels
-}
\ No newline at end of file
+}
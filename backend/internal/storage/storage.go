@@ -4,6 +4,13 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 	// The blank import is used for the side-effect of registering the pgx driver.
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
@@ -18,39 +25,363 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
+// ErrLocationNotFound is returned when no location exists with the requested ID.
+var ErrLocationNotFound = errors.New("location not found")
+
+// ErrInvalidGeometry is returned when PostGIS cannot parse a location geometry.
+var ErrInvalidGeometry = errors.New("invalid geometry")
+
+// ErrNotMonitorable is returned when monitoring is enabled for a location
+// without area, such as a point, whose images would be empty.
+var ErrNotMonitorable = errors.New("only locations with an area can be monitored")
+
+// monitoredAreaConstraint keeps monitoring to locations with an area.
+const monitoredAreaConstraint = "locations_monitored_area_check"
+
 // Location represents the structure of a location in the database.
 type Location struct {
 	ID   int    `json:"id"`
 	Name string `json:"name"`
+	// Geometry is the location's geometry as a GeoJSON object.
+	Geometry json.RawMessage `json:"geometry"`
+	// Scheduled monitoring settings and bookkeeping.
+	MonitorEnabled         bool       `json:"monitor_enabled"`
+	MonitorIntervalSeconds int64      `json:"monitor_interval_seconds"`
+	LastRunAt              *time.Time `json:"last_run_at,omitempty"`
+	LastRunError           *string    `json:"last_run_error,omitempty"`
+	// Pipeline is the processing pipeline declared for the location, or
+	// nil to use the scheduler's default.
+	Pipeline json.RawMessage `json:"pipeline,omitempty"`
 }
 
-// GetLocations retrieves a list of locations from the database.
-func (s *Store) GetLocations(ctx context.Context) ([]Location, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT id, name FROM locations LIMIT 10")
-	if err != nil {
+// Geometry is a geometry supplied by a client, either as WKT or as GeoJSON.
+// Exactly one of the two should be set. Coordinates are WGS84 (EPSG:4326).
+type Geometry struct {
+	WKT     string
+	GeoJSON json.RawMessage
+}
+
+// sqlExpr returns the SQL that turns the query parameter $n into a geometry,
+// along with the value to bind to it.
+func (g Geometry) sqlExpr(n int) (string, interface{}, error) {
+	if string(g.GeoJSON) == "null" {
+		g.GeoJSON = nil
+	}
+	switch {
+	case g.WKT != "" && len(g.GeoJSON) > 0:
+		return "", nil, fmt.Errorf("%w: provide either WKT or GeoJSON, not both", ErrInvalidGeometry)
+	case g.WKT != "":
+		return fmt.Sprintf("ST_GeomFromText($%d, 4326)", n), g.WKT, nil
+	case len(g.GeoJSON) > 0:
+		return fmt.Sprintf("ST_SetSRID(ST_GeomFromGeoJSON($%d), 4326)", n), string(g.GeoJSON), nil
+	default:
+		return "", nil, fmt.Errorf("%w: a WKT or GeoJSON geometry is required", ErrInvalidGeometry)
+	}
+}
+
+// LocationQuery filters and paginates GetLocations.
+type LocationQuery struct {
+	// Name, if set, matches locations whose name contains it (case-insensitive).
+	// It is matched literally, so "%" and "_" are not wildcards.
+	Name   string
+	Limit  int
+	Offset int
+}
+
+const locationColumns = `id, name, ST_AsGeoJSON(geom), monitor_enabled,
+	EXTRACT(EPOCH FROM monitor_interval)::BIGINT, last_run_at, last_run_error, pipeline`
+
+// scanLocation reads a row selected with locationColumns.
+func scanLocation(row interface{ Scan(...interface{}) error }) (*Location, error) {
+	var loc Location
+	var geometry, pipeline []byte
+	if err := row.Scan(&loc.ID, &loc.Name, &geometry, &loc.MonitorEnabled,
+		&loc.MonitorIntervalSeconds, &loc.LastRunAt, &loc.LastRunError, &pipeline); err != nil {
 		return nil, err
 	}
+	loc.Geometry = geometry
+	loc.Pipeline = pipeline
+	return &loc, nil
+}
+
+// GetLocations retrieves a page of locations from the database, ordered by ID,
+// and the total number of locations matching the query.
+func (s *Store) GetLocations(ctx context.Context, q LocationQuery) ([]Location, int, error) {
+	query := `
+		SELECT ` + locationColumns + `, COUNT(*) OVER ()
+		FROM locations
+		WHERE strpos(lower(name), lower($1)) > 0
+		ORDER BY id
+		LIMIT $2 OFFSET $3`
+	rows, err := s.db.QueryContext(ctx, query, q.Name, q.Limit, q.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
 	defer rows.Close()
 
-	var locations []Location
+	locations := []Location{}
+	total := 0
 	for rows.Next() {
 		var loc Location
-		if err := rows.Scan(&loc.ID, &loc.Name); err != nil {
-			return nil, err
+		var geometry, pipeline []byte
+		if err := rows.Scan(&loc.ID, &loc.Name, &geometry, &loc.MonitorEnabled,
+			&loc.MonitorIntervalSeconds, &loc.LastRunAt, &loc.LastRunError, &pipeline, &total); err != nil {
+			return nil, 0, err
 		}
+		loc.Geometry = geometry
+		loc.Pipeline = pipeline
 		locations = append(locations, loc)
 	}
 
-	return locations, nil
+	return locations, total, rows.Err()
+}
+
+// GetLocation retrieves a single location by ID.
+func (s *Store) GetLocation(ctx context.Context, id int) (*Location, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+locationColumns+` FROM locations WHERE id = $1`, id)
+	loc, err := scanLocation(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrLocationNotFound
+	}
+	return loc, err
 }
 
 // CreateLocation inserts a new location into the database using its name and WKT geometry.
 func (s *Store) CreateLocation(ctx context.Context, name, wkt string) (int, error) {
-	query := `INSERT INTO locations (name, geom) VALUES ($1, ST_GeomFromText($2)) RETURNING id`
-	var id int
-	err := s.db.QueryRowContext(ctx, query, name, wkt).Scan(&id)
+	return s.CreateLocationWithGeometry(ctx, name, Geometry{WKT: wkt})
+}
+
+// CreateLocationWithGeometry inserts a new location from a WKT or GeoJSON geometry.
+func (s *Store) CreateLocationWithGeometry(ctx context.Context, name string, geometry Geometry) (int, error) {
+	return s.CreateLocationWithSettings(ctx, name, geometry, LocationSettings{})
+}
+
+// CreateLocationWithSettings inserts a new location together with its
+// monitoring settings and pipeline in a single statement, so a failure leaves
+// nothing behind. Settings that aren't given keep their defaults.
+func (s *Store) CreateLocationWithSettings(ctx context.Context, name string, geometry Geometry, settings LocationSettings) (int, error) {
+	geomSQL, geomValue, err := geometry.sqlExpr(2)
 	if err != nil {
 		return 0, err
 	}
+	columns := []string{"name", "geom"}
+	values := []string{"$1", geomSQL}
+	args := []interface{}{name, geomValue}
+	for _, set := range settings.assignments(&args) {
+		columns = append(columns, set.column)
+		values = append(values, set.value)
+	}
+	query := `INSERT INTO locations (` + strings.Join(columns, ", ") + `) VALUES (` + strings.Join(values, ", ") + `) RETURNING id`
+	var id int
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&id)
+	if err != nil {
+		return 0, wrapGeometryError(err)
+	}
 	return id, nil
-}
\ No newline at end of file
+}
+
+// LocationSettings holds the monitoring settings and processing pipeline of
+// a location; nil fields are left as they are.
+type LocationSettings struct {
+	MonitorEnabled  *bool
+	MonitorInterval *time.Duration
+	// Pipeline is the location's processing pipeline as JSON. Pointing it at
+	// an empty message removes the pipeline.
+	Pipeline *json.RawMessage
+}
+
+// assignment sets one column to an SQL expression.
+type assignment struct {
+	column, value string
+}
+
+// assignments returns the columns to set for the non-nil settings, binding
+// their values as further query parameters after args.
+func (ls LocationSettings) assignments(args *[]interface{}) []assignment {
+	var out []assignment
+	add := func(column, expr string, value interface{}) {
+		*args = append(*args, value)
+		out = append(out, assignment{column: column, value: fmt.Sprintf(expr, len(*args))})
+	}
+	if ls.MonitorEnabled != nil {
+		add("monitor_enabled", "$%d", *ls.MonitorEnabled)
+	}
+	if ls.MonitorInterval != nil {
+		add("monitor_interval", "make_interval(secs => $%d)", ls.MonitorInterval.Seconds())
+	}
+	if ls.Pipeline != nil {
+		var value interface{}
+		if len(*ls.Pipeline) > 0 {
+			value = string(*ls.Pipeline)
+		}
+		add("pipeline", "$%d::jsonb", value)
+	}
+	return out
+}
+
+// LocationUpdate holds the fields to change on a location; nil fields are left as they are.
+type LocationUpdate struct {
+	Name     *string
+	Geometry *Geometry
+	LocationSettings
+}
+
+// UpdateLocation changes the name, geometry, monitoring settings and/or
+// pipeline of a location in a single statement.
+func (s *Store) UpdateLocation(ctx context.Context, id int, update LocationUpdate) error {
+	sets := []string{}
+	args := []interface{}{id}
+	if update.Name != nil {
+		args = append(args, *update.Name)
+		sets = append(sets, fmt.Sprintf("name = $%d", len(args)))
+	}
+	if update.Geometry != nil {
+		geomSQL, geomValue, err := update.Geometry.sqlExpr(len(args) + 1)
+		if err != nil {
+			return err
+		}
+		args = append(args, geomValue)
+		sets = append(sets, "geom = "+geomSQL)
+	}
+	for _, set := range update.assignments(&args) {
+		sets = append(sets, set.column+" = "+set.value)
+	}
+	if len(sets) == 0 {
+		// Nothing to change, but still report unknown IDs.
+		_, err := s.GetLocation(ctx, id)
+		return err
+	}
+
+	query := `UPDATE locations SET ` + strings.Join(sets, ", ") + ` WHERE id = $1`
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return wrapGeometryError(err)
+	}
+	return expectOneRow(res)
+}
+
+// DeleteLocation removes a location. Its change events are removed with it.
+func (s *Store) DeleteLocation(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM locations WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+// expectOneRow turns "no rows affected" into ErrLocationNotFound.
+func expectOneRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLocationNotFound
+	}
+	return nil
+}
+
+// geometryParseErrors are fragments of the messages PostGIS raises when it
+// can't parse WKT or GeoJSON. PostGIS reports them as internal_error (XX000)
+// or invalid_parameter_value (22023), codes that other failures share.
+var geometryParseErrors = []string{
+	"parse error",
+	"geojson",
+	"geometry requires more points",
+	"geometry must have an odd number of points",
+	"geometry contains non-closed rings",
+	"can not mix dimensionality",
+	"invalid endian flag",
+	"unknown wkb type",
+}
+
+// wrapGeometryError reports PostGIS geometry parsing failures as
+// ErrInvalidGeometry, and monitoring of locations without area as
+// ErrNotMonitorable.
+func wrapGeometryError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	if pgErr.Code == "23514" && pgErr.ConstraintName == monitoredAreaConstraint {
+		return ErrNotMonitorable
+	}
+	if pgErr.Code == "XX000" || pgErr.Code == "22023" {
+		message := strings.ToLower(pgErr.Message)
+		for _, fragment := range geometryParseErrors {
+			if strings.Contains(message, fragment) {
+				return fmt.Errorf("%w: %s", ErrInvalidGeometry, pgErr.Message)
+			}
+		}
+	}
+	return err
+}
+
+// MonitoredLocation is a location that the scheduler watches for changes.
+type MonitoredLocation struct {
+	ID   int
+	Name string
+	// BBox is the bounding box of the location's geometry.
+	BBox []float64
+	// Interval is how often the location should be analysed.
+	Interval time.Duration
+	// LastRunAt is when the scheduler last analysed the location.
+	LastRunAt *time.Time
+	// BaselineDate is the acquisition date of the image used as the baseline
+	// for the next comparison.
+	BaselineDate *time.Time
+	// Geometry is the location's geometry as GeoJSON.
+	Geometry json.RawMessage
+	// Pipeline is the location's processing pipeline, nil if it has none.
+	Pipeline json.RawMessage
+}
+
+// ListDueLocations returns monitored locations whose next run is due at now.
+func (s *Store) ListDueLocations(ctx context.Context, now time.Time) ([]MonitoredLocation, error) {
+	query := `
+		SELECT id, name,
+			ST_XMin(geom), ST_YMin(geom), ST_XMax(geom), ST_YMax(geom),
+			EXTRACT(EPOCH FROM monitor_interval)::BIGINT, last_run_at, baseline_date,
+			ST_AsGeoJSON(geom), pipeline
+		FROM locations
+		WHERE monitor_enabled
+			AND (last_run_at IS NULL OR last_run_at + monitor_interval <= $1)
+		ORDER BY last_run_at NULLS FIRST, id`
+	rows, err := s.db.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locations []MonitoredLocation
+	for rows.Next() {
+		var loc MonitoredLocation
+		var minLon, minLat, maxLon, maxLat float64
+		var intervalSeconds int64
+		var geometry, pipeline []byte
+		if err := rows.Scan(&loc.ID, &loc.Name, &minLon, &minLat, &maxLon, &maxLat,
+			&intervalSeconds, &loc.LastRunAt, &loc.BaselineDate, &geometry, &pipeline); err != nil {
+			return nil, err
+		}
+		loc.Geometry = geometry
+		loc.Pipeline = pipeline
+		loc.BBox = []float64{minLon, minLat, maxLon, maxLat}
+		loc.Interval = time.Duration(intervalSeconds) * time.Second
+		locations = append(locations, loc)
+	}
+	return locations, rows.Err()
+}
+
+// RecordLocationRun stores the outcome of a scheduled run. baselineDate is the
+// acquisition date of the newest image, which becomes the next baseline; it is
+// left unchanged when nil. runErr is empty for successful runs.
+func (s *Store) RecordLocationRun(ctx context.Context, id int, runAt time.Time, baselineDate *time.Time, runErr string) error {
+	query := `
+		UPDATE locations
+		SET last_run_at = $2,
+			baseline_date = COALESCE($3, baseline_date),
+			last_run_error = NULLIF($4, '')
+		WHERE id = $1`
+	_, err := s.db.ExecContext(ctx, query, id, runAt, baselineDate, runErr)
+	return err
+}
@@ -0,0 +1,41 @@
+// internal/storage/storage_test.go
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestWrapGeometryError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"WKT parse error", &pgconn.PgError{Code: "XX000", Message: "parse error - invalid geometry"}, ErrInvalidGeometry},
+		{"unknown GeoJSON type", &pgconn.PgError{Code: "XX000", Message: "unknown GeoJSON type"}, ErrInvalidGeometry},
+		{"unclosed ring", &pgconn.PgError{Code: "XX000", Message: "geometry contains non-closed rings"}, ErrInvalidGeometry},
+		{"wrapped", fmt.Errorf("query failed: %w", &pgconn.PgError{Code: "22023", Message: "Invalid GeoJSON representation"}), ErrInvalidGeometry},
+		{"other internal error", &pgconn.PgError{Code: "XX000", Message: "lwgeom_intersection: GEOS Error: TopologyException"}, nil},
+		{"other invalid parameter", &pgconn.PgError{Code: "22023", Message: "invalid value for parameter \"TimeZone\""}, nil},
+		{"monitored point", &pgconn.PgError{Code: "23514", ConstraintName: monitoredAreaConstraint}, ErrNotMonitorable},
+		{"other check", &pgconn.PgError{Code: "23514", ConstraintName: "analysis_jobs_progress_check"}, nil},
+		{"not from Postgres", errors.New("connection refused"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrapGeometryError(tt.err)
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("wrapGeometryError() = %v, want the error unchanged", got)
+				}
+			} else if !errors.Is(got, tt.want) {
+				t.Errorf("wrapGeometryError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// internal/storage/tiles.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// EventTileLayer is the name of the layer holding change events in the
+// vector tiles produced by LoadEventTile.
+const EventTileLayer = "change_events"
+
+// MaxTileZoom is the deepest zoom level tiles are served for.
+const MaxTileZoom = 22
+
+// ErrTileOutOfRange is returned for tile coordinates that don't exist.
+var ErrTileOutOfRange = errors.New("tile coordinates out of range")
+
+// tileExtent and tileBuffer are the tile resolution and the margin (both in
+// tile units) used when clipping geometries, as recommended for ST_AsMVTGeom.
+const (
+	tileExtent = 4096
+	tileBuffer = 64
+)
+
+// LoadEventTile renders the change events inside Web Mercator tile z/x/y as a
+// Mapbox Vector Tile. Only the filter's time, type, severity, location and
+// intersects conditions apply; paging fields are ignored. An empty slice means
+// no event falls in the tile.
+func LoadEventTile(ctx context.Context, pool *pgxpool.Pool, z, x, y int, filter EventFilter) ([]byte, error) {
+	if z < 0 || z > MaxTileZoom {
+		return nil, fmt.Errorf("%w: zoom must be between 0 and %d", ErrTileOutOfRange, MaxTileZoom)
+	}
+	if n := 1 << z; x < 0 || x >= n || y < 0 || y >= n {
+		return nil, fmt.Errorf("%w: no tile %d/%d/%d", ErrTileOutOfRange, z, x, y)
+	}
+
+	filter.Limit = 0
+	filter.After = nil
+	conditions, args := eventConditions(nil, filter)
+	args = append(args, z, x, y)
+	n := len(args)
+	// Events are stored in EPSG:4326, so the tile envelope is transformed once
+	// to use the geometry index, and only the matching rows are projected.
+	conditions = append(conditions, "ST_Intersects(geom, ST_Transform(bounds.env, 4326))")
+
+	query := fmt.Sprintf(`
+		WITH bounds AS (
+			SELECT ST_TileEnvelope($%d, $%d, $%d) AS env
+		),
+		tile AS (
+			SELECT id, location_id, event_type, description,
+				to_char(detected_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"') AS detected_at,
+				severity, area_sq_m, mean_magnitude,
+				ST_AsMVTGeom(ST_Transform(geom, 3857), bounds.env, %d, %d, true) AS mvt_geom
+			FROM change_events, bounds
+			%s
+		)
+		SELECT COALESCE(ST_AsMVT(tile.*, '%s', %d, 'mvt_geom', 'id'), ''::bytea)
+		FROM tile
+		WHERE mvt_geom IS NOT NULL;
+	`, n-2, n-1, n, tileExtent, tileBuffer, whereClause(conditions), EventTileLayer, tileExtent)
+
+	var data []byte
+	if err := pool.QueryRow(ctx, query, args...).Scan(&data); err != nil {
+		return nil, fmt.Errorf("failed to render event tile %d/%d/%d: %w", z, x, y, wrapGeometryError(err))
+	}
+	return data, nil
+}
@@ -0,0 +1,264 @@
+// internal/tiling/tiling.go
+
+// Package tiling splits large areas of interest into a grid of smaller
+// analysis requests, runs them concurrently and mosaics their change
+// magnitude grids back into one raster, so a single colour stretch can be
+// applied across the whole area.
+package tiling
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	"geowatch-backend/internal/geo"
+	"geowatch-backend/internal/geotiff"
+)
+
+// Config controls how an area is split and how the tiles are fetched.
+type Config struct {
+	// MaxTileSpanDeg is the largest width and height of a tile in degrees.
+	MaxTileSpanDeg float64
+	// MaxTiles bounds the number of tiles. When the span would need more,
+	// tiles are made larger instead.
+	MaxTiles int
+	// Workers is the number of tiles fetched concurrently.
+	Workers int
+	// MaxMosaicSize bounds the width and height of the mosaic in pixels.
+	// Tiles are downsampled by a power of two to fit.
+	MaxMosaicSize int
+}
+
+// DefaultConfig returns the settings used when nothing else is configured.
+// A 1° tile matches what a 2048 px analysis grid resolves well.
+func DefaultConfig() Config {
+	return Config{
+		MaxTileSpanDeg: 1.0,
+		MaxTiles:       36,
+		Workers:        4,
+		MaxMosaicSize:  4096,
+	}
+}
+
+// Tile is one cell of the grid, counted from the north-west corner.
+type Tile struct {
+	Row, Col int
+	// BBox is [minLon, minLat, maxLon, maxLat].
+	BBox []float64
+}
+
+// Grid is an area split into Rows x Cols equally sized tiles.
+type Grid struct {
+	BBox  []float64
+	Rows  int
+	Cols  int
+	Tiles []Tile
+}
+
+// Split divides bbox into a grid of tiles no larger than MaxTileSpanDeg on
+// either side, or fewer larger tiles if that would exceed MaxTiles. A bbox
+// that already fits yields a single tile.
+func Split(bbox []float64, config Config) (*Grid, error) {
+	if len(bbox) != 4 || bbox[2] <= bbox[0] || bbox[3] <= bbox[1] {
+		return nil, fmt.Errorf("invalid bbox %v", bbox)
+	}
+	width, height := bbox[2]-bbox[0], bbox[3]-bbox[1]
+
+	span := config.MaxTileSpanDeg
+	if span <= 0 {
+		span = math.Max(width, height)
+	}
+	cols := int(math.Ceil(width / span))
+	rows := int(math.Ceil(height / span))
+	if config.MaxTiles > 0 {
+		for cols*rows > config.MaxTiles {
+			span *= 1.25
+			cols = int(math.Ceil(width / span))
+			rows = int(math.Ceil(height / span))
+		}
+	}
+
+	g := &Grid{BBox: bbox, Rows: rows, Cols: cols}
+	// Edges are computed from their index so neighbouring tiles share them exactly.
+	lon := func(c int) float64 { return bbox[0] + width*float64(c)/float64(cols) }
+	lat := func(r int) float64 { return bbox[3] - height*float64(r)/float64(rows) }
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			g.Tiles = append(g.Tiles, Tile{
+				Row:  r,
+				Col:  c,
+				BBox: []float64{lon(c), lat(r + 1), lon(c + 1), lat(r)},
+			})
+		}
+	}
+	return g, nil
+}
+
+// FetchFunc returns the change magnitude of one tile. Band 0 of the image is
+// the magnitude, with NaN for pixels without data. Every tile of a grid must
+// have the same size.
+type FetchFunc func(ctx context.Context, tile Tile) (*geotiff.Image, error)
+
+// Mosaic fetches every tile of the grid with at most Workers running at once
+// and assembles their magnitude bands into one image covering the grid's
+// bbox. The result has the magnitude as band 0 and a valid-pixel mask (1 or
+// 0) as band 1. The first failing tile cancels the rest.
+func Mosaic(ctx context.Context, g *Grid, config Config, fetch FetchFunc) (*geotiff.Image, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var (
+		mu       sync.Mutex
+		mosaic   *geotiff.Image
+		factor   int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	queue := make(chan Tile)
+
+	// place copies a fetched tile into the mosaic, creating the mosaic from
+	// the size of the first tile.
+	place := func(tile Tile, img *geotiff.Image) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if mosaic == nil {
+			factor = downsampleFactor(img.Width*g.Cols, img.Height*g.Rows, config.MaxMosaicSize)
+			mosaic = newMosaic(g, img.Width/factor, img.Height/factor)
+		}
+		cellW, cellH := mosaic.Width/g.Cols, mosaic.Height/g.Rows
+		if img.Width/factor != cellW || img.Height/factor != cellH {
+			return fmt.Errorf("tile %d/%d is %dx%d, expected the same size as the other tiles", tile.Row, tile.Col, img.Width, img.Height)
+		}
+		blit(mosaic, img, tile.Col*cellW, tile.Row*cellH, cellW, cellH, factor)
+		return nil
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tile := range queue {
+				img, err := fetch(ctx, tile)
+				if err == nil {
+					err = place(tile, img)
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("tile %d/%d: %w", tile.Row, tile.Col, err)
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, tile := range g.Tiles {
+		select {
+		case queue <- tile:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := mosaic.SetBBox(g.BBox); err != nil {
+		return nil, err
+	}
+	return mosaic, nil
+}
+
+// Clip sets the mosaic pixels whose centre lies outside the polygon to no
+// data. Tiles are requested for their whole bbox, so without clipping the
+// mosaic would include change outside the area of interest.
+func Clip(mosaic *geotiff.Image, polygon geo.Polygon) {
+	bbox := mosaic.BBox()
+	if len(bbox) != 4 || len(polygon) == 0 {
+		return
+	}
+	magnitude, mask := mosaic.Bands[0], mosaic.Bands[1]
+	nan := float32(math.NaN())
+	for y := 0; y < mosaic.Height; y++ {
+		lat := bbox[3] - (float64(y)+0.5)/float64(mosaic.Height)*(bbox[3]-bbox[1])
+		for x := 0; x < mosaic.Width; x++ {
+			lon := bbox[0] + (float64(x)+0.5)/float64(mosaic.Width)*(bbox[2]-bbox[0])
+			if !polygon.Contains(geo.Point{lon, lat}) {
+				i := y*mosaic.Width + x
+				magnitude[i] = nan
+				mask[i] = 0
+			}
+		}
+	}
+}
+
+// downsampleFactor returns the smallest power of two that brings the full
+// resolution mosaic within maxSize pixels on each side.
+func downsampleFactor(width, height, maxSize int) int {
+	factor := 1
+	if maxSize <= 0 {
+		return factor
+	}
+	for (width/factor > maxSize || height/factor > maxSize) && factor < width && factor < height {
+		factor *= 2
+	}
+	return factor
+}
+
+func newMosaic(g *Grid, cellW, cellH int) *geotiff.Image {
+	w, h := cellW*g.Cols, cellH*g.Rows
+	magnitude := make([]float32, w*h)
+	nan := float32(math.NaN())
+	for i := range magnitude {
+		magnitude[i] = nan
+	}
+	noData := math.NaN()
+	return &geotiff.Image{
+		Width:  w,
+		Height: h,
+		Bands:  [][]float32{magnitude, make([]float32, w*h)},
+		NoData: &noData,
+	}
+}
+
+// blit copies band 0 of img into the mosaic at (x0, y0), averaging blocks of
+// factor x factor pixels and ignoring NaN samples.
+func blit(mosaic, img *geotiff.Image, x0, y0, cellW, cellH, factor int) {
+	magnitude, mask := mosaic.Bands[0], mosaic.Bands[1]
+	src := img.Bands[0]
+	for y := 0; y < cellH; y++ {
+		for x := 0; x < cellW; x++ {
+			var sum float64
+			n := 0
+			for dy := 0; dy < factor; dy++ {
+				row := (y*factor + dy) * img.Width
+				for dx := 0; dx < factor; dx++ {
+					v := src[row+x*factor+dx]
+					if v == v { // not NaN
+						sum += float64(v)
+						n++
+					}
+				}
+			}
+			if n > 0 {
+				i := (y0+y)*mosaic.Width + x0 + x
+				magnitude[i] = float32(sum / float64(n))
+				mask[i] = 1
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// internal/tiling/tiling_test.go
+
+package tiling
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+
+	"geowatch-backend/internal/geo"
+	"geowatch-backend/internal/geotiff"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name       string
+		bbox       []float64
+		config     Config
+		rows, cols int
+	}{
+		{"fits in one tile", []float64{0, 0, 0.5, 0.5}, Config{MaxTileSpanDeg: 1}, 1, 1},
+		{"wide area", []float64{0, 0, 2.5, 1}, Config{MaxTileSpanDeg: 1}, 1, 3},
+		{"tall area", []float64{10, 40, 11, 42}, Config{MaxTileSpanDeg: 1}, 2, 1},
+		{"tile limit grows the tiles", []float64{0, 0, 10, 10}, Config{MaxTileSpanDeg: 1, MaxTiles: 9}, 3, 3},
+		{"no span limit", []float64{0, 0, 5, 5}, Config{}, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := Split(tt.bbox, tt.config)
+			if err != nil {
+				t.Fatalf("Split() failed: %v", err)
+			}
+			if g.Rows != tt.rows || g.Cols != tt.cols || len(g.Tiles) != tt.rows*tt.cols {
+				t.Fatalf("Split() = %dx%d with %d tiles, want %dx%d", g.Rows, g.Cols, len(g.Tiles), tt.rows, tt.cols)
+			}
+			// The tiles cover the bbox exactly, starting in the north-west.
+			first, last := g.Tiles[0].BBox, g.Tiles[len(g.Tiles)-1].BBox
+			if first[0] != tt.bbox[0] || first[3] != tt.bbox[3] || last[2] != tt.bbox[2] || last[1] != tt.bbox[1] {
+				t.Errorf("tiles span %v to %v, want the corners of %v", first, last, tt.bbox)
+			}
+			for i := 1; i < len(g.Tiles); i++ {
+				prev, tile := g.Tiles[i-1], g.Tiles[i]
+				if tile.Row == prev.Row && tile.BBox[0] != prev.BBox[2] {
+					t.Errorf("tile %d/%d doesn't share its western edge with its neighbour", tile.Row, tile.Col)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitRejectsInvalidBBox(t *testing.T) {
+	for _, bbox := range [][]float64{nil, {0, 0, 1}, {1, 0, 0, 1}, {0, 1, 1, 1}} {
+		if _, err := Split(bbox, DefaultConfig()); err == nil {
+			t.Errorf("Split(%v) succeeded, want an error", bbox)
+		}
+	}
+}
+
+func TestDownsampleFactor(t *testing.T) {
+	tests := []struct {
+		width, height, maxSize, want int
+	}{
+		{1000, 1000, 4096, 1},
+		{5000, 1000, 4096, 2},
+		{20000, 3000, 4096, 8},
+		{20000, 20000, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := downsampleFactor(tt.width, tt.height, tt.maxSize); got != tt.want {
+			t.Errorf("downsampleFactor(%d, %d, %d) = %d, want %d", tt.width, tt.height, tt.maxSize, got, tt.want)
+		}
+	}
+}
+
+// constantTile returns a fetch function whose tiles are size x size pixels
+// valued row*10+col, with NaN in the first pixel of every tile.
+func constantTile(size int) FetchFunc {
+	return func(ctx context.Context, tile Tile) (*geotiff.Image, error) {
+		band := make([]float32, size*size)
+		for i := range band {
+			band[i] = float32(tile.Row*10 + tile.Col)
+		}
+		band[0] = float32(math.NaN())
+		return &geotiff.Image{Width: size, Height: size, Bands: [][]float32{band}}, nil
+	}
+}
+
+func TestMosaic(t *testing.T) {
+	g, err := Split([]float64{0, 0, 3, 2}, Config{MaxTileSpanDeg: 1})
+	if err != nil {
+		t.Fatalf("Split() failed: %v", err)
+	}
+	mosaic, err := Mosaic(context.Background(), g, Config{Workers: 3}, constantTile(4))
+	if err != nil {
+		t.Fatalf("Mosaic() failed: %v", err)
+	}
+	if mosaic.Width != 12 || mosaic.Height != 8 || len(mosaic.Bands) != 2 {
+		t.Fatalf("mosaic is %dx%d with %d bands, want 12x8 with 2", mosaic.Width, mosaic.Height, len(mosaic.Bands))
+	}
+	for _, tt := range []struct {
+		x, y  int
+		value float32
+		valid bool
+	}{
+		{1, 1, 0, true},   // tile 0/0
+		{9, 1, 2, true},   // tile 0/2
+		{5, 6, 11, true},  // tile 1/1
+		{4, 4, 0, false},  // first pixel of tile 1/1
+		{11, 7, 12, true}, // south-east corner
+	} {
+		i := tt.y*mosaic.Width + tt.x
+		v, mask := mosaic.Bands[0][i], mosaic.Bands[1][i]
+		if tt.valid && (v != tt.value || mask != 1) || !tt.valid && (!math.IsNaN(float64(v)) || mask != 0) {
+			t.Errorf("pixel (%d, %d) = %v with mask %v, want %v valid %v", tt.x, tt.y, v, mask, tt.value, tt.valid)
+		}
+	}
+	if got := mosaic.BBox(); got[0] != 0 || got[1] != 0 || got[2] != 3 || got[3] != 2 {
+		t.Errorf("mosaic bbox = %v, want [0 0 3 2]", got)
+	}
+}
+
+func TestMosaicDownsamples(t *testing.T) {
+	g, _ := Split([]float64{0, 0, 2, 1}, Config{MaxTileSpanDeg: 1})
+	mosaic, err := Mosaic(context.Background(), g, Config{MaxMosaicSize: 8}, constantTile(8))
+	if err != nil {
+		t.Fatalf("Mosaic() failed: %v", err)
+	}
+	if mosaic.Width != 8 || mosaic.Height != 4 {
+		t.Fatalf("mosaic is %dx%d, want 8x4", mosaic.Width, mosaic.Height)
+	}
+	// The NaN pixel is averaged away with its valid neighbours.
+	if v := mosaic.Bands[0][0]; v != 0 || mosaic.Bands[1][0] != 1 {
+		t.Errorf("first pixel = %v, want 0", v)
+	}
+}
+
+func TestMosaicStopsOnError(t *testing.T) {
+	g, _ := Split([]float64{0, 0, 6, 6}, Config{MaxTileSpanDeg: 1})
+	failure := errors.New("earth engine failed")
+	var calls atomic.Int32
+	_, err := Mosaic(context.Background(), g, Config{Workers: 1}, func(ctx context.Context, tile Tile) (*geotiff.Image, error) {
+		calls.Add(1)
+		if tile.Row == 0 && tile.Col == 1 {
+			return nil, failure
+		}
+		return constantTile(2)(ctx, tile)
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Mosaic() error = %v, want the tile's error", err)
+	}
+	if n := calls.Load(); n >= int32(len(g.Tiles)) {
+		t.Errorf("all %d tiles were fetched after the failure", n)
+	}
+}
+
+func TestMosaicRejectsMismatchedTiles(t *testing.T) {
+	g, _ := Split([]float64{0, 0, 2, 1}, Config{MaxTileSpanDeg: 1})
+	_, err := Mosaic(context.Background(), g, Config{Workers: 1}, func(ctx context.Context, tile Tile) (*geotiff.Image, error) {
+		return constantTile(2+tile.Col)(ctx, tile)
+	})
+	if err == nil {
+		t.Fatal("Mosaic() of differently sized tiles succeeded")
+	}
+}
+
+func TestClip(t *testing.T) {
+	g, _ := Split([]float64{0, 0, 4, 4}, Config{MaxTileSpanDeg: 2})
+	mosaic, err := Mosaic(context.Background(), g, Config{}, func(ctx context.Context, tile Tile) (*geotiff.Image, error) {
+		band := make([]float32, 4)
+		return &geotiff.Image{Width: 2, Height: 2, Bands: [][]float32{band}}, nil
+	})
+	if err != nil {
+		t.Fatalf("Mosaic() failed: %v", err)
+	}
+	// The western half, minus a hole over the pixel at column 0, row 1.
+	Clip(mosaic, geo.Polygon{
+		{{0, 0}, {2, 0}, {2, 4}, {0, 4}, {0, 0}},
+		{{0, 2}, {0, 3}, {1, 3}, {1, 2}, {0, 2}},
+	})
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			i := y*4 + x
+			want := x < 2 && !(x == 0 && y == 1)
+			if valid := mosaic.Bands[1][i] == 1 && !math.IsNaN(float64(mosaic.Bands[0][i])); valid != want {
+				t.Errorf("pixel (%d, %d) valid = %v, want %v", x, y, valid, want)
+			}
+		}
+	}
+}
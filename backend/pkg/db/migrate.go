@@ -0,0 +1,240 @@
+// pkg/db/migrate.go
+
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The SQL migrations are embedded in the binary. Each version has an
+// "NNNN_name.up.sql" file and a matching "NNNN_name.down.sql" file.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock held while migrating, so two
+// backends starting at the same time don't apply the same migration twice.
+const migrationLockID = 727274
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState reports whether a migration has been applied.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// LoadMigrations reads the embedded migrations, sorted by version.
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionStr, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name.%s.sql", name, direction)
+		}
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version: %w", name, err)
+		}
+		content, err := migrationFiles.ReadFile("migrations/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", name, err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp applies every migration that hasn't been applied yet, each in its
+// own transaction.
+func MigrateUp(ctx context.Context, pool *pgxpool.Pool) error {
+	return withMigrationLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		migrations, applied, err := migrationPlan(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		count := 0
+		for _, m := range migrations {
+			if _, done := applied[m.Version]; done {
+				continue
+			}
+			fmt.Printf("INFO: Applying migration %04d_%s...\n", m.Version, m.Name)
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			count++
+		}
+
+		if count == 0 {
+			fmt.Println("INFO: Database schema is up to date.")
+		} else {
+			fmt.Printf("INFO: Applied %d migration(s).\n", count)
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the most recently applied migrations, newest first.
+func MigrateDown(ctx context.Context, pool *pgxpool.Pool, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("number of migrations to revert must be positive, got %d", steps)
+	}
+	return withMigrationLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		migrations, applied, err := migrationPlan(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, done := applied[m.Version]; !done {
+				continue
+			}
+			fmt.Printf("INFO: Reverting migration %04d_%s...\n", m.Version, m.Name)
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reverting migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrationStatus lists all known migrations and when each was applied.
+func MigrationStatus(ctx context.Context, pool *pgxpool.Pool) ([]MigrationState, error) {
+	var states []MigrationState
+	err := withMigrationLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		migrations, applied, err := migrationPlan(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			state := MigrationState{Migration: m}
+			if at, ok := applied[m.Version]; ok {
+				state.AppliedAt = &at
+			}
+			states = append(states, state)
+		}
+		return nil
+	})
+	return states, err
+}
+
+// withMigrationLock runs fn on a dedicated connection while holding the
+// migration advisory lock.
+func withMigrationLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection for migrations: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	return fn(conn)
+}
+
+// migrationPlan makes sure the schema_migrations table exists and returns the
+// embedded migrations along with the versions already applied.
+func migrationPlan(ctx context.Context, conn *pgxpool.Conn) ([]Migration, map[int64]time.Time, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    BIGINT PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		applied[version] = at
+	}
+	return migrations, applied, rows.Err()
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS analysis_jobs;
+DROP TABLE IF EXISTS change_events;
+DROP TABLE IF EXISTS locations;
@@ -0,0 +1,79 @@
+-- Initial GeoWatch schema: monitored locations, detected change events and
+-- background analysis jobs. All geometries are WGS84 (EPSG:4326).
+
+CREATE EXTENSION IF NOT EXISTS postgis;
+
+CREATE TABLE IF NOT EXISTS locations (
+    id               SERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    geom             geometry(Geometry, 4326) NOT NULL,
+    monitor_enabled  BOOLEAN NOT NULL DEFAULT FALSE,
+    monitor_interval INTERVAL NOT NULL DEFAULT INTERVAL '5 days',
+    last_run_at      TIMESTAMPTZ,
+    baseline_date    TIMESTAMPTZ,
+    last_run_error   TEXT,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Deployments from before migrations created locations and change_events by
+-- hand, and CREATE TABLE IF NOT EXISTS leaves such a table as it is. Add every
+-- column the backend uses to bring them up to date; columns without a default
+-- can only be added as nullable to a table that already has rows.
+ALTER TABLE locations
+    ADD COLUMN IF NOT EXISTS name             TEXT,
+    ADD COLUMN IF NOT EXISTS geom             geometry(Geometry, 4326),
+    ADD COLUMN IF NOT EXISTS monitor_enabled  BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS monitor_interval INTERVAL NOT NULL DEFAULT INTERVAL '5 days',
+    ADD COLUMN IF NOT EXISTS last_run_at      TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS baseline_date    TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS last_run_error   TEXT,
+    ADD COLUMN IF NOT EXISTS created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS locations_geom_idx ON locations USING GIST (geom);
+CREATE INDEX IF NOT EXISTS locations_name_idx ON locations (lower(name));
+-- Lets the scheduler find due locations without scanning unmonitored ones.
+CREATE INDEX IF NOT EXISTS locations_monitor_due_idx ON locations (last_run_at) WHERE monitor_enabled;
+
+CREATE TABLE IF NOT EXISTS change_events (
+    id             SERIAL PRIMARY KEY,
+    location_id    INTEGER NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+    event_type     TEXT NOT NULL,
+    description    TEXT NOT NULL DEFAULT '',
+    detected_at    TIMESTAMPTZ NOT NULL,
+    severity       INTEGER NOT NULL DEFAULT 0,
+    area_sq_m      DOUBLE PRECISION,
+    mean_magnitude DOUBLE PRECISION,
+    geom           geometry(Geometry, 4326) NOT NULL
+);
+
+ALTER TABLE change_events
+    ADD COLUMN IF NOT EXISTS location_id    INTEGER REFERENCES locations (id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS event_type     TEXT,
+    ADD COLUMN IF NOT EXISTS description    TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS detected_at    TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS severity       INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS area_sq_m      DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS mean_magnitude DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS geom           geometry(Geometry, 4326);
+
+CREATE INDEX IF NOT EXISTS change_events_geom_idx ON change_events USING GIST (geom);
+CREATE INDEX IF NOT EXISTS change_events_detected_at_idx ON change_events (detected_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS change_events_location_id_idx ON change_events (location_id);
+
+CREATE TABLE IF NOT EXISTS analysis_jobs (
+    id           TEXT PRIMARY KEY,
+    status       TEXT NOT NULL CHECK (status IN ('queued', 'running', 'succeeded', 'failed')),
+    progress     INTEGER NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100),
+    message      TEXT,
+    request      JSONB NOT NULL,
+    result_path  TEXT,
+    content_type TEXT,
+    error        TEXT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at   TIMESTAMPTZ,
+    finished_at  TIMESTAMPTZ
+);
+
+-- Unfinished jobs are resumed at startup.
+CREATE INDEX IF NOT EXISTS analysis_jobs_unfinished_idx ON analysis_jobs (created_at) WHERE status IN ('queued', 'running');
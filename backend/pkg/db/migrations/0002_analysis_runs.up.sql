@@ -0,0 +1,12 @@
+-- One row per served analysis, with the service's description of how the
+-- result was produced (composites, scenes, stretch and bounds).
+CREATE TABLE IF NOT EXISTS analysis_runs (
+    id           TEXT PRIMARY KEY,
+    request      JSONB NOT NULL,
+    content_type TEXT NOT NULL,
+    cache_hit    BOOLEAN NOT NULL DEFAULT FALSE,
+    metadata     JSONB,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS analysis_runs_created_at_idx ON analysis_runs (created_at DESC);
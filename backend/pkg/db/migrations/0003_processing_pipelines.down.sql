@@ -0,0 +1,2 @@
+ALTER TABLE change_events DROP COLUMN IF EXISTS pipeline;
+ALTER TABLE locations DROP COLUMN IF EXISTS pipeline;
@@ -0,0 +1,4 @@
+-- Processing pipelines: declared per location, and recorded with each change
+-- event as it ran so the event can be reproduced.
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS pipeline JSONB;
+ALTER TABLE change_events ADD COLUMN IF NOT EXISTS pipeline JSONB;
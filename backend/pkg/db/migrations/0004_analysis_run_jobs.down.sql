@@ -0,0 +1 @@
+ALTER TABLE analysis_runs DROP COLUMN IF EXISTS job_id;
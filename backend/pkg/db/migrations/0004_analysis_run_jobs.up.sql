@@ -0,0 +1,7 @@
+-- Runs record the job that produced them, so only a job's own run links to
+-- its stored result and only a resumed job may replace an existing run.
+ALTER TABLE analysis_runs ADD COLUMN IF NOT EXISTS job_id TEXT;
+
+UPDATE analysis_runs r SET job_id = j.id
+FROM analysis_jobs j
+WHERE j.id = r.id AND r.job_id IS NULL;
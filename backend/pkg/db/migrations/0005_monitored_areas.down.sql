@@ -0,0 +1 @@
+ALTER TABLE locations DROP CONSTRAINT IF EXISTS locations_monitored_area_check;
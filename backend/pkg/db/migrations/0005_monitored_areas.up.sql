@@ -0,0 +1,5 @@
+-- Scheduled monitoring compares images of a location's bounding box, which is
+-- empty for points and lines, so only areal locations can be monitored.
+UPDATE locations SET monitor_enabled = FALSE WHERE monitor_enabled AND ST_Area(geom) = 0;
+ALTER TABLE locations ADD CONSTRAINT locations_monitored_area_check
+    CHECK (NOT monitor_enabled OR ST_Area(geom) > 0);
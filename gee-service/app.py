@@ -7,6 +7,8 @@ import requests # Make sure you have run 'pip install requests'
 from flask import Response
 
 import json # We will need this to handle the computation graph
+import io
+import numpy as np
 
 
 # --- Initialization ---
@@ -64,10 +66,22 @@ def analyze_changes():
             band_names = composite.bandNames().getInfo()
             if not band_names: raise ValueError(f"No cloud-free images found for {year}.")
             ndvi = composite.normalizedDifference([nir_band, red_band]).rename('ndvi'); ndwi = composite.normalizedDifference([green_band, nir_band]).rename('ndwi'); ndbi = composite.normalizedDifference([swir1_band, nir_band]).rename('ndbi')
-            return composite.addBands([ndvi, ndwi, ndbi])
+            # What went into the composite, so a result can be reproduced.
+            scene_ids = collection.aggregate_array('system:index').getInfo()
+            info = {
+                'year': year,
+                'collection': collection_name,
+                'start_date': f"{year}-07-01",
+                'end_date': f"{year}-07-15",
+                'scene_count': len(scene_ids),
+                'scene_ids': scene_ids,
+            }
+            return composite.addBands([ndvi, ndwi, ndbi]), info
 
-        image_before = create_analysis_composite(start_year).select(['ndvi', 'ndwi', 'ndbi'])
-        image_after = create_analysis_composite(end_year).select(['ndvi', 'ndwi', 'ndbi'])
+        image_before, before_info = create_analysis_composite(start_year)
+        image_after, after_info = create_analysis_composite(end_year)
+        image_before = image_before.select(['ndvi', 'ndwi', 'ndbi'])
+        image_after = image_after.select(['ndvi', 'ndwi', 'ndbi'])
         
         diff = image_after.subtract(image_before); squared_diff = diff.pow(2)
         change_magnitude = squared_diff.reduce(ee.Reducer.sum()).rename('change_sum')
@@ -96,7 +110,46 @@ def analyze_changes():
         scale_y = (min_lat - max_lat) / grid_dimensions
         translate_x = min_lon
         translate_y = max_lat
-        
+
+        # Sent with every result in the X-Analysis-Metadata header.
+        palette = ['0000FF', 'FFFF00', 'FFA500', 'FF0000']
+        metadata = {
+            'before': before_info,
+            'after': after_info,
+            'bbox': [min_lon, min_lat, max_lon, max_lat],
+            'width': grid_dimensions,
+            'height': grid_dimensions,
+            'crs': 'EPSG:4326',
+        }
+        if min_val is not None and p98_val is not None:
+            metadata['stretch'] = {'min': min_val, 'p98': p98_val}
+
+        # The Go backend asks for the raw magnitude to write a GeoTIFF itself.
+        # Pixels outside the AOI or without data are sent as -1.
+        if data.get('output') == 'magnitude':
+            magnitude_params = {
+                'expression': change_magnitude.clip(aoi).unmask(-1).toFloat(),
+                'fileFormat': 'NPY',
+                'grid': {
+                    'dimensions': { 'width': grid_dimensions, 'height': grid_dimensions },
+                    'affineTransform': {
+                        'scaleX': scale_x, 'shearX': 0, 'translateX': translate_x,
+                        'shearY': 0, 'scaleY': scale_y, 'translateY': translate_y
+                    },
+                    'crsCode': 'EPSG:4326'
+                },
+                'bandIds': ['change_sum'],
+            }
+            npy_data = ee.data.computePixels(magnitude_params)
+            grid = np.load(io.BytesIO(npy_data))['change_sum'].astype('<f4')
+            print("...magnitude grid received.")
+            return Response(grid.tobytes(), mimetype='application/octet-stream', headers={
+                'X-Grid-Width': str(grid.shape[1]),
+                'X-Grid-Height': str(grid.shape[0]),
+                'X-Grid-BBox': f"{min_lon},{min_lat},{max_lon},{max_lat}",
+                'X-Analysis-Metadata': json.dumps(metadata, separators=(',', ':')),
+            })
+
         request_params = {
             'expression': change_magnitude.selfMask(),
             'fileFormat': 'PNG',
@@ -111,15 +164,23 @@ def analyze_changes():
             'bandIds': ['change_sum'],
             'visualizationOptions': {
                 'ranges': [{'min': min_val, 'max': p98_val}], # Use a slightly smaller min to ensure visibility
-                'paletteColors': ['0000FF', 'FFFF00', 'FFA500', 'FF0000']
+                'paletteColors': palette
             }
         }
         
         pixel_data = ee.data.computePixels(request_params)
         print("...PNG data received.")
         
-        return Response(pixel_data, mimetype='image/png')
-
+        metadata['palette'] = palette
+        return Response(pixel_data, mimetype='image/png', headers={
+            'X-Analysis-Metadata': json.dumps(metadata, separators=(',', ':')),
+        })
+
+    except ValueError as e:
+        # Raised for requests that can't be served, such as years without
+        # imagery. These are client errors and retrying won't help.
+        print(f"ERROR: Invalid analysis request: {e}")
+        return jsonify({"error": str(e)}), 400
     except Exception as e:
         print(f"ERROR: A GEE error occurred: {e}")
         return jsonify({"error": f"An error occurred during GEE analysis: {str(e)}"}), 500